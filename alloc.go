@@ -0,0 +1,86 @@
+package lua
+
+import (
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// Allocator is a Lua memory allocation function, a lua_Alloc C function pointer, with its opaque pointer.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_Alloc
+type Allocator struct {
+	Func     uintptr
+	UserData uintptr
+}
+
+// Alloc calls the allocation function. When nsize is zero, it frees ptr and returns nil.
+// Otherwise it allocates or reallocates a block of nsize bytes, returning nil if it cannot fulfill the request.
+// When ptr is nil, osize encodes the kind of object being allocated.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_Alloc
+func (a Allocator) Alloc(ptr unsafe.Pointer, osize, nsize int) unsafe.Pointer {
+	r, _, _ := purego.SyscallN(a.Func, a.UserData, uintptr(ptr), uintptr(osize), uintptr(nsize))
+	// The block is allocated by C, outside of the Go heap.
+	return *(*unsafe.Pointer)(unsafe.Pointer(&r))
+}
+
+// AllocFunc is a Go allocation function wrapping the allocator next, which it may call with next.Alloc.
+// It has the semantics of lua_Alloc and must not panic.
+type AllocFunc func(next Allocator, ptr unsafe.Pointer, osize, nsize int) unsafe.Pointer
+
+// wrappedAlloc is the Go side of an allocator installed by WrapAlloc.
+type wrappedAlloc struct {
+	next Allocator
+	fn   AllocFunc
+}
+
+// allocTrampoline is the lua_Alloc of the allocators installed by WrapAlloc,
+// its opaque pointer is the Handle of a wrappedAlloc.
+var allocTrampoline = purego.NewCallback(func(ud uintptr, ptr unsafe.Pointer, osize, nsize int) unsafe.Pointer {
+	w := Handle(ud).Value().(*wrappedAlloc)
+	return w.fn(w.next, ptr, osize, nsize)
+})
+
+// GetAllocF returns the memory allocation function of the state,
+// which is shared by the main state and all of its threads.
+// It works for the states created with or without WithAlloc, as well as for those given to BuildState.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_getallocf
+func (s *State) GetAllocF() Allocator {
+	var ud uintptr
+	f := s.rt.ffi.LuaGetallocf(s.luaL, &ud)
+	return Allocator{Func: f, UserData: ud}
+}
+
+// SetAllocF changes the memory allocation function of the state.
+// The new allocator is given the blocks allocated by the previous one,
+// so it must be able to reallocate and free them, usually by delegating to it.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_setallocf
+func (s *State) SetAllocF(a Allocator) {
+	s.rt.ffi.LuaSetallocf(s.luaL, a.Func, a.UserData)
+}
+
+// WrapAlloc replaces the memory allocation function of the state with fn,
+// which is given the current allocator to delegate to, such as for accounting, tracing or fault injection.
+// Wrapping an allocator does not need a new purego callback, so it may be done for any number of states.
+// The wrapper is released once the main state is closed by Close.
+func (s *State) WrapAlloc(fn AllocFunc) {
+	w := &wrappedAlloc{next: s.GetAllocF(), fn: fn}
+	h := NewHandle(w)
+
+	g := s.canonical().group
+	g.mu.Lock()
+	g.allocs = append(g.allocs, h)
+	g.mu.Unlock()
+
+	s.SetAllocF(Allocator{Func: allocTrampoline, UserData: uintptr(h)})
+}
+
+// releaseAllocs deletes the handles of the allocators installed by WrapAlloc, once the state is closed.
+func (g *stateGroup) releaseAllocs() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, h := range g.allocs {
+		h.Delete()
+	}
+	g.allocs = nil
+}
@@ -0,0 +1,74 @@
+package lua_test
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestGetSetAllocF(assert *require.Assertions, L *lua.State) {
+	a := L.GetAllocF()
+	assert.NotZero(a.Func)
+
+	built := lua.BuildState(L.L())
+	assert.Equal(a, built.GetAllocF())
+
+	var calls int
+	L.WrapAlloc(func(next lua.Allocator, ptr unsafe.Pointer, osize, nsize int) unsafe.Pointer {
+		calls++
+		return next.Alloc(ptr, osize, nsize)
+	})
+	assert.NotEqual(a, L.GetAllocF())
+	assert.NoError(L.DoString(`local t = {} for i = 1, 100 do t[i] = tostring(i) end`))
+	assert.Positive(calls)
+
+	L.SetAllocF(a)
+	calls = 0
+	assert.NoError(L.DoString(`local t = {} for i = 1, 100 do t[i] = tostring(i) end`))
+	assert.Zero(calls)
+}
+
+func (s *Suite) TestWrapAlloc(assert *require.Assertions, t *testing.T) {
+	L := lua.NewState()
+	L.OpenLibs()
+	defer L.Close()
+
+	var (
+		inUse int
+		fail  bool
+	)
+	L.WrapAlloc(func(next lua.Allocator, ptr unsafe.Pointer, osize, nsize int) unsafe.Pointer {
+		if ptr == nil {
+			// osize is the kind of object being allocated
+			osize = 0
+		}
+		if fail && nsize > osize {
+			return nil
+		}
+		p := next.Alloc(ptr, osize, nsize)
+		if p != nil || nsize == 0 {
+			inUse += nsize - osize
+		}
+		return p
+	})
+
+	assert.NoError(L.DoString(`big = string.rep("x", 1 << 20)`))
+	assert.Greater(inUse, 1<<20)
+
+	L.PushNil()
+	L.SetGlobal("big")
+	assert.NoError(L.DoString(`collectgarbage()`))
+	assert.Less(inUse, 1<<20)
+
+	fail = true
+	err := L.DoString(`local s = string.rep("y", 1 << 20)`)
+	fail = false
+	assert.Error(err)
+	assert.Contains(err.Error(), "not enough memory")
+
+	thread := L.NewThread()
+	assert.Equal(L.GetAllocF(), thread.GetAllocF())
+	L.Pop(1)
+}
@@ -0,0 +1,172 @@
+package lua_test
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+	"go.yuchanns.xyz/lua/luatest"
+)
+
+// The benchmarks report the allocations of the binding together with the ones of purego,
+// which allocates the arguments of every foreign call made through RegisterLibFunc.
+// Keys such as field and global names are interned, strings are pushed without a copy,
+// and callbacks reuse the canonical State of the calling thread, so none of them add to that floor,
+// which TestBindingAllocs checks against the same foreign calls made directly.
+
+// benchmarkState opens a runtime of its own, since benchmarks run outside of the Suite.
+func benchmarkState(tb testing.TB) *lua.State {
+	rt, err := lua.OpenAuto(lua.Want(luatest.Version()))
+	if err != nil {
+		tb.Skip(err)
+	}
+	L := rt.NewState()
+	L.OpenLibs()
+	tb.Cleanup(func() {
+		L.Close()
+		_ = rt.Close()
+	})
+	return L
+}
+
+// TestBindingAllocs checks that the hot paths of the binding allocate no more than the foreign calls
+// they make, whose arguments are allocated by purego.
+// It is not run in parallel, as the allocations are counted for the whole process.
+func TestBindingAllocs(t *testing.T) {
+	assert := require.New(t)
+	L := benchmarkState(t)
+	ffi := L.Runtime().FFI()
+	key := []byte("answer\x00")
+	value := "a value which is not a constant key"
+
+	L.NewTable()
+	L.PushInteger(42)
+	L.SetField(-2, "answer")
+
+	const runs = 100
+	equalAllocs := func(name string, binding, direct func()) {
+		assert.Equal(testing.AllocsPerRun(runs, direct), testing.AllocsPerRun(runs, binding), name)
+	}
+
+	equalAllocs("GetField", func() {
+		L.GetField(-1, "answer")
+		L.Pop(1)
+	}, func() {
+		ffi.LuaGetfield(L.L(), -1, &key[0])
+		L.Pop(1)
+	})
+	equalAllocs("SetField", func() {
+		L.PushInteger(42)
+		L.SetField(-2, "answer")
+	}, func() {
+		L.PushInteger(42)
+		ffi.LuaSetfield(L.L(), -2, &key[0])
+	})
+	equalAllocs("PushString", func() {
+		L.PushString(value)
+		L.Pop(1)
+	}, func() {
+		ffi.LuaPushlstring(L.L(), unsafe.StringData(value), len(value))
+		L.Pop(1)
+	})
+	if L.Version() >= 503 {
+		global := []byte("string\x00")
+		equalAllocs("GetGlobal", func() {
+			L.GetGlobal("string")
+			L.Pop(1)
+		}, func() {
+			ffi.LuaGetglobal(L.L(), &global[0])
+			L.Pop(1)
+		})
+	}
+
+	binding := L.Runtime().NewCallback(func(L *lua.State) int {
+		L.PushInteger(42)
+		return 1
+	})
+	direct := purego.NewCallback(func(L unsafe.Pointer) int {
+		ffi.LuaPushinteger(L, 42)
+		return 1
+	})
+	call := func(fn uintptr) func() {
+		return func() {
+			L.PushCFunction(fn)
+			L.Call(0, 1)
+			L.Pop(1)
+		}
+	}
+	equalAllocs("Callback", call(binding), call(direct))
+}
+
+func BenchmarkGetField(b *testing.B) {
+	L := benchmarkState(b)
+	L.NewTable()
+	L.PushInteger(42)
+	L.SetField(-2, "answer")
+
+	b.ReportAllocs()
+	for b.Loop() {
+		L.GetField(-1, "answer")
+		L.Pop(1)
+	}
+}
+
+func BenchmarkSetField(b *testing.B) {
+	L := benchmarkState(b)
+	L.NewTable()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		L.PushInteger(42)
+		L.SetField(-2, "answer")
+	}
+}
+
+func BenchmarkGetGlobal(b *testing.B) {
+	L := benchmarkState(b)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		L.GetGlobal("string")
+		L.Pop(1)
+	}
+}
+
+func BenchmarkNewMetaTable(b *testing.B) {
+	L := benchmarkState(b)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		L.NewMetaTable("bench.meta")
+		L.Pop(1)
+	}
+}
+
+func BenchmarkPushString(b *testing.B) {
+	L := benchmarkState(b)
+	value := "a value which is not a constant key"
+
+	b.ReportAllocs()
+	for b.Loop() {
+		L.PushString(value)
+		L.Pop(1)
+	}
+}
+
+func BenchmarkCallback(b *testing.B) {
+	L := benchmarkState(b)
+	fn := L.Runtime().NewCallback(func(L *lua.State) int {
+		L.PushInteger(L.ToInteger(1) + 1)
+		return 1
+	})
+
+	b.ReportAllocs()
+	for b.Loop() {
+		L.PushCFunction(fn)
+		L.PushInteger(41)
+		L.Call(1, 1)
+		L.Pop(1)
+	}
+}
@@ -0,0 +1,150 @@
+package lua
+
+import (
+	"unsafe"
+)
+
+// luaLBuffer mirrors the fields of luaL_Buffer shared by Lua 5.3 and later,
+// which are followed by the initial storage of the buffer.
+type luaLBuffer struct {
+	b    *byte
+	size uintptr
+	n    uintptr
+	L    unsafe.Pointer
+}
+
+// bufferSize returns the size of luaL_Buffer for the Lua version, which is the size of the fields
+// followed by LUAL_BUFFERSIZE bytes, as defined by the default luaconf.h of each version.
+func (ffi *ffi) bufferSize() int {
+	header := int(unsafe.Sizeof(luaLBuffer{}))
+	if ffi.version < 504 {
+		// 0x80 * sizeof(void*) * sizeof(lua_Integer)
+		return header + 0x80*int(unsafe.Sizeof(uintptr(0)))*8
+	}
+	// 16 * sizeof(void*) * sizeof(lua_Number)
+	return header + 16*int(unsafe.Sizeof(uintptr(0)))*8
+}
+
+// Buffer is a string buffer which builds Lua strings piecemeal with the memory of the Lua allocator.
+// It wraps luaL_Buffer, which is kept in a userdata pushed onto the stack by BuffInit,
+// so that it is collected by Lua even if an error interrupts the building of the string.
+//
+// While a buffer is in use, it uses a variable number of stack slots above that userdata.
+// So, the stack must be at the same level at each buffer operation as it was after the previous one,
+// except AddValue which expects the value to add on top of it.
+// PushResult leaves the resulting string in place of the userdata.
+//
+// The luaL_Buffer of Lua 5.1 and LuaJIT has another layout and is not supported.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_Buffer
+type Buffer struct {
+	L *State
+	b *luaLBuffer
+}
+
+// newBuffer pushes the userdata holding a luaL_Buffer onto the stack.
+func (s *State) newBuffer() *Buffer {
+	if s.rt.ffi.LuaLBuffinit == nil {
+		panic(s.rt.ffi.unsupported("luaL_Buffer"))
+	}
+	p := s.NewUserData(s.rt.ffi.bufferSize())
+	return &Buffer{L: s, b: (*luaLBuffer)(p)}
+}
+
+// BuffInit pushes a new buffer onto the stack and initializes it.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_buffinit
+func (s *State) BuffInit() *Buffer {
+	B := s.newBuffer()
+	s.rt.ffi.LuaLBuffinit(s.luaL, unsafe.Pointer(B.b))
+	return B
+}
+
+// BuffInitSize pushes a new buffer onto the stack, initializes it and returns a slice of sz bytes
+// to be filled with the contents of the string. The result is pushed with PushResultSize.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_buffinitsize
+func (s *State) BuffInitSize(sz int) (*Buffer, []byte) {
+	B := s.newBuffer()
+	p := s.rt.ffi.LuaLBuffinitsize(s.luaL, unsafe.Pointer(B.b), sz)
+	return B, unsafe.Slice(p, sz)
+}
+
+// PrepBuffSize returns a slice of sz bytes where the next bytes can be copied before they are added with AddSize.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_prepbuffsize
+func (B *Buffer) PrepBuffSize(sz int) []byte {
+	p := B.L.rt.ffi.LuaLPrepbuffsize(unsafe.Pointer(B.b), sz)
+	return unsafe.Slice(p, sz)
+}
+
+// AddSize adds to the buffer n bytes previously copied to the slice returned by PrepBuffSize.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_addsize
+func (B *Buffer) AddSize(n int) {
+	B.b.n += uintptr(n)
+}
+
+// Sub removes n bytes from the end of the buffer.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_buffsub
+func (B *Buffer) Sub(n int) {
+	B.b.n -= uintptr(n)
+}
+
+// AddByte adds the byte c to the buffer.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_addchar
+func (B *Buffer) AddByte(c byte) {
+	if B.b.n >= B.b.size {
+		B.L.rt.ffi.LuaLPrepbuffsize(unsafe.Pointer(B.b), 1)
+	}
+	*(*byte)(unsafe.Add(unsafe.Pointer(B.b.b), B.b.n)) = c
+	B.b.n++
+}
+
+// AddString adds the string str to the buffer. The string may contain embedded zeros.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_addlstring
+func (B *Buffer) AddString(str string) {
+	B.L.rt.ffi.LuaLAddlstring(unsafe.Pointer(B.b), unsafe.StringData(str), len(str))
+}
+
+// AddBytes adds the bytes of p to the buffer.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_addlstring
+func (B *Buffer) AddBytes(p []byte) {
+	B.L.rt.ffi.LuaLAddlstring(unsafe.Pointer(B.b), unsafe.SliceData(p), len(p))
+}
+
+// AddValue adds the value on the top of the stack to the buffer and pops it.
+// The value must be a string or a number.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_addvalue
+func (B *Buffer) AddValue() {
+	B.L.rt.ffi.LuaLAddvalue(unsafe.Pointer(B.b))
+}
+
+// Len returns the length of the current content of the buffer.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_bufflen
+func (B *Buffer) Len() int {
+	return int(B.b.n)
+}
+
+// Bytes returns the current content of the buffer.
+// The slice refers to the memory of the buffer, it is valid until the next buffer operation.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_buffaddr
+func (B *Buffer) Bytes() []byte {
+	return unsafe.Slice(B.b.b, B.b.n)
+}
+
+// PushResult finishes the use of the buffer, leaving the final string on the top of the stack
+// in place of the buffer. The buffer must not be used afterwards.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_pushresult
+func (B *Buffer) PushResult() {
+	B.L.rt.ffi.LuaLPushresult(unsafe.Pointer(B.b))
+	B.finish()
+}
+
+// PushResultSize is equivalent to AddSize followed by PushResult.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_pushresultsize
+func (B *Buffer) PushResultSize(sz int) {
+	B.L.rt.ffi.LuaLPushresultsize(unsafe.Pointer(B.b), sz)
+	B.finish()
+}
+
+// finish removes the userdata holding the buffer, below the resulting string.
+func (B *Buffer) finish() {
+	B.L.Remove(-2)
+	B.b = nil
+}
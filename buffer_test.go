@@ -0,0 +1,77 @@
+package lua_test
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestBuffer(assert *require.Assertions, L *lua.State) {
+	if L.Version() < 503 {
+		assert.Panics(func() { L.BuffInit() })
+		return
+	}
+
+	B := L.BuffInit()
+	B.AddString("hello")
+	B.AddByte(',')
+	B.AddBytes([]byte(" world\x00"))
+	L.PushInteger(42)
+	B.AddValue()
+	assert.Equal(len("hello, world\x0042"), B.Len())
+	assert.Equal("hello, world\x0042", string(B.Bytes()))
+	B.Sub(2)
+	B.PushResult()
+	assert.Equal(1, L.GetTop())
+	assert.Equal("hello, world\x00", L.ToStringMeta(-1))
+	L.Pop(2)
+
+	// Beyond the initial storage of the buffer
+	large := strings.Repeat("0123456789", 10000)
+	B = L.BuffInit()
+	for i := range large {
+		B.AddByte(large[i])
+	}
+	B.AddString(large)
+	p := B.PrepBuffSize(3)
+	copy(p, "end")
+	B.AddSize(3)
+	B.PushResult()
+	assert.Equal(1, L.GetTop())
+	assert.Equal(large+large+"end", L.ToStringMeta(-1))
+	L.Pop(2)
+
+	B, p = L.BuffInitSize(4)
+	copy(p, "lua!")
+	B.PushResultSize(4)
+	assert.Equal(1, L.GetTop())
+	assert.Equal("lua!", L.ToStringMeta(-1))
+	L.Pop(2)
+}
+
+func (s *Suite) TestBufferInCallback(assert *require.Assertions, L *lua.State) {
+	if L.Version() < 503 {
+		return
+	}
+
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		n := int(L.CheckInteger(1))
+		B := L.BuffInit()
+		for i := range n {
+			if i > 0 {
+				B.AddString(", ")
+			}
+			L.PushInteger(int64(i))
+			B.AddValue()
+		}
+		B.PushResult()
+		return 1
+	}))
+	L.SetGlobal("join")
+
+	assert.NoError(L.DoString(`
+		assert(join(3) == "0, 1, 2")
+		assert(#join(2000) > 8192)
+	`))
+}
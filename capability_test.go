@@ -0,0 +1,44 @@
+package lua_test
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestCapabilities(assert *require.Assertions, L *lua.State) {
+	caps := L.Runtime().Capabilities()
+	assert.Equal(L.Version() >= 503, caps.Continuations)
+	assert.Equal(L.Version() >= 504, caps.Warnings)
+	assert.Equal(L.Version() >= 504, caps.CloseThread)
+	assert.Equal(L.Version() >= 503, caps.Traceback)
+	assert.Equal(L.Version() >= 504, caps.CloseSlot)
+	assert.True(caps.UserValues)
+	assert.Empty(caps.Missing)
+
+	if !caps.Warnings {
+		assert.Panics(func() {
+			L.SetWarnf(nil, nil)
+		})
+	}
+}
+
+func (s *Suite) TestSymbolError(assert *require.Assertions, t *testing.T) {
+	var path string
+	switch runtime.GOOS {
+	case "windows":
+		path = "kernel32.dll"
+	case "darwin":
+		path = "/usr/lib/libSystem.B.dylib"
+	default:
+		path = "libc.so.6"
+	}
+
+	_, err := lua.Open(path, lua.AllowMissingSymbols())
+	var symErr *lua.SymbolError
+	assert.True(errors.As(err, &symErr))
+	assert.Contains(symErr.Missing(), "luaL_newstate")
+}
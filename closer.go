@@ -0,0 +1,61 @@
+package lua
+
+import (
+	"io"
+	"unsafe"
+)
+
+// closerMetaTable is the name of the metatable of the userdata pushed by PushCloser.
+const closerMetaTable = "io.Closer"
+
+// PushCloser pushes onto the stack a userdata holding the Go closer c, whose __close and __gc metamethods close it.
+// Assigned to a to-be-closed variable in Lua, or marked by ToClose, the closer is closed
+// when the variable goes out of scope, even by an error. Otherwise it is closed when the userdata is collected,
+// or when the state is closed. Lua 5.1 to 5.3 have no __close metamethod and only rely on the collection.
+// c is closed at most once. An error returned by Close is raised by __close and ignored by __gc.
+// See: https://www.lua.org/manual/5.4/manual.html#3.3.8
+func (s *State) PushCloser(c io.Closer) {
+	closeMeta, gcMeta := s.rt.closerCallbacks()
+
+	ud := (*Handle)(s.NewUserData(int(unsafe.Sizeof(Handle(0)))))
+	*ud = NewHandle(c)
+
+	if s.NewMetaTable(closerMetaTable) {
+		s.PushCFunction(closeMeta)
+		s.SetField(-2, "__close")
+		s.PushCFunction(gcMeta)
+		s.SetField(-2, "__gc")
+	}
+	s.SetIMetaTable(-2)
+}
+
+// closerCallbacks returns the __close and __gc metamethods of the userdata pushed by PushCloser.
+// They are created once per runtime because purego callbacks are never released.
+func (rt *Runtime) closerCallbacks() (closeMeta, gcMeta uintptr) {
+	rt.closerOnce.Do(func() {
+		rt.closeMeta = rt.NewCallback(func(L *State) int {
+			if err := L.closeCloser(); err != nil {
+				return L.Errorf("%v", err)
+			}
+			return 0
+		})
+		rt.gcMeta = rt.NewCallback(func(L *State) int {
+			_ = L.closeCloser()
+			return 0
+		})
+	})
+	return rt.closeMeta, rt.gcMeta
+}
+
+// closeCloser closes the Go closer held by the userdata at index 1, unless it is already closed.
+func (s *State) closeCloser() error {
+	ud := (*Handle)(s.TestUserData(1, closerMetaTable))
+	if ud == nil || *ud == 0 {
+		return nil
+	}
+	h := *ud
+	*ud = 0
+	defer h.Delete()
+
+	return h.Value().(io.Closer).Close()
+}
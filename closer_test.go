@@ -0,0 +1,96 @@
+package lua_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+type testCloser struct {
+	closed int
+	err    error
+}
+
+func (c *testCloser) Close() error {
+	c.closed++
+	return c.err
+}
+
+func (s *Suite) TestPushCloser(assert *require.Assertions, t *testing.T) {
+	L := lua.NewState()
+	L.OpenLibs()
+
+	collected := &testCloser{}
+	L.PushCloser(collected)
+	L.Pop(1)
+	assert.NoError(L.DoString(`collectgarbage()`))
+	assert.Equal(1, collected.closed)
+
+	kept := &testCloser{}
+	L.PushCloser(kept)
+	L.SetGlobal("kept")
+	L.Close()
+	assert.Equal(1, kept.closed)
+}
+
+func (s *Suite) TestCloserToBeClosed(assert *require.Assertions, L *lua.State) {
+	if L.Version() < 504 {
+		assert.Panics(func() { L.ToClose(-1) })
+		return
+	}
+
+	var closers []*testCloser
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		c := &testCloser{}
+		if L.ToBoolean(1) {
+			c.err = errors.New("close failed: 100%")
+		}
+		closers = append(closers, c)
+		L.PushCloser(c)
+		return 1
+	}))
+	L.SetGlobal("open")
+
+	assert.NoError(L.DoString(`
+		do
+			local f <close> = open()
+		end
+		local ok, err = pcall(function()
+			local f <close> = open()
+			error("boom")
+		end)
+		assert(not ok and err:find("boom"))
+		ok, err = pcall(function()
+			local f <close> = open(true)
+		end)
+		assert(not ok)
+		closeError = err
+	`))
+	assert.Len(closers, 3)
+	for _, c := range closers {
+		assert.Equal(1, c.closed)
+	}
+	L.GetGlobal("closeError")
+	assert.Contains(L.ToString(-1), "close failed: 100%")
+	L.Pop(1)
+
+	c := &testCloser{}
+	L.PushCloser(c)
+	L.ToClose(-1)
+	L.Pop(1)
+	assert.Equal(1, c.closed)
+
+	if !L.Runtime().Capabilities().CloseSlot {
+		return
+	}
+	c = &testCloser{}
+	L.PushCloser(c)
+	L.ToClose(-1)
+	L.CloseSlot(-1)
+	assert.Equal(1, c.closed)
+	assert.True(L.IsNil(-1))
+	L.Pop(1)
+	assert.Equal(1, c.closed)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+
+	"go.yuchanns.xyz/lua"
+)
+
+// interpreter runs the command line of lua.c on a state of its runtime.
+type interpreter struct {
+	rt *lua.Runtime
+	// progname prefixes the error messages, it is empty in interactive mode.
+	progname string
+	// library is the path of the Lua library, shown with the version.
+	library string
+
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	getenv         func(string) string
+
+	// msgh is the message handler of the calls, adding a traceback to the error messages.
+	msgh uintptr
+}
+
+// newInterpreter returns an interpreter of the runtime rt, using the standard streams and environment.
+func newInterpreter(rt *lua.Runtime, progname, library string) *interpreter {
+	return &interpreter{
+		rt:       rt,
+		progname: progname,
+		library:  library,
+		stdin:    os.Stdin,
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		getenv:   os.Getenv,
+		msgh:     rt.NewCallback(msghandler),
+	}
+}
+
+// run runs the command line args parsed into opts in a new state, and returns the exit status of the process.
+func (in *interpreter) run(args []string, opts *options) int {
+	L := in.rt.NewState()
+	defer L.Close()
+
+	if in.main(L, args, opts) {
+		return 0
+	}
+	return 1
+}
+
+// main runs the initialization, the options, the script and the interactive mode,
+// stopping at the first error. Returns false on error.
+func (in *interpreter) main(L *lua.State, args []string, opts *options) bool {
+	if opts.noEnv {
+		// Signal the package library to ignore the environment variables.
+		L.PushBoolean(true)
+		L.SetField(lua.LUA_REGISTRYINDEX, "LUA_NOENV")
+	}
+	L.OpenLibs()
+	in.createArgTable(L, args, opts.script)
+
+	if opts.showVersion {
+		in.printVersion(L)
+	}
+	if !opts.noEnv && !in.handleInit(L) {
+		return false
+	}
+	for _, a := range opts.actions {
+		var ok bool
+		if a.option == 'e' {
+			ok = in.doString(L, a.value, "=(command line)")
+		} else {
+			ok = in.doLibrary(L, a.value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	if opts.script < len(args) && !in.handleScript(L, args, opts.script) {
+		return false
+	}
+
+	switch {
+	case opts.interactive:
+		in.repl(L)
+	case opts.script == len(args) && !opts.exec && !opts.showVersion:
+		if in.isTerminal() {
+			in.printVersion(L)
+			in.repl(L)
+		} else {
+			return in.doStdin(L)
+		}
+	}
+	return true
+}
+
+// createArgTable sets the global table arg: the script is at index 0, its arguments at the positive indices
+// and the interpreter with its options at the negative ones. Without a script, the interpreter is at index 0.
+func (in *interpreter) createArgTable(L *lua.State, args []string, script int) {
+	if script == len(args) {
+		script = 0
+	}
+	L.CreateTable(len(args)-script-1, script+1)
+	for i, arg := range args {
+		L.PushString(arg)
+		L.RawSetI(-2, int64(i-script))
+	}
+	L.SetGlobal("arg")
+}
+
+// printVersion shows the version of Lua and the library it is loaded from.
+func (in *interpreter) printVersion(L *lua.State) {
+	top := L.GetTop()
+	version := "Lua"
+	if L.GetGlobal("jit"); L.Type(-1) == lua.LUA_TTABLE && L.GetField(-1, "version") == lua.LUA_TSTRING {
+		version = L.ToString(-1)
+	} else if L.GetGlobal("_VERSION"); L.Type(-1) == lua.LUA_TSTRING {
+		version = L.ToString(-1)
+	}
+	L.SetTop(top)
+	fmt.Fprintf(in.stdout, "%s  (%s)\n", version, in.library)
+}
+
+// handleInit runs the LUA_INIT_5_4 environment variable, or LUA_INIT, as a file name when it starts with '@',
+// otherwise as Lua code.
+func (in *interpreter) handleInit(L *lua.State) bool {
+	var names []string
+	if v := int(L.Version()); v > 501 {
+		names = append(names, fmt.Sprintf("LUA_INIT_%d_%d", v/100, v%100))
+	}
+	names = append(names, "LUA_INIT")
+	for _, name := range names {
+		init := in.getenv(name)
+		if init == "" {
+			continue
+		}
+		if file, ok := strings.CutPrefix(init, "@"); ok {
+			return in.doFile(L, file)
+		}
+		return in.doString(L, init, "="+name)
+	}
+	return true
+}
+
+// doString runs the chunk s named name.
+func (in *interpreter) doString(L *lua.State, s, name string) bool {
+	err := L.LoadBufferx([]byte(s), name)
+	if err == nil {
+		err = in.docall(L, 0, 0)
+	}
+	return in.report(err)
+}
+
+// doFile runs the file named name.
+func (in *interpreter) doFile(L *lua.State, name string) bool {
+	err := L.LoadFile(name)
+	if err == nil {
+		err = in.docall(L, 0, 0)
+	}
+	return in.report(err)
+}
+
+// doStdin runs the standard input as a chunk.
+func (in *interpreter) doStdin(L *lua.State) bool {
+	err := in.loadStdin(L)
+	if err == nil {
+		err = in.docall(L, 0, 0)
+	}
+	return in.report(err)
+}
+
+// loadStdin loads the standard input as a chunk named "=stdin", skipping a first line starting with '#'
+// like luaL_loadfile does.
+func (in *interpreter) loadStdin(L *lua.State) error {
+	code, err := io.ReadAll(in.stdin)
+	if err != nil {
+		return fmt.Errorf("cannot read stdin: %w", err)
+	}
+	code = bytes.TrimPrefix(code, []byte("\xEF\xBB\xBF"))
+	if len(code) > 0 && code[0] == '#' {
+		// The newline is kept so that the lines keep their numbers.
+		if i := bytes.IndexByte(code, '\n'); i >= 0 {
+			code = code[i:]
+		} else {
+			code = nil
+		}
+	}
+	return L.LoadBufferx(code, "=stdin")
+}
+
+// doLibrary runs the -l option, requiring the module mod into the global mod, or g for g=mod.
+// Without an explicit global, the name of the global ends at a '-' of the module name.
+func (in *interpreter) doLibrary(L *lua.State, spec string) bool {
+	global, mod, ok := strings.Cut(spec, "=")
+	if !ok {
+		mod = spec
+		global, _, _ = strings.Cut(spec, "-")
+	}
+	L.GetGlobal("require")
+	L.PushString(mod)
+	err := in.docall(L, 1, 1)
+	if err == nil {
+		L.SetGlobal(global)
+	}
+	return in.report(err)
+}
+
+// handleScript runs the script at index script of args, the standard input for "-" unless it follows "--",
+// with the following arguments as its variable arguments.
+func (in *interpreter) handleScript(L *lua.State, args []string, script int) bool {
+	var err error
+	if fname := args[script]; fname == "-" && args[script-1] != "--" {
+		err = in.loadStdin(L)
+	} else {
+		err = L.LoadFile(fname)
+	}
+	if err == nil {
+		for _, arg := range args[script+1:] {
+			L.PushString(arg)
+		}
+		err = in.docall(L, len(args)-script-1, lua.LUA_MULTRET)
+	}
+	return in.report(err)
+}
+
+// docall calls the function below its narg arguments, with the message handler
+// and the interruption of the call by SIGINT.
+func (in *interpreter) docall(L *lua.State, narg, nres int) error {
+	base := L.GetTop() - narg
+	L.PushCFunction(in.msgh)
+	L.Insert(base)
+	stop := catchInterrupt(L)
+	err := L.PCall(narg, nres, base)
+	stop()
+	L.Remove(base)
+	return err
+}
+
+// msghandler adds a traceback to the error message, or converts the error object to a message.
+func msghandler(L *lua.State) int {
+	var msg string
+	switch L.Type(1) {
+	case lua.LUA_TSTRING, lua.LUA_TNUMBER:
+		msg = L.ToString(1)
+	default:
+		if L.GetMetaField(1, "__tostring") != lua.LUA_TNIL {
+			L.Pop(1)
+			L.ToStringMeta(1)
+			return 1
+		}
+		msg = fmt.Sprintf("(error object is a %s value)", L.TypeName(L.Type(1)))
+	}
+	L.Traceback(L, msg, 1)
+	return 1
+}
+
+// catchInterrupt interrupts the Lua code run by L with an error when the process receives SIGINT,
+// until the returned function is called.
+func catchInterrupt(L *lua.State) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	var (
+		wg          sync.WaitGroup
+		interrupted bool
+	)
+	signal.Notify(signals, os.Interrupt)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-signals:
+			interrupted = true
+			// Setting a hook is safe while the state runs, the hook raises the error at the next event.
+			L.SetHook(interrupt, lua.LUA_MASKCALL|lua.LUA_MASKRET|lua.LUA_MASKLINE|lua.LUA_MASKCOUNT, 1)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		wg.Wait()
+		if interrupted {
+			L.SetHook(nil, 0, 0)
+		}
+	}
+}
+
+// interrupt is the hook raising the interruption error.
+func interrupt(L *lua.State, _ *lua.Debug) {
+	L.SetHook(nil, 0, 0)
+	L.Errorf("interrupted!")
+}
+
+// report prints the error err, if any, and returns whether there was none.
+func (in *interpreter) report(err error) bool {
+	if err == nil {
+		return true
+	}
+	msg := err.Error()
+	var e *lua.Error
+	if errors.As(err, &e) {
+		msg = e.Message()
+	}
+	in.message(msg)
+	return false
+}
+
+// message prints msg on the standard error, prefixed by the name of the program outside of interactive mode.
+func (in *interpreter) message(msg string) {
+	if in.progname != "" {
+		fmt.Fprintf(in.stderr, "%s: ", in.progname)
+	}
+	fmt.Fprintln(in.stderr, msg)
+}
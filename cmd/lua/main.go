@@ -0,0 +1,178 @@
+// Command lua is a standalone Lua interpreter built on go.yuchanns.xyz/lua,
+// equivalent to the lua program of the reference implementation.
+//
+// Usage:
+//
+//	lua [options] [script [args]]
+//
+// The options are those of lua.c: -e, -l, -i, -v, -E, -- and -,
+// plus --library and --lua-version to choose the Lua dynamic library, which is otherwise
+// searched by lua.FindLibrary, from the LUA_LIBRARY environment variable, unless -E is given,
+// and the usual locations.
+// The script receives its arguments in the global table arg and as its variable arguments.
+// Without a script nor -e or -v, the interpreter runs the standard input,
+// interactively when it is a terminal, with line editing and history.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.yuchanns.xyz/lua"
+)
+
+// options is the parsed command line of the interpreter.
+type options struct {
+	// library is the path of the Lua dynamic library, and version the wanted version to search one.
+	library, version string
+	interactive      bool
+	showVersion      bool
+	noEnv            bool
+	exec             bool
+	// actions are the -e and -l options, run in order.
+	actions []action
+	// script is the index of the script in the arguments, their length when there is none.
+	script int
+}
+
+// action is a -e or -l option with its argument.
+type action struct {
+	option byte
+	value  string
+}
+
+// usageError is a bad command line, reported with the usage of the interpreter.
+type usageError struct {
+	option string
+}
+
+func (e *usageError) Error() string {
+	if e.option == "-e" || e.option == "-l" || e.option == "--library" || e.option == "--lua-version" {
+		return fmt.Sprintf("'%s' needs argument", e.option)
+	}
+	return fmt.Sprintf("unrecognized option '%s'", e.option)
+}
+
+// parseArgs parses the options of the command line args, which starts with the name of the program.
+// The options stop at the script, "--" or "-".
+func parseArgs(args []string) (opts *options, err error) {
+	opts = &options{script: len(args)}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			opts.script = i
+			return
+		}
+		switch {
+		case arg == "--":
+			opts.script = i + 1
+			return
+		case arg == "-":
+			opts.script = i
+			return
+		case arg == "-E":
+			opts.noEnv = true
+		case arg == "-i":
+			opts.interactive = true
+			opts.showVersion = true
+		case arg == "-v":
+			opts.showVersion = true
+		case strings.HasPrefix(arg, "-e"), strings.HasPrefix(arg, "-l"):
+			value := arg[2:]
+			if value == "" {
+				i++
+				if i >= len(args) || strings.HasPrefix(args[i], "-") {
+					return nil, &usageError{option: arg}
+				}
+				value = args[i]
+			}
+			opts.exec = opts.exec || arg[1] == 'e'
+			opts.actions = append(opts.actions, action{option: arg[1], value: value})
+		case strings.HasPrefix(arg, "--library"), strings.HasPrefix(arg, "--lua-version"):
+			name, value, ok := strings.Cut(arg, "=")
+			if name != "--library" && name != "--lua-version" {
+				return nil, &usageError{option: arg}
+			}
+			if !ok {
+				i++
+				if i >= len(args) {
+					return nil, &usageError{option: arg}
+				}
+				value = args[i]
+			}
+			if name == "--library" {
+				opts.library = value
+			} else {
+				opts.version = value
+			}
+		default:
+			return nil, &usageError{option: arg}
+		}
+	}
+	return
+}
+
+// printUsage reports the bad command line err, followed by the usage of the interpreter.
+func printUsage(w io.Writer, progname string, err error) {
+	fmt.Fprintf(w, "%s: %v\n", progname, err)
+	fmt.Fprintf(w, `usage: %s [options] [script [args]]
+Available options are:
+  -e stat            execute string 'stat'
+  -i                 enter interactive mode after executing 'script'
+  -l mod             require library 'mod' into global 'mod'
+  -l g=mod           require library 'mod' into global 'g'
+  -v                 show version information
+  -E                 ignore environment variables
+  --library path     load the Lua library at path
+  --lua-version ver  search the Lua library of version ver, such as 5.4
+  --                 stop handling options
+  -                  stop handling options and execute stdin
+`, progname)
+}
+
+// openRuntime loads the Lua library chosen by the options, and returns it with its path.
+func openRuntime(opts *options) (rt *lua.Runtime, path string, err error) {
+	path = opts.library
+	if path == "" {
+		// -E ignores the environment variables choosing the library too.
+		if opts.noEnv {
+			path, err = lua.FindLibrary(lua.Want(opts.version), lua.IgnoreEnv())
+		} else {
+			path, err = lua.FindLibrary(lua.Want(opts.version))
+		}
+		if err != nil {
+			return
+		}
+	}
+	// Like the lua executable, the interpreter exports the Lua API to the C modules loaded by require.
+	rt, err = lua.Open(path, lua.AllowMissingSymbols(), lua.GlobalSymbols())
+	return
+}
+
+func main() {
+	progname := "lua"
+	if len(os.Args) > 0 && os.Args[0] != "" {
+		progname = os.Args[0]
+	}
+
+	opts, err := parseArgs(os.Args)
+	if err != nil {
+		printUsage(os.Stderr, progname, err)
+		os.Exit(1)
+	}
+	rt, path, err := openRuntime(opts)
+	if err != nil {
+		var notFound *lua.LibraryNotFoundError
+		if errors.As(err, &notFound) {
+			err = fmt.Errorf("%w\nset LUA_LIBRARY or use --library to choose the Lua library", err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n", progname, err)
+		os.Exit(1)
+	}
+
+	in := newInterpreter(rt, progname, path)
+	os.Exit(in.run(os.Args, opts))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+	"go.yuchanns.xyz/lua/luatest"
+)
+
+var testRuntime *lua.Runtime
+
+func TestMain(m *testing.M) {
+	luatest.Main(m, luatest.WithRuntime(func(rt *lua.Runtime) {
+		testRuntime = rt
+	}))
+}
+
+func TestParseArgs(t *testing.T) {
+	assert := require.New(t)
+
+	opts, err := parseArgs([]string{"lua", "-e", "x = 1", "-lstring", "-i", "-E", "script.lua", "-e", "arg"})
+	assert.NoError(err)
+	assert.Equal([]action{{'e', "x = 1"}, {'l', "string"}}, opts.actions)
+	assert.True(opts.exec)
+	assert.True(opts.interactive)
+	assert.True(opts.showVersion)
+	assert.True(opts.noEnv)
+	assert.Equal(6, opts.script)
+
+	opts, err = parseArgs([]string{"lua", "--library=/lib/liblua.so", "--lua-version", "5.3", "--", "-"})
+	assert.NoError(err)
+	assert.Equal("/lib/liblua.so", opts.library)
+	assert.Equal("5.3", opts.version)
+	assert.Equal(5, opts.script)
+
+	opts, err = parseArgs([]string{"lua", "-v", "-"})
+	assert.NoError(err)
+	assert.True(opts.showVersion)
+	assert.False(opts.interactive)
+	assert.Equal(2, opts.script)
+
+	opts, err = parseArgs([]string{"lua"})
+	assert.NoError(err)
+	assert.Equal(1, opts.script)
+
+	_, err = parseArgs([]string{"lua", "-x"})
+	assert.EqualError(err, "unrecognized option '-x'")
+	_, err = parseArgs([]string{"lua", "-ix"})
+	assert.EqualError(err, "unrecognized option '-ix'")
+	_, err = parseArgs([]string{"lua", "-e"})
+	assert.EqualError(err, "'-e' needs argument")
+	_, err = parseArgs([]string{"lua", "-l", "-v"})
+	assert.EqualError(err, "'-l' needs argument")
+	_, err = parseArgs([]string{"lua", "--library"})
+	assert.EqualError(err, "'--library' needs argument")
+	_, err = parseArgs([]string{"lua", "--foo"})
+	assert.EqualError(err, "unrecognized option '--foo'")
+}
+
+// run runs the interpreter with the command line args,
+// and returns the exit status and what was written to the standard output and error.
+func run(t *testing.T, stdin string, env map[string]string, args ...string) (status int, stdout, stderr string) {
+	args = append([]string{"lua"}, args...)
+	opts, err := parseArgs(args)
+	require.NoError(t, err)
+
+	var o, e bytes.Buffer
+	in := newInterpreter(testRuntime, "lua", "liblua")
+	in.stdin = strings.NewReader(stdin)
+	in.stdout, in.stderr = &o, &e
+	in.getenv = func(name string) string { return env[name] }
+	status = in.run(args, opts)
+	return status, o.String(), e.String()
+}
+
+// runPrint runs the interpreter like run, after an -e option redirecting print to a file,
+// and also returns the printed lines.
+func runPrint(t *testing.T, stdin string, env map[string]string, args ...string) (status int, printed, stdout, stderr string) {
+	out := filepath.Join(t.TempDir(), "printed")
+	capture := fmt.Sprintf(`
+		local out = assert(io.open([[%s]], "w"))
+		function print(...)
+			local t = {...}
+			for i = 1, select("#", ...) do t[i] = tostring(t[i]) end
+			out:write(table.concat(t, "\t"), "\n")
+			out:flush()
+		end`, out)
+	status, stdout, stderr = run(t, stdin, env, append([]string{"-e", capture}, args...)...)
+
+	content, _ := os.ReadFile(out)
+	return status, string(content), stdout, stderr
+}
+
+func TestRunScript(t *testing.T) {
+	assert := require.New(t)
+
+	script := filepath.Join(t.TempDir(), "script.lua")
+	assert.NoError(os.WriteFile(script, []byte("#!/usr/bin/env lua\nprint(#arg, arg[0], arg[1], arg[2], select('#', ...), ...)\n"), 0o644))
+
+	status, printed, _, stderr := runPrint(t, "", nil, script, "a", "b")
+	assert.Zero(status, stderr)
+	assert.Equal(fmt.Sprintf("2\t%s\ta\tb\t2\ta\tb\n", script), printed)
+
+	status, printed, _, stderr = runPrint(t, "#!/usr/bin/env lua\nprint(..., arg[0])\n", nil, "-", "x")
+	assert.Zero(status, stderr)
+	assert.Equal("x\t-\n", printed)
+
+	// Without a script nor -e, the standard input is run.
+	out := filepath.Join(t.TempDir(), "out")
+	status, _, stderr = run(t, fmt.Sprintf("local f = io.open([[%s]], 'w') f:write('ran') f:close()", out), nil)
+	assert.Zero(status, stderr)
+	content, err := os.ReadFile(out)
+	assert.NoError(err)
+	assert.Equal("ran", string(content))
+}
+
+func TestRunErrors(t *testing.T) {
+	assert := require.New(t)
+
+	status, _, _, stderr := runPrint(t, "", nil, "-e", "error('boom')")
+	assert.Equal(1, status)
+	assert.True(strings.HasPrefix(stderr, "lua: (command line):1: boom\n"), stderr)
+	assert.Contains(stderr, "stack traceback:")
+
+	status, _, _, stderr = runPrint(t, "", nil, "-e", "error({})")
+	assert.Equal(1, status)
+	assert.True(strings.HasPrefix(stderr, "lua: (error object is a table value)"), stderr)
+
+	status, printed, _, stderr := runPrint(t, "", nil, "-e", "error(setmetatable({}, {__tostring = function() return 'custom' end}))", "-e", "print(1)")
+	assert.Equal(1, status)
+	assert.Equal("lua: custom\n", stderr)
+	assert.Empty(printed)
+
+	status, _, stderr = run(t, "error('x')", nil)
+	assert.Equal(1, status)
+	assert.True(strings.HasPrefix(stderr, "lua: stdin:1: x\n"), stderr)
+
+	status, _, _, stderr = runPrint(t, "", nil, filepath.Join(t.TempDir(), "missing.lua"))
+	assert.Equal(1, status)
+	assert.Contains(stderr, "cannot open")
+}
+
+func TestRunInit(t *testing.T) {
+	assert := require.New(t)
+
+	env := map[string]string{"LUA_INIT": "x = 42"}
+	status, printed, _, stderr := runPrint(t, "", env, "-e", "print(x)")
+	assert.Zero(status, stderr)
+	assert.Equal("42\n", printed)
+
+	status, printed, _, stderr = runPrint(t, "", env, "-E", "-e", "print(x)")
+	assert.Zero(status, stderr)
+	assert.Equal("nil\n", printed)
+
+	init := filepath.Join(t.TempDir(), "init.lua")
+	assert.NoError(os.WriteFile(init, []byte("y = 'file'"), 0o644))
+	status, printed, _, stderr = runPrint(t, "", map[string]string{"LUA_INIT": "@" + init}, "-e", "print(y)")
+	assert.Zero(status, stderr)
+	assert.Equal("file\n", printed)
+
+	status, _, _, stderr = runPrint(t, "", map[string]string{"LUA_INIT": "error('init')"}, "-e", "print(x)")
+	assert.Equal(1, status)
+	assert.Contains(stderr, "LUA_INIT:1: init")
+}
+
+func TestRunLibrary(t *testing.T) {
+	assert := require.New(t)
+
+	status, printed, _, stderr := runPrint(t, "", nil, "-l", "s=string", "-e", "print(s == string)")
+	assert.Zero(status, stderr)
+	assert.Equal("true\n", printed)
+
+	status, _, _, stderr = runPrint(t, "", nil, "-l", "nosuchmodule")
+	assert.Equal(1, status)
+	assert.Contains(stderr, "module 'nosuchmodule' not found")
+}
+
+func TestRunVersion(t *testing.T) {
+	assert := require.New(t)
+
+	status, _, stdout, stderr := runPrint(t, "", nil, "-v")
+	assert.Zero(status, stderr)
+	assert.Contains(stdout, "(liblua)")
+	assert.True(strings.HasPrefix(stdout, "Lua"), stdout)
+}
+
+func TestREPL(t *testing.T) {
+	assert := require.New(t)
+
+	stdin := strings.Join([]string{
+		"1 + 1",
+		"local t = {",
+		"  n = 3,",
+		"}",
+		"function f(a)",
+		"  return a * 2",
+		"end",
+		"f(21)",
+		"return 'a', 'b'",
+		")",
+		"_PROMPT = 'lua> '",
+		"error('oops')",
+		"x = 1",
+	}, "\n")
+	status, printed, stdout, stderr := runPrint(t, stdin, nil, "-i")
+	assert.Zero(status, stderr)
+	assert.Equal("2\n42\na\tb\n", printed)
+	assert.Contains(stdout, "> ")
+	assert.Contains(stdout, ">> ")
+	assert.Contains(stdout, "lua> ")
+	assert.Contains(stderr, "stdin:1: unexpected symbol near ')'\n")
+	assert.Contains(stderr, "stdin:1: oops\n")
+	assert.NotContains(stderr, "lua: ")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.yuchanns.xyz/lua"
+	"golang.org/x/term"
+)
+
+// lineReader reads the lines of the interactive mode.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// plainReader reads lines from a stream which is not a terminal, writing the prompts like lua.c without readline.
+type plainReader struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func (p *plainReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(p.w, prompt)
+	line, err := p.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// terminalReader reads lines from a terminal, with line editing and the history of the session.
+// The terminal is in raw mode only while a line is read, so that the output of Lua is not altered.
+type terminalReader struct {
+	fd int
+	t  *term.Terminal
+}
+
+func newTerminalReader(in *os.File, out io.Writer) *terminalReader {
+	return &terminalReader{
+		fd: int(in.Fd()),
+		t: term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{in, out}, ""),
+	}
+}
+
+func (t *terminalReader) readLine(prompt string) (string, error) {
+	state, err := term.MakeRaw(t.fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(t.fd, state)
+
+	if width, height, err := term.GetSize(t.fd); err == nil {
+		_ = t.t.SetSize(width, height)
+	}
+	t.t.SetPrompt(prompt)
+	return t.t.ReadLine()
+}
+
+// isTerminal reports whether the standard input is a terminal.
+func (in *interpreter) isTerminal() bool {
+	f, ok := in.stdin.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// lineReader returns the reader of the lines of the standard input.
+func (in *interpreter) lineReader() lineReader {
+	if in.isTerminal() {
+		return newTerminalReader(in.stdin.(*os.File), in.stdout)
+	}
+	return &plainReader{r: bufio.NewReader(in.stdin), w: in.stdout}
+}
+
+// repl runs the interactive mode: each statement read is run, the values of an expression are printed,
+// and a statement continues on the next lines while it is incomplete.
+func (in *interpreter) repl(L *lua.State) {
+	progname := in.progname
+	in.progname = ""
+	defer func() { in.progname = progname }()
+
+	r := in.lineReader()
+	for {
+		L.SetTop(0)
+		err := in.loadLine(L, r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			if err = in.docall(L, 0, lua.LUA_MULTRET); err == nil {
+				in.printResults(L)
+			}
+		}
+		in.report(err)
+	}
+	L.SetTop(0)
+	fmt.Fprintln(in.stdout)
+}
+
+// loadLine reads a statement and loads it: first as an expression whose values are returned,
+// then as a statement, reading more lines while it is incomplete.
+// Returns io.EOF when there is no more input.
+func (in *interpreter) loadLine(L *lua.State, r lineReader) error {
+	line, err := in.readLine(L, r, true)
+	if err != nil {
+		return err
+	}
+	if L.Version() < 504 {
+		// Before Lua 5.4, a line starting with '=' prints the values of the expression which follows.
+		if expr, ok := strings.CutPrefix(line, "="); ok {
+			line = "return " + expr
+		}
+	}
+	if L.LoadBufferx([]byte("return "+line), "=stdin") == nil {
+		return nil
+	}
+	for {
+		err := L.LoadBufferx([]byte(line), "=stdin")
+		if err == nil || !incomplete(err) {
+			return err
+		}
+		more, rerr := in.readLine(L, r, false)
+		if rerr != nil {
+			// The statement stays incomplete, its syntax error is reported.
+			return err
+		}
+		line += "\n" + more
+	}
+}
+
+// incomplete reports whether the syntax error err is due to a statement ending early,
+// as its message ends with the end of file mark.
+func incomplete(err error) bool {
+	var e *lua.Error
+	if !errors.As(err, &e) || e.Status() != lua.LUA_ERRSYNTAX {
+		return false
+	}
+	msg := e.Message()
+	// Lua 5.1 quotes the mark.
+	return strings.HasSuffix(msg, "<eof>") || strings.HasSuffix(msg, "'<eof>'")
+}
+
+// readLine reads a line with the prompt of the global _PROMPT, or _PROMPT2 for a continuation line.
+func (in *interpreter) readLine(L *lua.State, r lineReader, first bool) (string, error) {
+	name, prompt := "_PROMPT", "> "
+	if !first {
+		name, prompt = "_PROMPT2", ">> "
+	}
+	L.GetGlobal(name)
+	if t := L.Type(-1); t == lua.LUA_TSTRING || t == lua.LUA_TNUMBER {
+		prompt = L.ToString(-1)
+	}
+	L.Pop(1)
+
+	in.flushStdout(L)
+	return r.readLine(prompt)
+}
+
+// flushStdout flushes the standard output of the C library, written by the Lua code,
+// before the prompt is written by Go.
+func (in *interpreter) flushStdout(L *lua.State) {
+	top := L.GetTop()
+	defer L.SetTop(top)
+
+	if L.GetGlobal("io"); L.Type(-1) != lua.LUA_TTABLE {
+		return
+	}
+	if L.GetField(-1, "stdout") == lua.LUA_TNIL || L.GetField(-1, "flush") != lua.LUA_TFUNCTION {
+		return
+	}
+	L.PushValue(-2)
+	_ = L.PCall(1, 0, 0)
+}
+
+// printResults prints the values left on the stack by a statement with the global function print.
+func (in *interpreter) printResults(L *lua.State) {
+	n := L.GetTop()
+	if n == 0 {
+		return
+	}
+	if !L.CheckStack(lua.LUA_MINSTACK) {
+		in.message("too many results to print")
+		return
+	}
+	L.GetGlobal("print")
+	L.Insert(1)
+	if err := L.PCall(n, 0, 0); err != nil {
+		msg := err.Error()
+		var e *lua.Error
+		if errors.As(err, &e) {
+			msg = e.Message()
+		}
+		in.message(fmt.Sprintf("error calling 'print' (%s)", msg))
+	}
+}
@@ -0,0 +1,196 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// index translates the pseudo-indices of Lua 5.2 and later, LUA_REGISTRYINDEX and the upvalue indices,
+// into the ones of Lua 5.1. Other indices are returned unchanged.
+func (s *State) index(idx int) int {
+	if idx > LUA_REGISTRYINDEX || s.rt.ffi.version > 501 {
+		return idx
+	}
+	if idx == LUA_REGISTRYINDEX {
+		return LUA51_REGISTRYINDEX
+	}
+	return LUA_GLOBALSINDEX - (LUA_REGISTRYINDEX - idx)
+}
+
+// absIndex51 emulates lua_absindex, which is missing in Lua 5.1.
+func (s *State) absIndex51(idx int) int {
+	if idx > 0 || idx <= LUA51_REGISTRYINDEX {
+		return idx
+	}
+	return s.GetTop() + idx + 1
+}
+
+// rotate51 emulates lua_rotate with the lua_insert and lua_remove of Lua 5.1.
+func (s *State) rotate51(idx, n int) {
+	idx = s.absIndex51(idx)
+	top := s.GetTop()
+	if size := top - idx + 1; size > 0 {
+		n %= size
+	}
+	for ; n > 0; n-- {
+		s.rt.ffi.LuaInsert(s.luaL, idx)
+	}
+	for ; n < 0; n++ {
+		s.rt.ffi.LuaPushvalue(s.luaL, idx)
+		s.rt.ffi.LuaRemove(s.luaL, idx)
+	}
+}
+
+// isInteger51 emulates lua_isinteger for Lua 5.1, which only has floating point numbers,
+// by reporting numbers without a fractional part.
+func (s *State) isInteger51(idx int) bool {
+	if s.Type(idx) != LUA_TNUMBER {
+		return false
+	}
+	n := s.rt.ffi.LuaTonumber(s.luaL, s.index(idx))
+	return n == math.Trunc(n) && !math.IsInf(n, 0)
+}
+
+// len51 emulates lua_len for Lua 5.1, honouring the __len metamethod.
+func (s *State) len51(idx int) {
+	idx = s.absIndex51(idx)
+	if s.CallMeta(idx, "__len") {
+		return
+	}
+	switch s.Type(idx) {
+	case LUA_TSTRING, LUA_TTABLE:
+		s.PushInteger(int64(s.rt.ffi.LuaObjlen(s.luaL, s.index(idx))))
+	default:
+		s.Errorf("attempt to get length of a %s value", s.TypeName(s.Type(idx)))
+	}
+}
+
+// testUserData51 emulates luaL_testudata, which is missing in Lua 5.1.
+func (s *State) testUserData51(ud int, tname string) (ptr unsafe.Pointer) {
+	ud = s.absIndex51(ud)
+	ptr = s.ToUserData(ud)
+	if ptr == nil || s.GeIMetaTable(ud) == 0 {
+		return nil
+	}
+	s.GetMetaTable(tname)
+	if !s.RawEqual(-1, -2) {
+		ptr = nil
+	}
+	s.Pop(2)
+	return
+}
+
+// setFuncs51 emulates luaL_setfuncs, which is missing in Lua 5.1,
+// registering the functions into the table below the nup upvalues on the stack.
+func (s *State) setFuncs51(l []*Reg, nup int) {
+	s.CheckStack(nup)
+	for _, reg := range l {
+		for range nup {
+			s.PushValue(-nup)
+		}
+		s.PushCClousure(reg.Func, nup)
+		s.SetField(-(nup + 2), reg.Name)
+	}
+	s.Pop(nup)
+}
+
+// requiref51 emulates luaL_requiref, which is missing in Lua 5.1.
+func (s *State) requiref51(modname string, openf uintptr, global bool) {
+	s.GetField(LUA_REGISTRYINDEX, "_LOADED")
+	s.GetField(-1, modname)
+	if !s.ToBoolean(-1) {
+		s.Pop(1)
+		s.PushCFunction(openf)
+		s.PushString(modname)
+		s.Call(1, 1)
+		s.PushValue(-1)
+		s.SetField(-3, modname)
+	}
+	s.Remove(-2)
+	if global {
+		s.PushValue(-1)
+		s.SetGlobal(modname)
+	}
+}
+
+// checkMode51 reports an error for load modes which cannot be honoured by Lua 5.1,
+// which always accepts both text and binary chunks.
+func (s *State) checkMode51(name string, mode []string) error {
+	if len(mode) == 0 || mode[0] == "" || mode[0] == "bt" {
+		return nil
+	}
+	return s.rt.ffi.unsupported(name + " with mode " + mode[0])
+}
+
+// typeError53 emulates luaL_typeerror, which is not exported by Lua 5.3,
+// with the same message as the internal function of its auxiliary library.
+func (s *State) typeError53(arg int, tname string) int {
+	var typearg string
+	if s.GetMetaField(arg, "__name") == LUA_TSTRING {
+		typearg = s.ToString(-1)
+	} else if s.Type(arg) == LUA_TLIGHTUSERDATA {
+		typearg = "light userdata"
+	} else {
+		typearg = s.TypeName(s.Type(arg))
+	}
+	return s.ArgError(arg, fmt.Sprintf("%s expected, got %s", tname, typearg))
+}
+
+// length51 emulates luaL_len, which is missing in Lua 5.1.
+func (s *State) length51(idx int) int64 {
+	s.Len(idx)
+	if s.Type(-1) != LUA_TNUMBER || !s.isInteger51(-1) {
+		s.Errorf("object length is not an integer")
+	}
+	n := s.ToInteger(-1)
+	s.Pop(1)
+	return n
+}
+
+// toStringMeta51 emulates luaL_tolstring, which is missing in Lua 5.1, as it is implemented by Lua 5.3.
+func (s *State) toStringMeta51(idx int) {
+	idx = s.absIndex51(idx)
+	if s.CallMeta(idx, "__tostring") {
+		if s.rt.ffi.LuaIsstring(s.luaL, -1) == 0 {
+			s.Errorf("'__tostring' must return a string")
+		}
+		return
+	}
+	switch typ := s.Type(idx); typ {
+	case LUA_TNUMBER, LUA_TSTRING:
+		s.PushValue(idx)
+		// lua_tolstring converts a number in place
+		s.rt.ffi.LuaTolstring(s.luaL, -1, nil)
+	case LUA_TBOOLEAN:
+		if s.ToBoolean(idx) {
+			s.PushString("true")
+		} else {
+			s.PushString("false")
+		}
+	case LUA_TNIL:
+		s.PushString("nil")
+	default:
+		name := s.TypeName(typ)
+		if t := s.GetMetaField(idx, "__name"); t != LUA_TNIL {
+			if t == LUA_TSTRING {
+				name = s.ToString(-1)
+			}
+			s.Pop(1)
+		}
+		s.PushString(fmt.Sprintf("%s: %p", name, s.ToPointer(idx)))
+	}
+}
+
+// getSubTable51 emulates luaL_getsubtable, which is missing in Lua 5.1.
+func (s *State) getSubTable51(idx int, fname string) bool {
+	if s.GetField(idx, fname) == LUA_TTABLE {
+		return true
+	}
+	s.Pop(1)
+	idx = s.absIndex51(idx)
+	s.NewTable()
+	s.PushValue(-1)
+	s.SetField(idx, fname)
+	return false
+}
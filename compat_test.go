@@ -0,0 +1,110 @@
+package lua_test
+
+import (
+	"errors"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestCompatPseudoIndices(assert *require.Assertions, L *lua.State) {
+	L.PushString("registry value")
+	ref := L.Ref(lua.LUA_REGISTRYINDEX)
+	assert.Equal(lua.LUA_TSTRING, L.RawGetI(lua.LUA_REGISTRYINDEX, int64(ref)))
+	assert.Equal("registry value", L.ToString(-1))
+	L.Pop(1)
+	L.Unref(lua.LUA_REGISTRYINDEX, ref)
+
+	L.PushInteger(7)
+	L.PushCClousure(lua.NewCallback(func(L *lua.State) int {
+		L.PushValue(L.UpValueIndex(1))
+		return 1
+	}), 1)
+	assert.NoError(L.PCall(0, 1, 0))
+	assert.EqualValues(7, L.ToInteger(-1))
+	L.Pop(1)
+
+	L.PushGlobalTable()
+	L.GetField(-1, "string")
+	assert.Equal(lua.LUA_TTABLE, L.Type(-1))
+	L.Pop(2)
+}
+
+func (s *Suite) TestCompatStackShims(assert *require.Assertions, L *lua.State) {
+	for i := 1; i <= 4; i++ {
+		L.PushInteger(int64(i))
+	}
+	L.Rotate(1, 1)
+	assert.EqualValues(4, L.ToInteger(1))
+	assert.EqualValues(3, L.ToInteger(-1))
+	L.Rotate(1, -1)
+	assert.EqualValues(1, L.ToInteger(1))
+	assert.EqualValues(4, L.ToInteger(-1))
+
+	L.Copy(1, 2)
+	assert.EqualValues(1, L.ToInteger(2))
+	assert.Equal(4, L.AbsIndex(-1))
+	L.SetTop(0)
+
+	L.NewTable()
+	L.PushString("one")
+	L.SetI(-2, 1)
+	assert.Equal(lua.LUA_TSTRING, L.GetI(-1, 1))
+	assert.Equal("one", L.ToString(-1))
+	L.Pop(1)
+
+	var key byte
+	L.PushString("by pointer")
+	L.RawSetP(-2, &key)
+	assert.Equal(lua.LUA_TSTRING, L.RawGetP(-1, &key))
+	assert.Equal("by pointer", L.ToString(-1))
+	L.Pop(1)
+
+	L.Len(-1)
+	assert.EqualValues(1, L.ToInteger(-1))
+	L.Pop(2)
+}
+
+func (s *Suite) TestCompatUnsupported(assert *require.Assertions, L *lua.State) {
+	if L.Version() >= 504 {
+		return
+	}
+	err := L.CloseThread(nil)
+	assert.True(errors.Is(err, errors.ErrUnsupported))
+	var uerr *lua.UnsupportedError
+	assert.ErrorAs(err, &uerr)
+	assert.Equal("lua_closethread", uerr.Name())
+
+	assert.Panics(func() {
+		L.SetWarnf(func(*lua.State, string, int) {}, nil)
+	})
+}
+
+func (s *Suite) TestCompatUserValue(assert *require.Assertions, L *lua.State) {
+	L.NewUserData(8)
+	L.NewTable()
+	assert.True(L.SetUserValue(-2))
+	assert.Equal(lua.LUA_TTABLE, L.GetUserValue(-1))
+	L.Pop(1)
+
+	// Lua 5.1 keeps the user value in the environment of the userdata, which must be a table.
+	L.PushString("not a table")
+	assert.Equal(L.Version() >= 503, L.SetUserValue(-2))
+	assert.Equal(1, L.GetTop())
+	L.Pop(1)
+}
+
+func (s *Suite) TestCompatChunkEnv(assert *require.Assertions, L *lua.State) {
+	L.NewEnv()
+	assert.NoError(L.LoadBufferWithEnv([]byte(`x = 1`), "=chunk", "t", -1))
+	assert.NoError(L.PCall(0, 0, 0))
+	assert.Equal(lua.LUA_TNUMBER, L.GetField(-1, "x"))
+	L.Pop(2)
+
+	if L.Version() < 503 {
+		L.PushString("not a table")
+		assert.Error(L.LoadBufferWithEnv([]byte(`x = 1`), "=chunk", "t", -1))
+		assert.Equal(1, L.GetTop())
+		L.Pop(1)
+	}
+}
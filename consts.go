@@ -28,6 +28,23 @@ const (
 	LUA_REGISTRYINDEX = (-LUAI_MAXSTACK - 1000)
 )
 
+// Lua 5.1 pseudo-indices. The binding translates LUA_REGISTRYINDEX and the upvalue indices
+// into them for Lua 5.1 and LuaJIT, so they are rarely needed directly.
+// LUA_GLOBALSINDEX is only valid with Lua 5.1 and LuaJIT, use PushGlobalTable to be portable.
+// See: https://www.lua.org/manual/5.1/manual.html#3.3
+const (
+	LUA51_REGISTRYINDEX = -10000
+	LUA_ENVIRONINDEX    = -10001
+	LUA_GLOBALSINDEX    = -10002
+)
+
+// Predefined references in the registry.
+// See: https://www.lua.org/manual/5.4/manual.html#4.3
+const (
+	LUA_RIDX_MAINTHREAD = 1 // the main thread of the state
+	LUA_RIDX_GLOBALS    = 2 // the global environment
+)
+
 // Thread status codes returned by Lua operations (see: https://www.lua.org/manual/5.4/manual.html#4.4)
 const (
 	LUA_OK        = 0 // success
@@ -36,6 +53,10 @@ const (
 	LUA_ERRSYNTAX = 3 // syntax error
 	LUA_ERRMEM    = 4 // memory allocation error
 	LUA_ERRERR    = 5 // error while running the message handler
+	// LUA_ERRFILE reports a file or reader which cannot be read.
+	// It is 6 in Lua 5.1, 5.4 and 5.5 but 7 in Lua 5.3, which has LUA_ERRGCMM before it,
+	// errors created on the Go side such as Load read errors always use this value.
+	LUA_ERRFILE = 6
 )
 
 // Maximum Lua stack size (used for registry index calculation).
@@ -68,3 +89,26 @@ const (
 )
 
 const LUA_MINSTACK = 20
+
+// Debug hook event codes, reported by the Event field of Debug in a hook.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_Hook
+const (
+	LUA_HOOKCALL  = 0 // a function is called
+	LUA_HOOKRET   = 1 // a function returns
+	LUA_HOOKLINE  = 2 // a new line of code is about to be run
+	LUA_HOOKCOUNT = 3 // count instructions have been run
+	// LUA_HOOKTAILCALL reports a tail call, it is LUA_HOOKTAILRET in Lua 5.1.
+	LUA_HOOKTAILCALL = 4
+)
+
+// Debug hook masks, telling SetHook which events to report.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_sethook
+const (
+	LUA_MASKCALL  = 1 << LUA_HOOKCALL
+	LUA_MASKRET   = 1 << LUA_HOOKRET
+	LUA_MASKLINE  = 1 << LUA_HOOKLINE
+	LUA_MASKCOUNT = 1 << LUA_HOOKCOUNT
+)
+
+// LUA_IDSIZE is the size of the short_src field of lua_Debug in the default luaconf.h.
+const LUA_IDSIZE = 60
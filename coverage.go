@@ -0,0 +1,336 @@
+package lua
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Coverage collects the line coverage of the Lua code run by states, through a line hook.
+// It may be attached to several states, even concurrently running ones, whose coverage is merged,
+// and other collectors may be merged into it with Merge.
+//
+// The executable lines of a function and of the functions nested in it are known once it starts running,
+// from the line information of its binary chunk, see State.Dump, so that the functions which never run
+// lower the coverage. With Lua 5.5 and LuaJIT, whose binary chunks are not decoded, the executable lines
+// of a function are the active lines reported by lua_getinfo once the function has run,
+// so the lines of the functions which never run are not reported, except the line which defines them.
+type Coverage struct {
+	mu    sync.Mutex
+	files map[string]*sourceCoverage
+}
+
+// sourceCoverage is the coverage of the functions of a source.
+type sourceCoverage struct {
+	// lines maps the executable lines to their hits.
+	lines map[int]int
+	// decoded holds the lines defining the functions decoded from a binary chunk.
+	// The chunk holds every function defined on these lines, so their executable lines are all known.
+	decoded map[[2]int]bool
+	// closures holds the addresses of the other functions whose executable lines are known.
+	// Several functions may be defined on the same lines, so they are told apart by their closure.
+	closures map[uintptr]bool
+}
+
+func newSourceCoverage() *sourceCoverage {
+	return &sourceCoverage{
+		lines:    make(map[int]int),
+		decoded:  make(map[[2]int]bool),
+		closures: make(map[uintptr]bool),
+	}
+}
+
+// FileCoverage is the line coverage of a source.
+type FileCoverage struct {
+	// Source is the chunk name, such as "@script.lua" for a file.
+	Source string
+	// Lines maps the executable lines to the number of times they have been run.
+	Lines map[int]int
+}
+
+// Name returns the file name of a source starting with '@' or the name of a source starting with '=',
+// otherwise the source is a string chunk, which is named like Lua does in error messages.
+func (f FileCoverage) Name() string {
+	if name, ok := strings.CutPrefix(f.Source, "@"); ok {
+		return name
+	}
+	if name, ok := strings.CutPrefix(f.Source, "="); ok {
+		return name
+	}
+	// Like the short source of Lua, only the first line of a string chunk is kept.
+	first, _, multiline := strings.Cut(f.Source, "\n")
+	if multiline || len(first) > LUA_IDSIZE-15 {
+		first = first[:min(len(first), LUA_IDSIZE-15)] + "..."
+	}
+	return `[string "` + first + `"]`
+}
+
+// Hit returns the number of executable lines which have been run.
+func (f FileCoverage) Hit() (n int) {
+	for _, hits := range f.Lines {
+		if hits > 0 {
+			n++
+		}
+	}
+	return
+}
+
+// NewCoverage returns an empty coverage collector.
+func NewCoverage() *Coverage {
+	return &Coverage{files: make(map[string]*sourceCoverage)}
+}
+
+// Attach starts collecting the coverage of the thread L with a line hook.
+// When L is the main thread, the coroutines created afterwards are covered too.
+// The other coroutines must be attached themselves, including those created by an attached coroutine,
+// whose Go hook falls back to the one of the main thread, see SetHook.
+// Returns ErrHookInUse if the thread already has a debug hook.
+func (c *Coverage) Attach(L *State) error {
+	if L.GetHookMask() != 0 {
+		return ErrHookInUse
+	}
+	L.SetHook(c.hook, LUA_MASKLINE, 0)
+	return nil
+}
+
+// Detach stops collecting the coverage of the thread L.
+func (c *Coverage) Detach(L *State) {
+	L.SetHook(nil, 0, 0)
+}
+
+// hook records the line about to run and, for a new function, its active lines.
+func (c *Coverage) hook(L *State, ar *Debug) {
+	if !L.GetInfo("S", ar) || ar.What == "C" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.files[ar.Source]
+	if !ok {
+		f = newSourceCoverage()
+		c.files[ar.Source] = f
+	}
+
+	function := [2]int{ar.LineDefined, ar.LastLineDefined}
+	if !f.decoded[function] && L.GetInfo("f", ar) {
+		if closure := uintptr(L.ToPointer(-1)); !f.closures[closure] {
+			f.closures[closure] = true
+			if !f.addChunkLines(L) {
+				f.addActiveLines(L, ar)
+			}
+		}
+		L.Pop(1)
+	}
+	f.lines[ar.CurrentLine]++
+}
+
+// addChunkLines adds the executable lines of the function on the top of the stack and of its nested functions,
+// decoded from its binary chunk. Returns false if the chunk cannot be dumped or decoded.
+func (f *sourceCoverage) addChunkLines(L *State) bool {
+	var chunk bytes.Buffer
+	if err := L.Dump(&chunk, false); err != nil {
+		return false
+	}
+	functions, err := chunkFunctions(chunk.Bytes())
+	if err != nil {
+		return false
+	}
+
+	for _, fn := range functions {
+		f.decoded[[2]int{fn.lineDefined, fn.lastLineDefined}] = true
+		for _, line := range fn.lines {
+			f.addLine(line)
+		}
+	}
+	return true
+}
+
+// addActiveLines adds the active lines of the function running at ar.
+func (f *sourceCoverage) addActiveLines(L *State, ar *Debug) {
+	if !L.GetInfo("L", ar) {
+		return
+	}
+	if L.Type(-1) == LUA_TTABLE {
+		L.PushNil()
+		for L.Next(-2) {
+			f.addLine(int(L.ToInteger(-2)))
+			L.Pop(1)
+		}
+	}
+	L.Pop(1)
+}
+
+// addLine records line as executable.
+func (f *sourceCoverage) addLine(line int) {
+	if _, ok := f.lines[line]; !ok {
+		f.lines[line] = 0
+	}
+}
+
+// Merge adds the coverage collected by other.
+func (c *Coverage) Merge(other *Coverage) {
+	for _, file := range other.Files() {
+		c.mu.Lock()
+		f, ok := c.files[file.Source]
+		if !ok {
+			f = newSourceCoverage()
+			c.files[file.Source] = f
+		}
+		for line, hits := range file.Lines {
+			f.lines[line] += hits
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Files returns the coverage of each source, sorted by source.
+func (c *Coverage) Files() []FileCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files := make([]FileCoverage, 0, len(c.files))
+	for _, source := range slices.Sorted(maps.Keys(c.files)) {
+		files = append(files, FileCoverage{
+			Source: source,
+			Lines:  maps.Clone(c.files[source].lines),
+		})
+	}
+	return files
+}
+
+// WriteLCOV writes the coverage in the LCOV tracefile format, as read by genhtml and most coverage services.
+// See: https://manpages.debian.org/unstable/lcov/geninfo.1.en.html#TRACEFILE_FORMAT
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "TN:")
+	for _, f := range c.Files() {
+		fmt.Fprintf(bw, "SF:%s\n", f.Name())
+		for _, line := range slices.Sorted(maps.Keys(f.Lines)) {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, f.Lines[line])
+		}
+		fmt.Fprintf(bw, "LF:%d\n", len(f.Lines))
+		fmt.Fprintf(bw, "LH:%d\n", f.Hit())
+		fmt.Fprintln(bw, "end_of_record")
+	}
+	return bw.Flush()
+}
+
+// The elements of a Cobertura report.
+// See: https://github.com/cobertura/web/blob/master/htdocs/xml/coverage-04.dtd
+type (
+	coberturaCoverage struct {
+		XMLName         xml.Name           `xml:"coverage"`
+		LineRate        float64            `xml:"line-rate,attr"`
+		BranchRate      float64            `xml:"branch-rate,attr"`
+		LinesCovered    int                `xml:"lines-covered,attr"`
+		LinesValid      int                `xml:"lines-valid,attr"`
+		BranchesCovered int                `xml:"branches-covered,attr"`
+		BranchesValid   int                `xml:"branches-valid,attr"`
+		Complexity      float64            `xml:"complexity,attr"`
+		Version         string             `xml:"version,attr"`
+		Timestamp       int64              `xml:"timestamp,attr"`
+		Sources         []string           `xml:"sources>source"`
+		Packages        []coberturaPackage `xml:"packages>package"`
+	}
+	coberturaPackage struct {
+		Name       string           `xml:"name,attr"`
+		LineRate   float64          `xml:"line-rate,attr"`
+		BranchRate float64          `xml:"branch-rate,attr"`
+		Complexity float64          `xml:"complexity,attr"`
+		Classes    []coberturaClass `xml:"classes>class"`
+	}
+	coberturaClass struct {
+		Name       string          `xml:"name,attr"`
+		Filename   string          `xml:"filename,attr"`
+		LineRate   float64         `xml:"line-rate,attr"`
+		BranchRate float64         `xml:"branch-rate,attr"`
+		Complexity float64         `xml:"complexity,attr"`
+		Methods    struct{}        `xml:"methods"`
+		Lines      []coberturaLine `xml:"lines>line"`
+	}
+	coberturaLine struct {
+		Number int `xml:"number,attr"`
+		Hits   int `xml:"hits,attr"`
+	}
+)
+
+// WriteCobertura writes the coverage as a Cobertura XML report, as read by most CI systems.
+// Each directory of the sources is a package and each source a class.
+func (c *Coverage) WriteCobertura(w io.Writer) error {
+	report := coberturaCoverage{
+		Version:   "1.9",
+		Timestamp: time.Now().UnixMilli(),
+		Sources:   []string{"."},
+	}
+
+	packages := make(map[string]*coberturaPackage)
+	var names []string
+	for _, f := range c.Files() {
+		class := coberturaClass{
+			Name:     f.Name(),
+			Filename: f.Name(),
+			LineRate: rate(f.Hit(), len(f.Lines)),
+		}
+		for _, line := range slices.Sorted(maps.Keys(f.Lines)) {
+			class.Lines = append(class.Lines, coberturaLine{Number: line, Hits: f.Lines[line]})
+		}
+		report.LinesValid += len(f.Lines)
+		report.LinesCovered += f.Hit()
+
+		dir := filepath.Dir(f.Name())
+		pkg, ok := packages[dir]
+		if !ok {
+			pkg = &coberturaPackage{Name: dir}
+			packages[dir] = pkg
+			names = append(names, dir)
+		}
+		pkg.Classes = append(pkg.Classes, class)
+	}
+	report.LineRate = rate(report.LinesCovered, report.LinesValid)
+
+	slices.Sort(names)
+	for _, name := range names {
+		pkg := packages[name]
+		var covered, valid int
+		for _, class := range pkg.Classes {
+			for _, line := range class.Lines {
+				valid++
+				if line.Hits > 0 {
+					covered++
+				}
+			}
+		}
+		pkg.LineRate = rate(covered, valid)
+		report.Packages = append(report.Packages, *pkg)
+	}
+
+	if _, err := io.WriteString(w, xml.Header+
+		`<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// rate returns the ratio of covered to valid, or 1 when nothing is valid.
+func rate(covered, valid int) float64 {
+	if valid == 0 {
+		return 1
+	}
+	return float64(covered) / float64(valid)
+}
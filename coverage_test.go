@@ -0,0 +1,199 @@
+package lua_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+const coverageScript = `local function classify(n)
+  if n > 0 then
+    return "positive"
+  else
+    return "negative"
+  end
+end
+local result = classify(1)
+return result
+`
+
+func (s *Suite) TestCoverage(assert *require.Assertions, L *lua.State) {
+	c := lua.NewCoverage()
+	assert.NoError(c.Attach(L))
+	assert.ErrorIs(c.Attach(L), lua.ErrHookInUse)
+
+	assert.NoError(L.LoadBuffer([]byte(coverageScript), "@classify.lua"))
+	assert.NoError(L.PCall(0, 1, 0))
+	L.Pop(1)
+	c.Detach(L)
+
+	files := c.Files()
+	assert.Len(files, 1)
+	f := files[0]
+	assert.Equal("classify.lua", f.Name())
+	assert.Equal(1, f.Lines[2])
+	assert.Equal(1, f.Lines[3])
+	assert.Contains(f.Lines, 5)
+	assert.Zero(f.Lines[5])
+	assert.Positive(f.Lines[8])
+	assert.Less(f.Hit(), len(f.Lines))
+
+	var lcov bytes.Buffer
+	assert.NoError(c.WriteLCOV(&lcov))
+	assert.Contains(lcov.String(), "SF:classify.lua\n")
+	assert.Contains(lcov.String(), "DA:3,1\n")
+	assert.Contains(lcov.String(), "DA:5,0\n")
+	assert.True(strings.HasSuffix(lcov.String(), "end_of_record\n"))
+
+	var cobertura bytes.Buffer
+	assert.NoError(c.WriteCobertura(&cobertura))
+	var report struct {
+		LinesValid int `xml:"lines-valid,attr"`
+		Classes    []struct {
+			Filename string `xml:"filename,attr"`
+		} `xml:"packages>package>classes>class"`
+	}
+	assert.NoError(xml.Unmarshal(cobertura.Bytes(), &report))
+	assert.Equal(len(f.Lines), report.LinesValid)
+	assert.Equal("classify.lua", report.Classes[0].Filename)
+}
+
+func (s *Suite) TestCoverageMerge(assert *require.Assertions, t *testing.T) {
+	shared := lua.NewCoverage()
+	run := func(c *lua.Coverage, arg string) {
+		L := lua.NewState()
+		defer L.Close()
+		assert.NoError(c.Attach(L))
+		assert.NoError(L.LoadBuffer([]byte(strings.Replace(coverageScript, "classify(1)", arg, 1)), "@classify.lua"))
+		assert.NoError(L.PCall(0, 1, 0))
+	}
+	run(shared, "classify(1)")
+	run(shared, "classify(-1)")
+
+	other := lua.NewCoverage()
+	run(other, "classify(-1)")
+	shared.Merge(other)
+
+	files := shared.Files()
+	assert.Len(files, 1)
+	assert.Equal(1, files[0].Lines[3])
+	assert.Equal(2, files[0].Lines[5])
+	assert.Equal(len(files[0].Lines), files[0].Hit())
+}
+
+func (s *Suite) TestCoverageUncalledFunction(assert *require.Assertions, L *lua.State) {
+	c := lua.NewCoverage()
+	assert.NoError(c.Attach(L))
+	assert.NoError(L.LoadBuffer([]byte(`local function used(n)
+  return n + 1
+end
+local function unused(n)
+  local m = n * 2
+  return m
+end
+return used(1)
+`), "@unused.lua"))
+	assert.NoError(L.PCall(0, 1, 0))
+	L.Pop(1)
+	c.Detach(L)
+
+	f := c.Files()[0]
+	assert.Equal(1, f.Lines[2])
+	if L.Runtime().IsJIT() || L.Version() >= 505 {
+		// The binary chunks of these versions are not decoded.
+		assert.NotContains(f.Lines, 5)
+		return
+	}
+	assert.Contains(f.Lines, 5)
+	assert.Zero(f.Lines[5])
+	assert.Contains(f.Lines, 6)
+	assert.Zero(f.Lines[6])
+
+	var cobertura bytes.Buffer
+	assert.NoError(c.WriteCobertura(&cobertura))
+	var report struct {
+		LineRate float64 `xml:"line-rate,attr"`
+	}
+	assert.NoError(xml.Unmarshal(cobertura.Bytes(), &report))
+	assert.Less(report.LineRate, 1.0)
+	assert.InDelta(float64(f.Hit())/float64(len(f.Lines)), report.LineRate, 1e-9)
+}
+
+func (s *Suite) TestCoverageActiveLines(assert *require.Assertions, L *lua.State) {
+	const script = `local function outer(n)
+  local function inner(m)
+    return m * 2
+  end
+  return inner(n) + 1
+end
+local t = {}
+function t.method(x)
+  if x then
+    return x
+  end
+  return nil
+end
+return outer(1), t.method(false), t.method(2)
+`
+	// Every function runs, so the lines decoded from the chunk are the active lines of the functions.
+	L.PushString(script)
+	L.SetGlobal("script")
+	assert.NoError(L.DoString(`
+		active = {}
+		debug.sethook(function()
+			local info = debug.getinfo(2, "SL")
+			if info.source == "@active.lua" then
+				for line in pairs(info.activelines) do active[#active + 1] = line end
+			end
+		end, "l")
+		assert((loadstring or load)(script, "@active.lua"))()
+		debug.sethook()
+	`))
+	want := make(map[int]bool)
+	L.GetGlobal("active")
+	for i := 1; i <= int(L.RawLen(-1)); i++ {
+		L.RawGetI(-1, int64(i))
+		want[int(L.ToInteger(-1))] = true
+		L.Pop(1)
+	}
+	L.Pop(1)
+
+	c := lua.NewCoverage()
+	assert.NoError(c.Attach(L))
+	assert.NoError(L.LoadBuffer([]byte(script), "@active.lua"))
+	assert.NoError(L.PCall(0, 0, 0))
+	c.Detach(L)
+
+	got := make(map[int]bool)
+	for line := range c.Files()[0].Lines {
+		got[line] = true
+	}
+	assert.Equal(want, got)
+}
+
+func (s *Suite) TestCoverageSameLines(assert *require.Assertions, L *lua.State) {
+	c := lua.NewCoverage()
+	assert.NoError(c.Attach(L))
+	// Both functions are defined from line 1 to line 6.
+	assert.NoError(L.LoadBuffer([]byte(`local outer = function(x) return function()
+  if x then
+    return 1
+  end
+  return 2
+end end
+return outer(false)()
+`), "@same.lua"))
+	assert.NoError(L.PCall(0, 1, 0))
+	L.Pop(1)
+	c.Detach(L)
+
+	f := c.Files()[0]
+	assert.Positive(f.Lines[2])
+	assert.Contains(f.Lines, 3)
+	assert.Zero(f.Lines[3])
+	assert.Positive(f.Lines[5])
+}
@@ -0,0 +1,86 @@
+package lua
+
+import (
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+const (
+	// maxInternedCStrings bounds the number of strings interned by a runtime,
+	// so that keys built at run time cannot grow the cache without limit.
+	maxInternedCStrings = 4096
+	// maxInternedCStringLen is the length of the longest string interned by a runtime.
+	maxInternedCStringLen = 64
+)
+
+// cStringCache interns the NUL-terminated copies of the short strings passed as keys to the C API,
+// such as field, global and metatable names.
+// The copies live in the Go heap, which does not move objects, and are never released.
+type cStringCache struct {
+	mu sync.RWMutex
+	m  map[string]*byte
+}
+
+// emptyCString is the NUL-terminated empty string.
+var emptyCString byte
+
+// cString returns a NUL-terminated copy of s to be passed to the C API for the duration of a call.
+// Short strings are interned, so that repeated keys do not allocate.
+// The C side reads the string up to its first NUL byte.
+func (rt *Runtime) cString(s string) *byte {
+	if s == "" {
+		return &emptyCString
+	}
+	if len(s) > maxInternedCStringLen {
+		return newCString(s)
+	}
+
+	c := &rt.cStrings
+	c.mu.RLock()
+	p, ok := c.m[s]
+	c.mu.RUnlock()
+	if ok {
+		return p
+	}
+
+	p = newCString(s)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.m == nil {
+		c.m = make(map[string]*byte)
+	}
+	if len(c.m) < maxInternedCStrings {
+		// The key shares the memory of the copy.
+		c.m[unsafe.String(p, len(s))] = p
+	}
+	return p
+}
+
+func newCString(s string) *byte {
+	b := make([]byte, len(s)+1)
+	copy(b, s)
+	return &b[0]
+}
+
+// goStringN copies the n bytes at p into a Go string, embedded zeros included.
+func goStringN(p *byte, n int) string {
+	if p == nil || n == 0 {
+		return ""
+	}
+	return string(unsafe.Slice(p, n))
+}
+
+// cStringData returns a pointer to the bytes of s and their count up to the first NUL byte,
+// for the C API functions taking a pointer and a length, which copy the bytes before returning.
+func cStringData(s string) (p *byte, n int) {
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	if s == "" {
+		return &emptyCString, 0
+	}
+	return unsafe.StringData(s), len(s)
+}
@@ -0,0 +1,257 @@
+// Package dap is a Debug Adapter Protocol server for the Lua code run by a go.yuchanns.xyz/lua state,
+// so that editors such as VS Code can debug the scripts embedded in a Go program.
+//
+// The debugger supports line breakpoints, stepping in, over and out, stack traces,
+// the locals and upvalues of the frames, the evaluation of expressions in a frame,
+// and pausing the running state. It serves one client at a time, over any connection,
+// such as a local TCP socket with ListenAndServe or the standard streams with ServeStdio.
+//
+// The program keeps running its scripts as usual: while the debugger is stopped,
+// the goroutine running the state is blocked in a debug hook, where it inspects the state for the client.
+// See: https://microsoft.github.io/debug-adapter-protocol/specification
+package dap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.yuchanns.xyz/lua"
+)
+
+var (
+	// ErrSessionActive is returned when serving a client while another one is connected.
+	ErrSessionActive = errors.New("dap: a client is already connected")
+	// ErrClosed is returned when serving a client after Close.
+	ErrClosed = errors.New("dap: debugger closed")
+
+	errNotStopped = errors.New("the program is not stopped")
+)
+
+// threadID is the only thread reported to the client: the Lua thread running when the debugger stops.
+const threadID = 1
+
+// Debugger debugs the Lua code run by a state, and the coroutines it creates while a client is connected.
+type Debugger struct {
+	L *lua.State
+
+	configured     chan struct{}
+	configuredOnce sync.Once
+
+	// mu guards the fields below, which are shared by the goroutine serving the client and the hook.
+	mu       sync.Mutex
+	closed   bool
+	listener net.Listener
+	session  *session
+	// breakpoints maps the absolute paths of the files to their lines with a breakpoint.
+	breakpoints map[string]map[int]bool
+	// lines counts the breakpoints of each line, in any file, to skip the other lines quickly.
+	lines map[int]int
+	// paths caches the absolute paths of the sources of the chunks.
+	paths map[string]string
+	// pause is the reason to stop at the next line, if any.
+	pause string
+	step  step
+	// current is the stop the hook is blocked in, if any.
+	current *stop
+}
+
+// New returns a debugger of the state L, which is idle until a client is served.
+// It must be called while the thread of L is not running.
+func New(L *lua.State) *Debugger {
+	// The State held for the thread can have its hook set by Serve from another goroutine.
+	L.PushThread()
+	T := L.ToThread(-1)
+	L.Pop(1)
+
+	return &Debugger{
+		L:           T,
+		configured:  make(chan struct{}),
+		breakpoints: make(map[string]map[int]bool),
+		lines:       make(map[int]int),
+		paths:       make(map[string]string),
+	}
+}
+
+// WaitConfigured blocks until a client has finished configuring the debugger, such as setting its breakpoints,
+// so that a program may wait for the client before running the scripts to debug.
+func (d *Debugger) WaitConfigured(ctx context.Context) error {
+	select {
+	case <-d.configured:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Serve serves the client connected through rw until it disconnects or the connection fails.
+// The debug hook of the state is set while the client is connected,
+// and the state resumes running when it disconnects, with the breakpoints cleared.
+// Returns lua.ErrHookInUse if the state already has a debug hook.
+func (d *Debugger) Serve(rw io.ReadWriter) error {
+	s := &session{d: d, rw: rw, r: bufio.NewReader(rw), sources: make(map[string]int)}
+
+	d.mu.Lock()
+	switch {
+	case d.closed:
+		d.mu.Unlock()
+		return ErrClosed
+	case d.session != nil:
+		d.mu.Unlock()
+		return ErrSessionActive
+	case d.L.GetHookMask() != 0:
+		d.mu.Unlock()
+		return lua.ErrHookInUse
+	}
+	d.session = s
+	d.mu.Unlock()
+
+	d.L.SetHook(d.hook, lua.LUA_MASKLINE, 0)
+	defer d.end()
+
+	for {
+		content, err := readMessage(s.r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) {
+				return nil
+			}
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if s.handle(&req) {
+			return nil
+		}
+	}
+}
+
+// end removes the hook and the breakpoints once the client is gone, and resumes the state if it is stopped.
+func (d *Debugger) end() {
+	d.L.SetHook(nil, 0, 0)
+
+	d.mu.Lock()
+	d.session = nil
+	d.breakpoints = make(map[string]map[int]bool)
+	d.lines = make(map[int]int)
+	d.pause = ""
+	d.step = step{}
+	current := d.current
+	d.current = nil
+	d.mu.Unlock()
+
+	if current != nil {
+		current.resume <- struct{}{}
+	}
+}
+
+// ServeListener accepts the clients of l and serves them one after the other, until Close is called.
+func (d *Debugger) ServeListener(l net.Listener) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrClosed
+	}
+	d.listener = l
+	d.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			d.mu.Lock()
+			closed := d.closed
+			d.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		err = d.Serve(conn)
+		conn.Close()
+		if err != nil && !errors.Is(err, ErrClosed) {
+			return err
+		}
+	}
+}
+
+// ListenAndServe listens on the TCP address addr, such as "127.0.0.1:4711", and serves its clients, see ServeListener.
+func (d *Debugger) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return d.ServeListener(l)
+}
+
+// ServeStdio serves a client connected through the standard input and output of the process,
+// as editors launching the debug adapter do. The Lua code must not write to the standard output then.
+func (d *Debugger) ServeStdio() error {
+	return d.Serve(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout})
+}
+
+// Close stops listening, disconnects the client if its connection can be closed, and resumes the state.
+func (d *Debugger) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	l := d.listener
+	var conn io.Closer
+	if d.session != nil {
+		conn, _ = d.session.rw.(io.Closer)
+	}
+	d.mu.Unlock()
+
+	var err error
+	if l != nil {
+		err = l.Close()
+	}
+	if conn != nil {
+		err = errors.Join(err, conn.Close())
+	}
+	return err
+}
+
+// setBreakpoints replaces the breakpoints of the file path.
+func (d *Debugger) setBreakpoints(path string, lines []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for line := range d.breakpoints[path] {
+		if d.lines[line]--; d.lines[line] == 0 {
+			delete(d.lines, line)
+		}
+	}
+	delete(d.breakpoints, path)
+	if len(lines) == 0 {
+		return
+	}
+	set := make(map[int]bool, len(lines))
+	for _, line := range lines {
+		if !set[line] {
+			set[line] = true
+			d.lines[line]++
+		}
+	}
+	d.breakpoints[path] = set
+}
+
+// absPath returns the clean absolute path of the file name, relative to the working directory of the process.
+func absPath(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return filepath.Clean(name)
+}
@@ -0,0 +1,364 @@
+package dap_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+	"go.yuchanns.xyz/lua/dap"
+	"go.yuchanns.xyz/lua/luatest"
+)
+
+func TestMain(m *testing.M) {
+	luatest.Main(m)
+}
+
+// message is any message sent by the debugger.
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// client is a minimal DAP client, such as an editor.
+type client struct {
+	assert   *require.Assertions
+	conn     net.Conn
+	seq      int
+	messages chan message
+	events   []message
+}
+
+func newClient(assert *require.Assertions, conn net.Conn) *client {
+	c := &client{assert: assert, conn: conn, messages: make(chan message, 64)}
+	go func() {
+		defer close(c.messages)
+		r := bufio.NewReader(conn)
+		for {
+			length := 0
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				line = strings.TrimSpace(line)
+				if line == "" {
+					break
+				}
+				if v, ok := strings.CutPrefix(line, "Content-Length:"); ok {
+					length, _ = strconv.Atoi(strings.TrimSpace(v))
+				}
+			}
+			content := make([]byte, length)
+			if _, err := io.ReadFull(r, content); err != nil {
+				return
+			}
+			var m message
+			if json.Unmarshal(content, &m) == nil {
+				c.messages <- m
+			}
+		}
+	}()
+	return c
+}
+
+// next returns the next message sent by the debugger.
+func (c *client) next() message {
+	select {
+	case m, ok := <-c.messages:
+		c.assert.True(ok, "connection closed")
+		return m
+	case <-time.After(10 * time.Second):
+		c.assert.FailNow("timeout waiting for the debugger")
+		return message{}
+	}
+}
+
+// request sends a request and returns its response, decoding its body into body if not nil.
+func (c *client) request(command string, args, body any) message {
+	c.seq++
+	content, err := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	c.assert.NoError(err)
+	_, err = fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	c.assert.NoError(err)
+
+	for {
+		m := c.next()
+		if m.Type == "event" {
+			c.events = append(c.events, m)
+			continue
+		}
+		c.assert.Equal(c.seq, m.RequestSeq)
+		c.assert.Equal(command, m.Command)
+		if body != nil && m.Success {
+			c.assert.NoError(json.Unmarshal(m.Body, body))
+		}
+		return m
+	}
+}
+
+// call sends a request which must succeed.
+func (c *client) call(command string, args, body any) {
+	m := c.request(command, args, body)
+	c.assert.True(m.Success, "%s: %s", command, m.Message)
+}
+
+// event waits for the event named name, and returns its body.
+func (c *client) event(name string) json.RawMessage {
+	for {
+		var m message
+		if len(c.events) > 0 {
+			m, c.events = c.events[0], c.events[1:]
+		} else {
+			m = c.next()
+		}
+		if m.Type == "event" && m.Event == name {
+			return m.Body
+		}
+	}
+}
+
+// stopped waits for the debugger to stop, and returns the reason.
+func (c *client) stopped() string {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	c.assert.NoError(json.Unmarshal(c.event("stopped"), &body))
+	return body.Reason
+}
+
+type (
+	stackFrame struct {
+		ID     int    `json:"id"`
+		Name   string `json:"name"`
+		Line   int    `json:"line"`
+		Source *struct {
+			Path            string `json:"path"`
+			SourceReference int    `json:"sourceReference"`
+		} `json:"source"`
+	}
+	variable struct {
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+		VariablesReference int    `json:"variablesReference"`
+	}
+	evaluation struct {
+		Result             string `json:"result"`
+		VariablesReference int    `json:"variablesReference"`
+	}
+)
+
+func (c *client) stackTrace() []stackFrame {
+	var body struct {
+		StackFrames []stackFrame `json:"stackFrames"`
+	}
+	c.call("stackTrace", map[string]any{"threadId": 1}, &body)
+	c.assert.NotEmpty(body.StackFrames)
+	return body.StackFrames
+}
+
+func (c *client) variables(ref int) map[string]variable {
+	var body struct {
+		Variables []variable `json:"variables"`
+	}
+	c.call("variables", map[string]any{"variablesReference": ref}, &body)
+	vars := make(map[string]variable, len(body.Variables))
+	for _, v := range body.Variables {
+		vars[v.Name] = v
+	}
+	return vars
+}
+
+func (c *client) locals(frame int) map[string]variable {
+	var body struct {
+		Scopes []struct {
+			Name               string `json:"name"`
+			VariablesReference int    `json:"variablesReference"`
+		} `json:"scopes"`
+	}
+	c.call("scopes", map[string]any{"frameId": frame}, &body)
+	c.assert.Equal("Locals", body.Scopes[0].Name)
+	return c.variables(body.Scopes[0].VariablesReference)
+}
+
+func (c *client) evaluate(frame int, expression string) evaluation {
+	var body evaluation
+	c.call("evaluate", map[string]any{"frameId": frame, "expression": expression, "context": "repl"}, &body)
+	return body
+}
+
+const script = `local function add(a, b)
+  local sum = a + b
+  return sum
+end
+local t = {1, 2, name = "x"}
+local x = add(1, 2)
+local y = add(x, 10)
+return y
+`
+
+func TestDebugger(t *testing.T) {
+	assert := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "script.lua")
+	assert.NoError(os.WriteFile(path, []byte(script), 0o644))
+
+	L := lua.NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	d := dap.New(L)
+	server, conn := net.Pipe()
+	served := make(chan error, 1)
+	go func() { served <- d.Serve(server) }()
+	c := newClient(assert, conn)
+
+	c.call("initialize", map[string]any{"adapterID": "lua"}, nil)
+	c.event("initialized")
+	c.call("attach", map[string]any{}, nil)
+	var breakpoints struct {
+		Breakpoints []struct {
+			Verified bool `json:"verified"`
+		} `json:"breakpoints"`
+	}
+	c.call("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": path},
+		"breakpoints": []map[string]any{{"line": 6}},
+	}, &breakpoints)
+	assert.Len(breakpoints.Breakpoints, 1)
+	assert.True(breakpoints.Breakpoints[0].Verified)
+	c.call("configurationDone", nil, nil)
+	assert.NoError(d.WaitConfigured(context.Background()))
+
+	done := make(chan error, 1)
+	go func() { done <- L.DoFile(path) }()
+
+	assert.Equal("breakpoint", c.stopped())
+	frames := c.stackTrace()
+	assert.Equal("main chunk", frames[0].Name)
+	assert.Equal(6, frames[0].Line)
+	assert.Equal(path, frames[0].Source.Path)
+
+	c.call("stepIn", map[string]any{"threadId": 1}, nil)
+	assert.Equal("step", c.stopped())
+	frames = c.stackTrace()
+	assert.Equal("add", frames[0].Name)
+	assert.Equal(2, frames[0].Line)
+	locals := c.locals(frames[0].ID)
+	assert.Equal("1", locals["a"].Value)
+	assert.Equal("2", locals["b"].Value)
+	assert.NotContains(locals, "sum")
+
+	assert.Equal("21", c.evaluate(frames[0].ID, "a + b * 10").Result)
+	table := c.evaluate(frames[1].ID, "t")
+	assert.Positive(table.VariablesReference)
+	fields := c.variables(table.VariablesReference)
+	assert.Equal("1", fields["[1]"].Value)
+	assert.Equal("2", fields["[2]"].Value)
+	assert.Equal(`"x"`, fields["name"].Value)
+	m := c.request("evaluate", map[string]any{"frameId": frames[0].ID, "expression": "error('boom')"}, nil)
+	assert.False(m.Success)
+	assert.Contains(m.Message, "boom")
+
+	c.call("next", map[string]any{"threadId": 1}, nil)
+	assert.Equal("step", c.stopped())
+	frames = c.stackTrace()
+	assert.Equal(3, frames[0].Line)
+	assert.Equal("3", c.locals(frames[0].ID)["sum"].Value)
+
+	c.call("stepOut", map[string]any{"threadId": 1}, nil)
+	assert.Equal("step", c.stopped())
+	frames = c.stackTrace()
+	assert.Equal("main chunk", frames[0].Name)
+	assert.GreaterOrEqual(frames[0].Line, 6)
+
+	c.call("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": path},
+		"breakpoints": []map[string]any{{"line": 2}},
+	}, nil)
+	c.call("continue", map[string]any{"threadId": 1}, nil)
+	assert.Equal("breakpoint", c.stopped())
+	frames = c.stackTrace()
+	assert.Equal("add", frames[0].Name)
+	assert.Equal("3", c.evaluate(frames[0].ID, "a").Result)
+
+	c.call("setBreakpoints", map[string]any{"source": map[string]any{"path": path}, "breakpoints": []any{}}, nil)
+	c.call("continue", map[string]any{"threadId": 1}, nil)
+	assert.NoError(<-done)
+	assert.Equal(int64(13), L.ToInteger(-1))
+	L.Pop(1)
+
+	m = c.request("stackTrace", map[string]any{"threadId": 1}, nil)
+	assert.False(m.Success)
+
+	// Pause a running loop, which is stopped by the client.
+	go func() { done <- L.DoString("local n = 0\nwhile not stop do\n  n = n + 1\nend\nreturn n") }()
+	c.call("pause", map[string]any{"threadId": 1}, nil)
+	assert.Equal("pause", c.stopped())
+	frames = c.stackTrace()
+	assert.Positive(frames[0].Source.SourceReference)
+	var source struct {
+		Content string `json:"content"`
+	}
+	c.call("source", map[string]any{"sourceReference": frames[0].Source.SourceReference}, &source)
+	assert.Contains(source.Content, "while not stop do")
+	assert.Equal("", c.evaluate(frames[0].ID, "_G.stop = true").Result)
+	c.call("continue", map[string]any{"threadId": 1}, nil)
+	assert.NoError(<-done)
+	assert.Positive(L.ToInteger(-1))
+	L.Pop(1)
+
+	c.call("disconnect", nil, nil)
+	assert.NoError(<-served)
+	assert.Zero(L.GetHookMask())
+}
+
+func TestDebuggerDisconnectResumes(t *testing.T) {
+	assert := require.New(t)
+
+	L := lua.NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	d := dap.New(L)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	served := make(chan error, 1)
+	go func() { served <- d.ServeListener(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(err)
+	c := newClient(assert, conn)
+	c.call("initialize", nil, nil)
+	c.call("launch", map[string]any{"stopOnEntry": true}, nil)
+	c.call("configurationDone", nil, nil)
+
+	done := make(chan error, 1)
+	go func() { done <- L.DoString("local a = 1\nreturn a + 1") }()
+	assert.Equal("entry", c.stopped())
+	assert.NoError(conn.Close())
+
+	assert.NoError(<-done)
+	assert.Equal(int64(2), L.ToInteger(-1))
+	L.Pop(1)
+
+	assert.NoError(d.Close())
+	assert.NoError(<-served)
+	assert.ErrorIs(d.Serve(conn), dap.ErrClosed)
+}
@@ -0,0 +1,134 @@
+package dap
+
+import (
+	"strings"
+
+	"go.yuchanns.xyz/lua"
+)
+
+// stepKind is the kind of step requested by the client.
+type stepKind int
+
+const (
+	stepNone stepKind = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// step is the step in progress: the debugger stops at the next line run by L at a depth
+// of at most depth for stepOver, below depth for stepOut, and at any next line for stepIn.
+// Stepping over or out only stops in the thread where the step started.
+type step struct {
+	kind  stepKind
+	L     *lua.State
+	depth int
+}
+
+// stop is the state of the hook while the debugger is stopped.
+type stop struct {
+	L *lua.State
+	// depth is the number of levels of the call stack of L.
+	depth int
+	// calls are run by the hook on the goroutine running the state, to inspect it for the client.
+	calls chan func()
+	// resume is sent once the client resumes the state.
+	resume chan struct{}
+	// refs are the variables references given to the client, valid until the state resumes.
+	refs []reference
+}
+
+// hook is the line hook stopping the state on breakpoints, steps and pauses.
+func (d *Debugger) hook(L *lua.State, ar *lua.Debug) {
+	if ar.Event != lua.LUA_HOOKLINE {
+		return
+	}
+	if reason := d.stopReason(L, ar); reason != "" {
+		d.suspend(L, reason)
+	}
+}
+
+// stopReason returns why the debugger stops at the line about to run, or "" if it does not.
+func (d *Debugger) stopReason(L *lua.State, ar *lua.Debug) string {
+	d.mu.Lock()
+	pause, st, candidate := d.pause, d.step, d.lines[ar.CurrentLine] > 0
+	d.pause = ""
+	d.mu.Unlock()
+
+	if pause != "" {
+		return pause
+	}
+	switch st.kind {
+	case stepIn:
+		return "step"
+	case stepOver:
+		if L == st.L && depth(L) <= st.depth {
+			return "step"
+		}
+	case stepOut:
+		if L == st.L && depth(L) < st.depth {
+			return "step"
+		}
+	}
+	if !candidate || !L.GetInfo("S", ar) {
+		return ""
+	}
+	name, ok := strings.CutPrefix(ar.Source, "@")
+	if !ok {
+		return ""
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path, ok := d.paths[name]
+	if !ok {
+		path = absPath(name)
+		d.paths[name] = path
+	}
+	if d.breakpoints[path][ar.CurrentLine] {
+		return "breakpoint"
+	}
+	return ""
+}
+
+// suspend blocks the state until the client resumes it, running the calls inspecting it meanwhile.
+// The state is not suspended if no client is connected.
+func (d *Debugger) suspend(L *lua.State, reason string) {
+	st := &stop{
+		L:      L,
+		depth:  depth(L),
+		calls:  make(chan func()),
+		resume: make(chan struct{}, 1),
+	}
+
+	d.mu.Lock()
+	s := d.session
+	if s == nil {
+		d.mu.Unlock()
+		return
+	}
+	d.step = step{}
+	d.current = st
+	d.mu.Unlock()
+
+	s.event("stopped", stoppedBody{Reason: reason, ThreadID: threadID, AllThreadsStopped: true})
+	for {
+		select {
+		case fn := <-st.calls:
+			fn()
+		case <-st.resume:
+			st.release()
+			return
+		}
+	}
+}
+
+// depth returns the number of levels of the call stack of L.
+func depth(L *lua.State) (n int) {
+	for {
+		if _, ok := L.GetStack(n); !ok {
+			return
+		}
+		n++
+	}
+}
@@ -0,0 +1,325 @@
+package dap
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.yuchanns.xyz/lua"
+)
+
+// referenceKind is the kind of the variables a reference given to the client refers to.
+type referenceKind int
+
+const (
+	refLocals referenceKind = iota
+	refUpvalues
+	refTable
+)
+
+// reference is a variables reference: the locals or the upvalues of the frame at level,
+// or the fields of the table referenced in the registry by ref.
+type reference struct {
+	kind  referenceKind
+	level int
+	ref   int
+}
+
+// reference returns the variables reference of r, numbered from 1.
+func (st *stop) reference(r reference) int {
+	st.refs = append(st.refs, r)
+	return len(st.refs)
+}
+
+// release releases the tables referenced for the client, before the state resumes.
+func (st *stop) release() {
+	for _, r := range st.refs {
+		if r.kind == refTable {
+			st.L.Unref(lua.LUA_REGISTRYINDEX, r.ref)
+		}
+	}
+	st.refs = nil
+}
+
+// frame returns the activation record of the frame id, which is its level plus one.
+func (st *stop) frame(id int) (*lua.Debug, int, error) {
+	level := max(id-1, 0)
+	ar, ok := st.L.GetStack(level)
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown frame %d", id)
+	}
+	return ar, level, nil
+}
+
+// stackTrace returns the frames of the stopped thread, level 0 being the function running.
+func (s *session) stackTrace(st *stop, args *stackTraceArguments) stackTraceBody {
+	L := st.L
+	body := stackTraceBody{StackFrames: []stackFrame{}, TotalFrames: st.depth}
+	end := st.depth
+	if args.Levels > 0 {
+		end = min(end, args.StartFrame+args.Levels)
+	}
+	for level := args.StartFrame; level < end; level++ {
+		ar, ok := L.GetStack(level)
+		if !ok {
+			break
+		}
+		L.GetInfo("Sln", ar)
+		frame := stackFrame{ID: level + 1, Name: frameName(ar)}
+		if ar.What == "C" {
+			frame.PresentationHint = "subtle"
+		} else {
+			frame.Source = s.source(ar)
+			frame.Line = max(ar.CurrentLine, 0)
+			frame.Column = 1
+		}
+		body.StackFrames = append(body.StackFrames, frame)
+	}
+	return body
+}
+
+// frameName names the function of a frame the way Lua tracebacks do.
+func frameName(ar *lua.Debug) string {
+	switch {
+	case ar.What == "main":
+		return "main chunk"
+	case ar.Name != "":
+		return ar.Name
+	case ar.What == "C":
+		return "?"
+	default:
+		return fmt.Sprintf("function <%s:%d>", ar.ShortSrc, ar.LineDefined)
+	}
+}
+
+// source returns the source of the function of a frame: the file of a chunk loaded from a file,
+// otherwise a source reference to the contents of a string chunk.
+func (s *session) source(ar *lua.Debug) *source {
+	if name, ok := strings.CutPrefix(ar.Source, "@"); ok {
+		return &source{Name: filepath.Base(name), Path: absPath(name)}
+	}
+	if name, ok := strings.CutPrefix(ar.Source, "="); ok {
+		return &source{Name: name}
+	}
+	ref, ok := s.sources[ar.Source]
+	if !ok {
+		s.chunks = append(s.chunks, ar.Source)
+		ref = len(s.chunks)
+		s.sources[ar.Source] = ref
+	}
+	return &source{Name: ar.ShortSrc, SourceReference: ref}
+}
+
+// scopes returns the scopes of the frame id: its locals, its upvalues and the globals.
+func (st *stop) scopes(id int) (scopesBody, error) {
+	_, level, err := st.frame(id)
+	if err != nil {
+		return scopesBody{}, err
+	}
+	st.L.PushGlobalTable()
+	globals := st.reference(reference{kind: refTable, ref: st.L.Ref(lua.LUA_REGISTRYINDEX)})
+	return scopesBody{Scopes: []scope{
+		{Name: "Locals", VariablesReference: st.reference(reference{kind: refLocals, level: level})},
+		{Name: "Upvalues", VariablesReference: st.reference(reference{kind: refUpvalues, level: level})},
+		{Name: "Globals", VariablesReference: globals, Expensive: true},
+	}}, nil
+}
+
+// variables returns the variables of the reference id.
+func (st *stop) variables(id int) (variablesBody, error) {
+	if id < 1 || id > len(st.refs) {
+		return variablesBody{}, fmt.Errorf("unknown variables reference %d", id)
+	}
+	r := st.refs[id-1]
+	L := st.L
+	body := variablesBody{Variables: []variable{}}
+	switch r.kind {
+	case refLocals:
+		ar, ok := L.GetStack(r.level)
+		if !ok {
+			return body, fmt.Errorf("unknown frame %d", r.level+1)
+		}
+		for n := 1; ; n++ {
+			name := L.GetLocal(ar, n)
+			if name == "" {
+				break
+			}
+			// Names starting with '(' are internal variables, such as temporaries.
+			if !strings.HasPrefix(name, "(") {
+				body.Variables = append(body.Variables, st.variable(name))
+			}
+			L.Pop(1)
+		}
+	case refUpvalues:
+		ar, ok := L.GetStack(r.level)
+		if !ok {
+			return body, fmt.Errorf("unknown frame %d", r.level+1)
+		}
+		if !L.GetInfo("f", ar) {
+			break
+		}
+		for n := 1; ; n++ {
+			name := L.GetUpValue(-1, n)
+			if name == "" {
+				break
+			}
+			body.Variables = append(body.Variables, st.variable(name))
+			L.Pop(1)
+		}
+		L.Pop(1)
+	case refTable:
+		L.RawGetI(lua.LUA_REGISTRYINDEX, int64(r.ref))
+		body.Variables = st.fields()
+		L.Pop(1)
+	}
+	return body, nil
+}
+
+// fields returns the fields of the table on top of the stack, the integer keys first, in order.
+func (st *stop) fields() []variable {
+	type field struct {
+		integer bool
+		key     int64
+		v       variable
+	}
+	L := st.L
+	var fields []field
+	L.PushNil()
+	for L.Next(-2) {
+		var f field
+		switch {
+		case L.Type(-2) == lua.LUA_TSTRING:
+			f.v = st.variable(L.ToString(-2))
+		case L.Type(-2) == lua.LUA_TNUMBER && L.IsInteger(-2):
+			f.integer, f.key = true, L.ToInteger(-2)
+			f.v = st.variable("[" + display(L, -2) + "]")
+		default:
+			f.v = st.variable("[" + display(L, -2) + "]")
+		}
+		fields = append(fields, f)
+		L.Pop(1)
+	}
+	slices.SortStableFunc(fields, func(a, b field) int {
+		switch {
+		case a.integer && b.integer:
+			return cmp.Compare(a.key, b.key)
+		case a.integer != b.integer:
+			if a.integer {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.v.Name, b.v.Name)
+	})
+
+	variables := make([]variable, len(fields))
+	for i, f := range fields {
+		variables[i] = f.v
+	}
+	return variables
+}
+
+// variable describes the value on top of the stack, named name, which is left on the stack.
+// A table is given a reference to its fields.
+func (st *stop) variable(name string) variable {
+	L := st.L
+	v := variable{Name: name, Value: display(L, -1), Type: L.TypeName(L.Type(-1))}
+	if L.Type(-1) == lua.LUA_TTABLE {
+		L.PushValue(-1)
+		v.VariablesReference = st.reference(reference{kind: refTable, ref: L.Ref(lua.LUA_REGISTRYINDEX)})
+	}
+	return v
+}
+
+// display formats the value at idx without calling its metamethods, which could raise errors in the hook.
+func display(L *lua.State, idx int) string {
+	switch L.Type(idx) {
+	case lua.LUA_TNIL:
+		return "nil"
+	case lua.LUA_TBOOLEAN:
+		return strconv.FormatBool(L.ToBoolean(idx))
+	case lua.LUA_TNUMBER:
+		if L.IsInteger(idx) {
+			return strconv.FormatInt(L.ToInteger(idx), 10)
+		}
+		// The format of numbers of Lua, LUAI_NUMFFORMAT.
+		return strconv.FormatFloat(L.ToNumber(idx), 'g', 14, 64)
+	case lua.LUA_TSTRING:
+		return strconv.Quote(L.ToString(idx))
+	default:
+		return fmt.Sprintf("%s: %p", L.TypeName(L.Type(idx)), L.ToPointer(idx))
+	}
+}
+
+// evaluate evaluates an expression, or runs a statement, in the frame of the arguments.
+// The code sees the locals and upvalues of the frame as globals, falling back to the global table;
+// assigning them does not change the variables of the frame.
+func (st *stop) evaluate(args *evaluateArguments) (evaluateBody, error) {
+	L := st.L
+	ar, _, err := st.frame(args.FrameID)
+	if err != nil {
+		return evaluateBody{}, err
+	}
+	top := L.GetTop()
+	defer L.SetTop(top)
+
+	L.NewEnv()
+	env := L.GetTop()
+	if L.GetInfo("f", ar) {
+		for n := 1; ; n++ {
+			name := L.GetUpValue(-1, n)
+			if name == "" {
+				break
+			}
+			L.SetField(env, name)
+		}
+		L.Pop(1)
+	}
+	// The locals shadow the upvalues, and the later locals the earlier ones with the same name.
+	for n := 1; ; n++ {
+		name := L.GetLocal(ar, n)
+		if name == "" {
+			break
+		}
+		if strings.HasPrefix(name, "(") {
+			L.Pop(1)
+			continue
+		}
+		L.SetField(env, name)
+	}
+
+	if err := L.LoadBufferWithEnv([]byte("return "+args.Expression), "=eval", "", env); err != nil {
+		if err := L.LoadBufferWithEnv([]byte(args.Expression), "=eval", "", env); err != nil {
+			return evaluateBody{}, luaError(err)
+		}
+	}
+	base := L.GetTop()
+	if err := L.PCall(0, lua.LUA_MULTRET, 0); err != nil {
+		return evaluateBody{}, luaError(err)
+	}
+
+	var body evaluateBody
+	results := make([]string, 0, L.GetTop()-base+1)
+	for idx := base; idx <= L.GetTop(); idx++ {
+		results = append(results, display(L, idx))
+	}
+	body.Result = strings.Join(results, ", ")
+	if len(results) == 1 {
+		v := st.variable("")
+		body.Type, body.VariablesReference = v.Type, v.VariablesReference
+	}
+	return body, nil
+}
+
+// luaError returns the message of a Lua error, as shown to the client.
+func luaError(err error) error {
+	var e *lua.Error
+	if errors.As(err, &e) {
+		return errors.New(e.Message())
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The messages of the Debug Adapter Protocol used by the debugger.
+// See: https://microsoft.github.io/debug-adapter-protocol/specification
+type (
+	request struct {
+		Seq       int             `json:"seq"`
+		Type      string          `json:"type"`
+		Command   string          `json:"command"`
+		Arguments json.RawMessage `json:"arguments,omitempty"`
+	}
+	response struct {
+		Seq        int    `json:"seq"`
+		Type       string `json:"type"`
+		RequestSeq int    `json:"request_seq"`
+		Success    bool   `json:"success"`
+		Command    string `json:"command"`
+		Message    string `json:"message,omitempty"`
+		Body       any    `json:"body,omitempty"`
+	}
+	event struct {
+		Seq   int    `json:"seq"`
+		Type  string `json:"type"`
+		Event string `json:"event"`
+		Body  any    `json:"body,omitempty"`
+	}
+)
+
+// The arguments and bodies of the requests, responses and events used by the debugger.
+type (
+	capabilities struct {
+		SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+		SupportsEvaluateForHovers        bool `json:"supportsEvaluateForHovers"`
+	}
+	launchArguments struct {
+		StopOnEntry bool `json:"stopOnEntry"`
+	}
+	source struct {
+		Name            string `json:"name,omitempty"`
+		Path            string `json:"path,omitempty"`
+		SourceReference int    `json:"sourceReference,omitempty"`
+	}
+	sourceBreakpoint struct {
+		Line int `json:"line"`
+	}
+	setBreakpointsArguments struct {
+		Source      source             `json:"source"`
+		Breakpoints []sourceBreakpoint `json:"breakpoints"`
+	}
+	breakpoint struct {
+		Verified bool `json:"verified"`
+		Line     int  `json:"line"`
+	}
+	breakpointsBody struct {
+		Breakpoints []breakpoint `json:"breakpoints"`
+	}
+	thread struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	threadsBody struct {
+		Threads []thread `json:"threads"`
+	}
+	stoppedBody struct {
+		Reason            string `json:"reason"`
+		ThreadID          int    `json:"threadId"`
+		AllThreadsStopped bool   `json:"allThreadsStopped"`
+	}
+	continueBody struct {
+		AllThreadsContinued bool `json:"allThreadsContinued"`
+	}
+	stackTraceArguments struct {
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}
+	stackFrame struct {
+		ID               int     `json:"id"`
+		Name             string  `json:"name"`
+		Source           *source `json:"source,omitempty"`
+		Line             int     `json:"line"`
+		Column           int     `json:"column"`
+		PresentationHint string  `json:"presentationHint,omitempty"`
+	}
+	stackTraceBody struct {
+		StackFrames []stackFrame `json:"stackFrames"`
+		TotalFrames int          `json:"totalFrames"`
+	}
+	scopesArguments struct {
+		FrameID int `json:"frameId"`
+	}
+	scope struct {
+		Name               string `json:"name"`
+		VariablesReference int    `json:"variablesReference"`
+		Expensive          bool   `json:"expensive"`
+	}
+	scopesBody struct {
+		Scopes []scope `json:"scopes"`
+	}
+	variablesArguments struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	variable struct {
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+		Type               string `json:"type"`
+		VariablesReference int    `json:"variablesReference"`
+	}
+	variablesBody struct {
+		Variables []variable `json:"variables"`
+	}
+	evaluateArguments struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+	evaluateBody struct {
+		Result             string `json:"result"`
+		Type               string `json:"type,omitempty"`
+		VariablesReference int    `json:"variablesReference"`
+	}
+	sourceArguments struct {
+		SourceReference int `json:"sourceReference"`
+	}
+	sourceBody struct {
+		Content string `json:"content"`
+	}
+)
+
+// readMessage reads the content of a message, which follows a header giving its length.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil || length < 0 {
+				return nil, fmt.Errorf("dap: invalid content length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("dap: missing content length")
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// writeMessage writes the message m encoded in JSON, after the header giving its length.
+func writeMessage(w io.Writer, m any) error {
+	content, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(content)); err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
@@ -0,0 +1,198 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// session is the connection of a client.
+type session struct {
+	d  *Debugger
+	rw io.ReadWriter
+	r  *bufio.Reader
+
+	// mu guards the writes, done by the goroutine serving the client and the hook.
+	mu  sync.Mutex
+	seq int
+
+	// sources maps the string chunks shown to the client to their source references, chunks are their contents.
+	// They are used by the requests, handled one at a time.
+	sources map[string]int
+	chunks  []string
+}
+
+// send writes a message with the next sequence number, set by seq.
+func (s *session) send(m any, seq *int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	*seq = s.seq
+	// A write error ends the session through the next read.
+	_ = writeMessage(s.rw, m)
+}
+
+// respond sends the response to req, an error response when err is not nil.
+func (s *session) respond(req *request, body any, err error) {
+	resp := &response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+		resp.Body = nil
+	}
+	s.send(resp, &resp.Seq)
+}
+
+// event sends the event named name.
+func (s *session) event(name string, body any) {
+	e := &event{Type: "event", Event: name, Body: body}
+	s.send(e, &e.Seq)
+}
+
+// handle handles the request req and reports whether the client disconnected.
+func (s *session) handle(req *request) (done bool) {
+	var (
+		body any
+		err  error
+	)
+	switch req.Command {
+	case "initialize":
+		s.respond(req, capabilities{SupportsConfigurationDoneRequest: true, SupportsEvaluateForHovers: true}, nil)
+		s.event("initialized", nil)
+		return false
+	case "launch", "attach":
+		// The program runs its scripts itself, so launching and attaching only connect the client.
+		var args launchArguments
+		if err = arguments(req, &args); err == nil && args.StopOnEntry {
+			s.d.mu.Lock()
+			s.d.pause = "entry"
+			s.d.mu.Unlock()
+		}
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err = arguments(req, &args); err == nil {
+			body = s.setBreakpoints(&args)
+		}
+	case "setExceptionBreakpoints":
+		// Errors are not breakpoints, the empty set of filters is accepted.
+	case "configurationDone":
+		s.d.configuredOnce.Do(func() { close(s.d.configured) })
+	case "threads":
+		body = threadsBody{Threads: []thread{{ID: threadID, Name: "main"}}}
+	case "stackTrace":
+		var args stackTraceArguments
+		if err = arguments(req, &args); err == nil {
+			body, err = s.inspect(func(st *stop) (any, error) { return s.stackTrace(st, &args), nil })
+		}
+	case "scopes":
+		var args scopesArguments
+		if err = arguments(req, &args); err == nil {
+			body, err = s.inspect(func(st *stop) (any, error) { return st.scopes(args.FrameID) })
+		}
+	case "variables":
+		var args variablesArguments
+		if err = arguments(req, &args); err == nil {
+			body, err = s.inspect(func(st *stop) (any, error) { return st.variables(args.VariablesReference) })
+		}
+	case "evaluate":
+		var args evaluateArguments
+		if err = arguments(req, &args); err == nil {
+			body, err = s.inspect(func(st *stop) (any, error) { return st.evaluate(&args) })
+		}
+	case "source":
+		var args sourceArguments
+		if err = arguments(req, &args); err == nil {
+			if args.SourceReference < 1 || args.SourceReference > len(s.chunks) {
+				err = fmt.Errorf("unknown source reference %d", args.SourceReference)
+			} else {
+				body = sourceBody{Content: s.chunks[args.SourceReference-1]}
+			}
+		}
+	case "continue":
+		s.resume(req, stepNone)
+		return false
+	case "next":
+		s.resume(req, stepOver)
+		return false
+	case "stepIn":
+		s.resume(req, stepIn)
+		return false
+	case "stepOut":
+		s.resume(req, stepOut)
+		return false
+	case "pause":
+		s.d.mu.Lock()
+		s.d.pause = "pause"
+		s.d.mu.Unlock()
+	case "disconnect", "terminate":
+		s.respond(req, nil, nil)
+		return true
+	default:
+		err = fmt.Errorf("unsupported request %q", req.Command)
+	}
+	s.respond(req, body, err)
+	return false
+}
+
+// arguments decodes the arguments of req into args.
+func arguments(req *request, args any) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	return json.Unmarshal(req.Arguments, args)
+}
+
+// setBreakpoints replaces the breakpoints of a file, which are verified once set
+// since the lines of a chunk are only known once it is loaded.
+func (s *session) setBreakpoints(args *setBreakpointsArguments) breakpointsBody {
+	lines := make([]int, 0, len(args.Breakpoints))
+	body := breakpointsBody{Breakpoints: make([]breakpoint, 0, len(args.Breakpoints))}
+	for _, b := range args.Breakpoints {
+		lines = append(lines, b.Line)
+		body.Breakpoints = append(body.Breakpoints, breakpoint{Verified: true, Line: b.Line})
+	}
+	s.d.setBreakpoints(absPath(args.Source.Path), lines)
+	return body
+}
+
+// resume responds to req and resumes the stopped state for the step kind.
+func (s *session) resume(req *request, kind stepKind) {
+	s.d.mu.Lock()
+	st := s.d.current
+	if st == nil {
+		s.d.mu.Unlock()
+		s.respond(req, nil, errNotStopped)
+		return
+	}
+	s.d.current = nil
+	s.d.step = step{kind: kind, L: st.L, depth: st.depth}
+	s.d.mu.Unlock()
+
+	var body any
+	if req.Command == "continue" {
+		body = continueBody{AllThreadsContinued: true}
+	}
+	// The response precedes the events of the resumed state.
+	s.respond(req, body, nil)
+	st.resume <- struct{}{}
+}
+
+// inspect runs fn on the goroutine running the stopped state, and returns its results.
+func (s *session) inspect(fn func(st *stop) (any, error)) (body any, err error) {
+	s.d.mu.Lock()
+	st := s.d.current
+	s.d.mu.Unlock()
+	if st == nil {
+		return nil, errNotStopped
+	}
+
+	done := make(chan struct{})
+	st.calls <- func() {
+		defer close(done)
+		body, err = fn(st)
+	}
+	<-done
+	return
+}
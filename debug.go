@@ -0,0 +1,338 @@
+package lua
+
+import (
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// luaDebug51 is the lua_Debug of Lua 5.1 and LuaJIT.
+type luaDebug51 struct {
+	event           int32
+	name            *byte
+	namewhat        *byte
+	what            *byte
+	source          *byte
+	currentline     int32
+	nups            int32
+	linedefined     int32
+	lastlinedefined int32
+	shortSrc        [LUA_IDSIZE]byte
+	iCI             int32
+}
+
+// luaDebug53 is the lua_Debug of Lua 5.3.
+type luaDebug53 struct {
+	event           int32
+	name            *byte
+	namewhat        *byte
+	what            *byte
+	source          *byte
+	currentline     int32
+	linedefined     int32
+	lastlinedefined int32
+	nups            uint8
+	nparams         uint8
+	isvararg        int8
+	istailcall      int8
+	shortSrc        [LUA_IDSIZE]byte
+	iCI             unsafe.Pointer
+}
+
+// luaDebug54 is the lua_Debug of Lua 5.4.
+type luaDebug54 struct {
+	event           int32
+	name            *byte
+	namewhat        *byte
+	what            *byte
+	source          *byte
+	srclen          uintptr
+	currentline     int32
+	linedefined     int32
+	lastlinedefined int32
+	nups            uint8
+	nparams         uint8
+	isvararg        int8
+	istailcall      int8
+	ftransfer       uint16
+	ntransfer       uint16
+	shortSrc        [LUA_IDSIZE]byte
+	iCI             unsafe.Pointer
+}
+
+// luaDebug55 is the lua_Debug of Lua 5.5, which counts the extra arguments of vararg functions.
+type luaDebug55 struct {
+	event           int32
+	name            *byte
+	namewhat        *byte
+	what            *byte
+	source          *byte
+	srclen          uintptr
+	currentline     int32
+	linedefined     int32
+	lastlinedefined int32
+	nups            uint8
+	nparams         uint8
+	isvararg        int8
+	extraargs       uint8
+	istailcall      int8
+	ftransfer       int32
+	ntransfer       int32
+	shortSrc        [LUA_IDSIZE]byte
+	iCI             unsafe.Pointer
+}
+
+// debugRecord is large enough to hold the lua_Debug of any supported version, with its alignment.
+type debugRecord [(unsafe.Sizeof(luaDebug55{}) + 7) / 8]uint64
+
+// Debug carries information about a function or an activation record, the Go side of lua_Debug.
+// Hooks only fill Event and CurrentLine.
+// The other fields are filled by GetInfo, according to its what argument.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_Debug
+type Debug struct {
+	// Event is the hook event, such as LUA_HOOKLINE.
+	Event int
+	// Name is a reasonable name for the function (n).
+	Name string
+	// NameWhat explains the Name field: "global", "local", "method", "field", "upvalue" or "" (n).
+	NameWhat string
+	// What is "Lua" for a Lua function, "C" for a C function, "main" for the main part of a chunk (S).
+	What string
+	// Source is the source of the chunk that created the function (S).
+	// A source starting with '@' is a file name, one starting with '=' is a user defined name.
+	Source string
+	// ShortSrc is a printable version of Source, to be used in error messages (S).
+	ShortSrc string
+	// CurrentLine is the current line where the function is executing, or -1 (l).
+	CurrentLine int
+	// LineDefined and LastLineDefined are the lines where the definition of the function starts and ends (S).
+	LineDefined     int
+	LastLineDefined int
+	// NUps is the number of upvalues of the function (u).
+	NUps int
+	// NParams is the number of parameters of the function, always 0 for C functions (u), since Lua 5.2.
+	NParams int
+	// IsVararg tells whether the function is a vararg function (u), since Lua 5.2.
+	IsVararg bool
+	// IsTailCall tells whether the function invocation was called by a tail call (t), since Lua 5.2.
+	IsTailCall bool
+
+	// ar is the lua_Debug the fields are read from: the one given to a hook, or record.
+	ar unsafe.Pointer
+	// record holds the lua_Debug of a Debug created on the Go side, such as by GetStack.
+	record *debugRecord
+}
+
+// pointer returns the lua_Debug of the record, allocating it for a new Debug.
+func (ar *Debug) pointer() unsafe.Pointer {
+	if ar.ar == nil {
+		ar.record = new(debugRecord)
+		ar.ar = unsafe.Pointer(ar.record)
+	}
+	return ar.ar
+}
+
+// debugFields are the raw fields of a lua_Debug of any version.
+type debugFields struct {
+	event                                     int32
+	name, namewhat, what, source              *byte
+	srclen                                    int
+	currentline, linedefined, lastlinedefined int32
+	nups, nparams                             int
+	isvararg, istailcall                      bool
+	shortSrc                                  *byte
+}
+
+// debugFields copies the raw fields of the lua_Debug ar, without reading the memory they point to.
+// srclen is -1 before Lua 5.4.
+func (ffi *ffi) debugFields(ar unsafe.Pointer) (f debugFields) {
+	switch {
+	case ffi.version < 503:
+		d := (*luaDebug51)(ar)
+		f = debugFields{d.event, d.name, d.namewhat, d.what, d.source, -1,
+			d.currentline, d.linedefined, d.lastlinedefined, int(d.nups), 0, false, false, &d.shortSrc[0]}
+	case ffi.version < 504:
+		d := (*luaDebug53)(ar)
+		f = debugFields{d.event, d.name, d.namewhat, d.what, d.source, -1,
+			d.currentline, d.linedefined, d.lastlinedefined, int(d.nups), int(d.nparams), d.isvararg != 0, d.istailcall != 0, &d.shortSrc[0]}
+	case ffi.version < 505:
+		d := (*luaDebug54)(ar)
+		f = debugFields{d.event, d.name, d.namewhat, d.what, d.source, int(d.srclen),
+			d.currentline, d.linedefined, d.lastlinedefined, int(d.nups), int(d.nparams), d.isvararg != 0, d.istailcall != 0, &d.shortSrc[0]}
+	default:
+		d := (*luaDebug55)(ar)
+		f = debugFields{d.event, d.name, d.namewhat, d.what, d.source, int(d.srclen),
+			d.currentline, d.linedefined, d.lastlinedefined, int(d.nups), int(d.nparams), d.isvararg != 0, d.istailcall != 0, &d.shortSrc[0]}
+	}
+	return
+}
+
+// readDebug fills the fields of ar selected by the options of what from its lua_Debug.
+// The other fields of a lua_Debug given to a hook are not initialized, so they are left alone.
+func (ffi *ffi) readDebug(ar *Debug, what string) {
+	f := ffi.debugFields(ar.ar)
+	ar.Event = int(f.event)
+	for _, option := range what {
+		switch option {
+		case 'S':
+			ar.What = bytePtrToString(f.what)
+			if f.srclen >= 0 && f.source != nil {
+				// The source may contain embedded zeros since Lua 5.4.
+				ar.Source = goStringN(f.source, f.srclen)
+			} else {
+				ar.Source = bytePtrToString(f.source)
+			}
+			ar.ShortSrc = bytePtrToString(f.shortSrc)
+			ar.LineDefined = int(f.linedefined)
+			ar.LastLineDefined = int(f.lastlinedefined)
+		case 'l':
+			ar.CurrentLine = int(f.currentline)
+		case 'n':
+			ar.Name = bytePtrToString(f.name)
+			ar.NameWhat = bytePtrToString(f.namewhat)
+		case 'u':
+			ar.NUps = f.nups
+			ar.NParams = f.nparams
+			ar.IsVararg = f.isvararg
+		case 't':
+			ar.IsTailCall = f.istailcall
+		}
+	}
+}
+
+// GetStack gets information about the interpreter runtime stack.
+// Level 0 is the current running function, whereas level n+1 is the function that has called level n,
+// except for tail calls, which do not count in the stack.
+// Returns false when called with a level greater than the stack depth.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_getstack
+func (s *State) GetStack(level int) (ar *Debug, ok bool) {
+	ar = &Debug{}
+	if s.rt.ffi.LuaGetstack(s.luaL, level, ar.pointer()) == 0 {
+		return nil, false
+	}
+	return ar, true
+}
+
+// GetInfo gets information about a specific function or function invocation.
+// ar must be a Debug returned by GetStack or given to a hook, to get information about a function invocation.
+// To get information about a function, push it onto the stack, start the what string with '>'
+// and give a new Debug, the function is popped from the stack.
+// The characters of what select the fields to fill, see the fields of Debug;
+// 'f' pushes the running function and 'L' a table of its valid lines onto the stack.
+// Returns false on error, such as an invalid option in what.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_getinfo
+func (s *State) GetInfo(what string, ar *Debug) bool {
+	p := s.rt.cString(what)
+	ok := s.rt.ffi.LuaGetinfo(s.luaL, p, ar.pointer()) != 0
+	if ok {
+		s.rt.ffi.readDebug(ar, what)
+	}
+	return ok
+}
+
+// GetLocal pushes the value of the local variable n of the activation record ar onto the stack
+// and returns its name, the first parameter or active local variable being 1.
+// Names starting with '(' are internal variables, such as loop control variables and temporaries;
+// negative n are the variable arguments, since Lua 5.2.
+// Returns "" and pushes nothing when n is greater than the number of active local variables.
+// With a nil ar, the names of the parameters of the function on top of the stack are returned,
+// and nothing is pushed, since Lua 5.2.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_getlocal
+func (s *State) GetLocal(ar *Debug, n int) string {
+	var p unsafe.Pointer
+	if ar != nil {
+		p = ar.pointer()
+	}
+	return bytePtrToString(s.rt.ffi.LuaGetlocal(s.luaL, p, n))
+}
+
+// SetLocal assigns the value on top of the stack to the local variable n of the activation record ar,
+// pops it and returns the name of the variable, see GetLocal.
+// Returns "" and pops nothing when n is greater than the number of active local variables.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_setlocal
+func (s *State) SetLocal(ar *Debug, n int) string {
+	return bytePtrToString(s.rt.ffi.LuaSetlocal(s.luaL, ar.pointer(), n))
+}
+
+// HookFunc is a Go debug hook, called with the State of the running thread and the record of the event.
+// The record is only valid during the call, information about the running function is available
+// through GetInfo, and about the other levels through GetStack.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_Hook
+type HookFunc func(L *State, ar *Debug)
+
+// SetHook sets the debugging hook function of the thread, or removes it when f is nil or mask is zero.
+// mask combines LUA_MASKCALL, LUA_MASKRET, LUA_MASKLINE and LUA_MASKCOUNT,
+// count is the number of instructions between the count events.
+// Coroutines created afterwards by the thread inherit its hook.
+// The Go function of a thread which has not set its own is the one of its main thread.
+// It is safe to call SetHook from another goroutine while the thread runs, as lua_sethook is,
+// only on the State the binding holds for the thread, such as the one returned by NewState, NewThread,
+// ToThread or passed to callbacks. Other States, such as those of BuildState, are resolved
+// to it by Lua calls on the thread, which must not run meanwhile.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_sethook
+func (s *State) SetHook(f HookFunc, mask, count int) {
+	c := s.canonical()
+	g := c.group
+	g.mu.Lock()
+	c.hook = f
+	g.mu.Unlock()
+
+	if f == nil || mask == 0 {
+		s.rt.ffi.LuaSethook(s.luaL, 0, 0, 0)
+		return
+	}
+	s.rt.ffi.LuaSethook(s.luaL, s.rt.hookCallback(), mask, count)
+}
+
+// GetHook returns the Go debugging hook function of the thread, or nil.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_gethook
+func (s *State) GetHook() HookFunc {
+	if s.rt.ffi.LuaGethook(s.luaL) == 0 {
+		return nil
+	}
+	return s.hookFunc()
+}
+
+// GetHookMask returns the current hook mask of the thread.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_gethookmask
+func (s *State) GetHookMask() int {
+	return s.rt.ffi.LuaGethookmask(s.luaL)
+}
+
+// GetHookCount returns the current hook count of the thread.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_gethookcount
+func (s *State) GetHookCount() int {
+	return s.rt.ffi.LuaGethookcount(s.luaL)
+}
+
+// hookFunc returns the Go hook of the thread, falling back to the one of its main thread.
+func (s *State) hookFunc() HookFunc {
+	c := s.canonical()
+	g := c.group
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if c.hook != nil {
+		return c.hook
+	}
+	return g.main.hook
+}
+
+// hookCallback returns the lua_Hook calling the Go hooks of the threads.
+// It is created once per runtime because purego callbacks are never released.
+func (rt *Runtime) hookCallback() uintptr {
+	rt.hookOnce.Do(func() {
+		rt.hookf = purego.NewCallback(func(L, ar unsafe.Pointer) {
+			s := rt.state(L, nil)
+			f := s.hookFunc()
+			if f == nil {
+				return
+			}
+			d := &Debug{ar: ar}
+			// The hook only gets the event and, for line events, the current line.
+			rt.ffi.readDebug(d, "l")
+			f(s, d)
+		})
+	})
+	return rt.hookf
+}
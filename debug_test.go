@@ -0,0 +1,127 @@
+package lua_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestGetStackGetInfo(assert *require.Assertions, L *lua.State) {
+	var (
+		caller *lua.Debug
+		depth  int
+	)
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		ar, ok := L.GetStack(0)
+		assert.True(ok)
+		assert.True(L.GetInfo("Sn", ar))
+		assert.Equal("C", ar.What)
+
+		caller, ok = L.GetStack(1)
+		assert.True(ok)
+		assert.True(L.GetInfo("Slnu", caller))
+
+		for depth = 0; ; depth++ {
+			if _, ok := L.GetStack(depth); !ok {
+				break
+			}
+		}
+		return 0
+	}))
+	L.SetGlobal("inspect")
+
+	assert.NoError(L.LoadBuffer([]byte("local function probe(a, b)\n  inspect()\n  return a\nend\nprobe(1, 2)\n"), "=probe.lua"))
+	assert.NoError(L.PCall(0, 0, 0))
+
+	assert.Equal("Lua", caller.What)
+	assert.Equal("probe", caller.Name)
+	assert.Equal("local", caller.NameWhat)
+	assert.Equal("=probe.lua", caller.Source)
+	assert.Equal("probe.lua", caller.ShortSrc)
+	assert.Equal(2, caller.CurrentLine)
+	assert.Equal(1, caller.LineDefined)
+	assert.Equal(4, caller.LastLineDefined)
+	if L.Version() >= 502 {
+		assert.Equal(2, caller.NParams)
+	}
+	assert.Equal(3, depth)
+
+	_, ok := L.GetStack(0)
+	assert.False(ok)
+
+	L.GetGlobal("inspect")
+	ar := &lua.Debug{}
+	assert.True(L.GetInfo(">S", ar))
+	assert.Equal("C", ar.What)
+	assert.Equal(0, L.GetTop())
+}
+
+func (s *Suite) TestSetHook(assert *require.Assertions, L *lua.State) {
+	var (
+		lines []int
+		calls int
+	)
+	L.SetHook(func(L *lua.State, ar *lua.Debug) {
+		switch ar.Event {
+		case lua.LUA_HOOKLINE:
+			lines = append(lines, ar.CurrentLine)
+		case lua.LUA_HOOKCALL:
+			calls++
+		}
+	}, lua.LUA_MASKLINE|lua.LUA_MASKCALL, 0)
+	assert.Equal(lua.LUA_MASKLINE|lua.LUA_MASKCALL, L.GetHookMask())
+	assert.NotNil(L.GetHook())
+
+	assert.NoError(L.DoString("local a = 1\nlocal b = 2\nreturn a + b"))
+	L.Pop(1)
+	L.SetHook(nil, 0, 0)
+	assert.Nil(L.GetHook())
+	assert.Zero(L.GetHookMask())
+
+	assert.Equal([]int{1, 2, 3}, lines)
+	assert.Positive(calls)
+
+	var counts int
+	L.SetHook(func(L *lua.State, ar *lua.Debug) {
+		assert.Equal(lua.LUA_HOOKCOUNT, ar.Event)
+		counts++
+	}, lua.LUA_MASKCOUNT, 10)
+	assert.Equal(10, L.GetHookCount())
+	assert.NoError(L.DoString("local n = 0 for i = 1, 1000 do n = n + i end"))
+	L.SetHook(nil, 0, 0)
+	assert.Greater(counts, 10)
+}
+
+func (s *Suite) TestGetSetLocal(assert *require.Assertions, L *lua.State) {
+	locals := map[string]int64{}
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		ar, ok := L.GetStack(1)
+		assert.True(ok)
+		for n := 1; ; n++ {
+			name := L.GetLocal(ar, n)
+			if name == "" {
+				break
+			}
+			locals[name] = L.ToInteger(-1)
+			L.Pop(1)
+		}
+		assert.Empty(L.GetLocal(ar, 100))
+
+		L.PushInteger(42)
+		assert.Equal("a", L.SetLocal(ar, 1))
+		return 0
+	}))
+	L.SetGlobal("inspect")
+
+	assert.NoError(L.DoString(`
+		local function probe(a, b)
+			local c = a + b
+			inspect()
+			return a
+		end
+		return probe(1, 2)
+	`))
+	assert.Equal(int64(42), L.ToInteger(-1))
+	L.Pop(1)
+	assert.Equal(map[string]int64{"a": 1, "b": 2, "c": 3}, locals)
+	assert.Equal(0, L.GetTop())
+}
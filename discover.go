@@ -0,0 +1,314 @@
+package lua
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Want restricts the library discovery to the given Lua version, such as "5.4", "5.1" or "luajit".
+// Without it, the first Lua library found is accepted.
+func Want(version string) initOptFunc {
+	return func(o *initOpt) {
+		o.want = version
+	}
+}
+
+// IgnoreEnv makes FindLibrary, OpenAuto and InitAuto skip the LUA_LIBRARY environment variables,
+// like the -E option of the lua program ignores LUA_INIT and LUA_PATH.
+func IgnoreEnv() initOptFunc {
+	return func(o *initOpt) {
+		o.ignoreEnv = true
+	}
+}
+
+// LibraryCandidate is a library tried during discovery, together with the reason it was rejected.
+type LibraryCandidate struct {
+	Path string
+	Err  error
+}
+
+// LibraryNotFoundError is returned when no library matching the wanted version can be found.
+// It reports every candidate tried during discovery.
+type LibraryNotFoundError struct {
+	want       string
+	candidates []LibraryCandidate
+}
+
+func (e *LibraryNotFoundError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("no Lua library found")
+	if e.want != "" {
+		fmt.Fprintf(&sb, " for version %s", e.want)
+	}
+	if len(e.candidates) == 0 {
+		sb.WriteString(", no candidate")
+		return sb.String()
+	}
+	sb.WriteString(", tried:")
+	for _, c := range e.candidates {
+		fmt.Fprintf(&sb, "\n\t%s: %v", c.Path, c.Err)
+	}
+	return sb.String()
+}
+
+// Candidates returns the libraries tried during discovery.
+func (e *LibraryNotFoundError) Candidates() []LibraryCandidate {
+	return e.candidates
+}
+
+// FindLibrary searches the Lua dynamic library matching the options and returns its path.
+// The candidates are taken, in order, from the LUA_LIBRARY_5_4 (for the wanted version) and LUA_LIBRARY
+// environment variables, which hold a list of files or directories separated by os.PathListSeparator
+// and are skipped with IgnoreEnv, the luamake output directories of the working directory and its parents,
+// the common names of distribution packages, and the output of ldconfig -p.
+// Each candidate is loaded to check its lua_version against the wanted version.
+func FindLibrary(o ...initOptFunc) (path string, err error) {
+	opt := &initOpt{}
+	for _, fn := range o {
+		fn(opt)
+	}
+
+	want, jit, err := parseWantedVersion(opt.want)
+	if err != nil {
+		return
+	}
+
+	notFound := &LibraryNotFoundError{want: opt.want}
+	seen := make(map[string]bool)
+	for _, candidate := range libraryCandidates(opt.want, opt.ignoreEnv) {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+
+		err := probeLibrary(candidate, want, jit)
+		if err == nil {
+			return candidate, nil
+		}
+		notFound.candidates = append(notFound.candidates, LibraryCandidate{
+			Path: candidate,
+			Err:  err,
+		})
+	}
+	return "", notFound
+}
+
+// OpenAuto searches the Lua dynamic library matching the options with FindLibrary
+// and loads it as a new Runtime.
+func OpenAuto(o ...initOptFunc) (rt *Runtime, err error) {
+	path, err := FindLibrary(o...)
+	if err != nil {
+		return
+	}
+	return Open(path, o...)
+}
+
+// InitAuto searches the Lua dynamic library matching the options with FindLibrary
+// and loads it to the default runtime.
+// Calling InitAuto for multiple times without deinit the previous library will result in an error.
+func InitAuto(o ...initOptFunc) (err error) {
+	path, err := FindLibrary(o...)
+	if err != nil {
+		return
+	}
+	return Init(path, o...)
+}
+
+// parseWantedVersion converts a version such as "5.4" into the number reported by lua_version.
+func parseWantedVersion(want string) (version float64, jit bool, err error) {
+	if want == "" {
+		return
+	}
+	if strings.EqualFold(want, "luajit") || strings.EqualFold(want, "jit") {
+		return 501, true, nil
+	}
+	major, minor, ok := strings.Cut(want, ".")
+	if !ok {
+		return 0, false, fmt.Errorf("invalid Lua version %q, expected a version like 5.4", want)
+	}
+	ma, err1 := strconv.Atoi(major)
+	mi, err2 := strconv.Atoi(minor)
+	if err1 != nil || err2 != nil {
+		return 0, false, fmt.Errorf("invalid Lua version %q, expected a version like 5.4", want)
+	}
+	return float64(ma*100 + mi), false, nil
+}
+
+// probeLibrary loads the library at path and checks that it is a Lua library of the wanted version.
+func probeLibrary(path string, want float64, jit bool) (err error) {
+	// The probed library is unloaded at once, its symbols are kept out of the global namespace.
+	lib, err := loadLibrary(path, true)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = freeLibrary(lib)
+	}()
+
+	for _, name := range []string{"luaL_newstate", "lua_close"} {
+		if _, serr := findSymbol(lib, name); serr != nil {
+			return fmt.Errorf("not a Lua library, missing %s", name)
+		}
+	}
+
+	found := &ffi{version: getLuaVersion(lib), jit: isLuaJIT(lib)}
+	if want != 0 && (found.version != want || jit != found.jit) {
+		return fmt.Errorf("version mismatch, found %s", found.versionString())
+	}
+	return
+}
+
+// libraryCandidates lists the paths and names to try for the wanted version, in order of preference.
+func libraryCandidates(want string, ignoreEnv bool) (candidates []string) {
+	versions := []string{want}
+	if want == "" {
+		versions = []string{"5.5", "5.4", "5.3", "5.1", "luajit"}
+	}
+
+	var envs []string
+	if want != "" {
+		envs = append(envs, "LUA_LIBRARY_"+strings.ReplaceAll(want, ".", "_"))
+	}
+	envs = append(envs, "LUA_LIBRARY")
+	if ignoreEnv {
+		envs = nil
+	}
+	for _, env := range envs {
+		for _, entry := range filepath.SplitList(os.Getenv(env)) {
+			if entry == "" {
+				continue
+			}
+			if fi, err := os.Stat(entry); err == nil && fi.IsDir() {
+				for _, version := range versions {
+					for _, name := range libraryNames(version) {
+						candidates = append(candidates, filepath.Join(entry, name))
+					}
+				}
+				continue
+			}
+			candidates = append(candidates, entry)
+		}
+	}
+
+	candidates = append(candidates, luamakeCandidates(versions)...)
+
+	for _, version := range versions {
+		candidates = append(candidates, libraryNames(version)...)
+	}
+
+	candidates = append(candidates, ldconfigCandidates(versions)...)
+	return
+}
+
+// libraryNames returns the common file names of the Lua library of a version on the current platform.
+func libraryNames(version string) []string {
+	if strings.EqualFold(version, "luajit") {
+		switch runtime.GOOS {
+		case "windows":
+			return []string{"lua51.dll", "luajit.dll"}
+		case "darwin":
+			return []string{"libluajit-5.1.2.dylib", "libluajit-5.1.dylib", "libluajit.dylib"}
+		default:
+			return []string{"libluajit-5.1.so.2", "libluajit-5.1.so", "libluajit.so"}
+		}
+	}
+
+	compact := strings.ReplaceAll(version, ".", "")
+	switch runtime.GOOS {
+	case "windows":
+		return []string{
+			"lua" + compact + ".dll",
+			"lua" + version + ".dll",
+			"lua.dll",
+		}
+	case "darwin":
+		return []string{
+			"liblua" + version + ".dylib",
+			"liblua." + version + ".dylib",
+			"liblua" + compact + ".dylib",
+			"liblua.dylib",
+		}
+	default:
+		return []string{
+			"liblua" + version + ".so.0",
+			"liblua" + version + ".so",
+			"liblua-" + version + ".so",
+			"liblua" + compact + ".so",
+			"liblua.so." + version,
+			"liblua.so",
+		}
+	}
+}
+
+// luamakeCandidates returns the libraries built by make.lua in the working directory and its parents.
+func luamakeCandidates(versions []string) (candidates []string) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	for {
+		for _, version := range versions {
+			compact := strings.ReplaceAll(version, ".", "")
+			var pattern string
+			switch runtime.GOOS {
+			case "windows":
+				pattern = "lua*" + compact + "*.dll"
+			case "darwin":
+				pattern = "*lua*" + compact + "*.dylib"
+			default:
+				pattern = "*lua*" + compact + "*.so*"
+			}
+			matches, _ := filepath.Glob(filepath.Join(dir, "lua"+compact, ".lua", "lib", pattern))
+			candidates = append(candidates, matches...)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+}
+
+// ldconfigCandidates returns the Lua libraries known to the dynamic linker cache.
+func ldconfigCandidates(versions []string) (candidates []string) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	out, err := exec.Command("ldconfig", "-p").Output()
+	if err != nil {
+		out, err = exec.Command("/sbin/ldconfig", "-p").Output()
+		if err != nil {
+			return
+		}
+	}
+
+	var libs []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// liblua5.4.so.0 (libc6,x86-64) => /lib/x86_64-linux-gnu/liblua5.4.so.0
+		name, path, ok := strings.Cut(scanner.Text(), "=>")
+		if !ok || !strings.Contains(name, "lua") {
+			continue
+		}
+		libs = append(libs, strings.TrimSpace(path))
+	}
+
+	// Prefer the libraries whose name mentions the wanted version.
+	for _, version := range versions {
+		compact := strings.ReplaceAll(version, ".", "")
+		for _, lib := range libs {
+			base := strings.ToLower(filepath.Base(lib))
+			if strings.Contains(base, version) || strings.Contains(base, compact) {
+				candidates = append(candidates, lib)
+			}
+		}
+	}
+	return append(candidates, libs...)
+}
@@ -0,0 +1,59 @@
+package lua_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestFindLibrary(assert *require.Assertions, t *testing.T) {
+	rt, err := lua.Open(s.path)
+	assert.NoError(err)
+	version := int(rt.Version())
+	assert.NoError(rt.Close())
+
+	want := fmt.Sprintf("%d.%d", version/100, version%100)
+	path, err := lua.FindLibrary(lua.Want(want))
+	assert.NoError(err)
+
+	rt, err = lua.Open(path)
+	assert.NoError(err)
+	assert.EqualValues(version, rt.Version())
+	assert.NoError(rt.Close())
+
+	_, err = lua.FindLibrary(lua.Want("9.9"))
+	var notFound *lua.LibraryNotFoundError
+	assert.True(errors.As(err, &notFound))
+	assert.Contains(err.Error(), "9.9")
+	assert.NotEmpty(notFound.Candidates())
+	for _, c := range notFound.Candidates() {
+		assert.Error(c.Err, c.Path)
+	}
+
+	_, err = lua.FindLibrary(lua.Want("five"))
+	assert.Error(err)
+}
+
+func TestFindLibraryIgnoreEnv(t *testing.T) {
+	assert := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "liblua-env.so")
+	t.Setenv("LUA_LIBRARY_9_9", path)
+	tried := func(err error) (paths []string) {
+		var notFound *lua.LibraryNotFoundError
+		assert.True(errors.As(err, &notFound))
+		for _, c := range notFound.Candidates() {
+			paths = append(paths, c.Path)
+		}
+		return
+	}
+
+	_, err := lua.FindLibrary(lua.Want("9.9"))
+	assert.Contains(tried(err), path)
+	_, err = lua.FindLibrary(lua.Want("9.9"), lua.IgnoreEnv())
+	assert.NotContains(tried(err), path)
+}
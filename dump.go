@@ -0,0 +1,61 @@
+package lua
+
+import (
+	"errors"
+	"io"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// dumpCtx is the state of a Dump call shared with the writer callback.
+// It is passed to C as a Handle, like loadCtx.
+type dumpCtx struct {
+	w   io.Writer
+	err error
+}
+
+var writer = purego.NewCallback(func(_ unsafe.Pointer, p unsafe.Pointer, sz uintptr, ud uintptr) int {
+	ctx := Handle(ud).Value().(*dumpCtx)
+	if ctx.err != nil {
+		return 1
+	}
+	if sz == 0 {
+		return 0
+	}
+	if _, err := ctx.w.Write(unsafe.Slice((*byte)(p), sz)); err != nil {
+		ctx.err = err
+		return 1
+	}
+	return 0
+})
+
+// Dump writes the Lua function on the top of the stack to w as a binary chunk,
+// which can be loaded back with Load. This mirrors lua_dump, the function is not popped.
+// The debug information is left out when strip is true, except with Lua 5.1 which always keeps it.
+// Returns the write error of w, or an error if the value is not a Lua function.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_dump
+func (s *State) Dump(w io.Writer, strip bool) error {
+	ctx := &dumpCtx{w: w}
+	h := NewHandle(ctx)
+	defer h.Delete()
+
+	var status int
+	if s.rt.ffi.version < 503 {
+		status = s.rt.ffi.LuaDump501(s.luaL, writer, uintptr(h))
+	} else {
+		var st int
+		if strip {
+			st = 1
+		}
+		status = s.rt.ffi.LuaDump(s.luaL, writer, uintptr(h), st)
+	}
+
+	if ctx.err != nil {
+		return ctx.err
+	}
+	if status != 0 {
+		return errors.New("lua: unable to dump given function")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package lua_test
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func (s *Suite) TestDump(assert *require.Assertions, L *lua.State) {
+	assert.NoError(L.LoadString(`local a, b = ... return a * b`))
+
+	var chunk bytes.Buffer
+	assert.NoError(L.Dump(&chunk, true))
+	assert.Equal(1, L.GetTop())
+	L.Pop(1)
+	assert.NotZero(chunk.Len())
+
+	assert.NoError(L.Load(bytes.NewReader(chunk.Bytes()), "=dumped", "b"))
+	L.PushInteger(6)
+	L.PushInteger(7)
+	assert.NoError(L.PCall(2, 1, 0))
+	assert.EqualValues(42, L.ToInteger(-1))
+	L.Pop(1)
+
+	assert.NoError(L.LoadString(`return 1`))
+	assert.EqualError(L.Dump(failingWriter{}, false), "disk full")
+	L.Pop(1)
+
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int { return 0 }))
+	assert.Error(L.Dump(&chunk, false))
+	L.Pop(1)
+	assert.Equal(0, L.GetTop())
+}
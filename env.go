@@ -0,0 +1,93 @@
+package lua
+
+import (
+	"fmt"
+	"io"
+)
+
+// LoadWithEnv loads a Lua chunk from an io.Reader like Load, and sets the table at envIdx as its environment,
+// so that the globals of the chunk are read from and written to that table.
+// The first upvalue of the chunk must be _ENV, which is checked by name, otherwise an error is returned
+// and nothing is pushed. With Lua 5.1 and LuaJIT the environment is set by lua_setfenv.
+// An empty mode means "bt".
+// See: https://www.lua.org/manual/5.4/manual.html#2.2
+func (s *State) LoadWithEnv(r io.Reader, chunkname string, mode string, envIdx int) (err error) {
+	envIdx = s.AbsIndex(envIdx)
+	err = s.Load(r, chunkname, modes(mode)...)
+	if err != nil {
+		return
+	}
+	return s.setChunkEnv(chunkname, envIdx)
+}
+
+// LoadBufferWithEnv loads a Lua chunk from a byte slice like LoadBufferx,
+// and sets the table at envIdx as its environment. See LoadWithEnv.
+func (s *State) LoadBufferWithEnv(buff []byte, name string, mode string, envIdx int) (err error) {
+	envIdx = s.AbsIndex(envIdx)
+	err = s.LoadBufferx(buff, name, modes(mode)...)
+	if err != nil {
+		return
+	}
+	return s.setChunkEnv(name, envIdx)
+}
+
+// LoadFileWithEnv loads a Lua source file like LoadFilex,
+// and sets the table at envIdx as its environment. See LoadWithEnv.
+func (s *State) LoadFileWithEnv(filename string, mode string, envIdx int) (err error) {
+	envIdx = s.AbsIndex(envIdx)
+	err = s.LoadFilex(filename, modes(mode)...)
+	if err != nil {
+		return
+	}
+	return s.setChunkEnv(filename, envIdx)
+}
+
+// NewEnv creates a new table to be used as the environment of a chunk and pushes it onto the stack.
+// Its metatable has an __index field referring to the global table,
+// so that the chunk reads the globals it does not define, while its own globals stay in the new table.
+func (s *State) NewEnv() {
+	s.NewTable()
+	s.CreateTable(0, 1)
+	s.PushGlobalTable()
+	s.SetField(-2, "__index")
+	s.SetIMetaTable(-2)
+}
+
+// setChunkEnv sets the table at envIdx as the environment of the chunk at the top of the stack.
+// The chunk is popped if its first upvalue is not _ENV, or if lua_setfenv fails with Lua 5.1.
+func (s *State) setChunkEnv(chunkname string, envIdx int) error {
+	if s.rt.ffi.version < 503 {
+		if s.Type(envIdx) != LUA_TTABLE {
+			s.Pop(1)
+			return fmt.Errorf("lua: the environment of chunk %s must be a table, got %s", chunkname, s.TypeName(s.Type(envIdx)))
+		}
+		s.PushValue(envIdx)
+		if s.rt.ffi.LuaSetfenv(s.luaL, -2) == 0 {
+			s.Pop(1)
+			return fmt.Errorf("lua: cannot set the environment of chunk %s", chunkname)
+		}
+		return nil
+	}
+
+	var name string
+	if p := s.rt.ffi.LuaGetupvalue(s.luaL, -1, 1); p != nil {
+		name = bytePtrToString(p)
+		s.Pop(1)
+	}
+	if name != "_ENV" {
+		s.Pop(1)
+		return fmt.Errorf("lua: the first upvalue of chunk %s is %q, not _ENV", chunkname, name)
+	}
+
+	s.PushValue(envIdx)
+	s.SetUpValue(-2, 1)
+	return nil
+}
+
+// modes converts an optional mode into the variadic mode of the load functions.
+func modes(mode string) []string {
+	if mode == "" {
+		return nil
+	}
+	return []string{mode}
+}
@@ -0,0 +1,37 @@
+package lua_test
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestLoadWithEnv(assert *require.Assertions, L *lua.State) {
+	L.NewEnv()
+	env := L.GetTop()
+
+	assert.NoError(L.LoadWithEnv(strings.NewReader(`plugin = "a" return tostring(1)`), "plugin", "t", env))
+	assert.NoError(L.PCall(0, 1, 0))
+	assert.Equal("1", L.ToString(-1))
+	L.Pop(1)
+
+	// The global went to the environment, which still reads the real globals.
+	L.GetField(env, "plugin")
+	assert.Equal("a", L.ToString(-1))
+	L.Pop(1)
+	L.GetGlobal("plugin")
+	assert.True(L.IsNil(-1))
+	L.Pop(1)
+
+	assert.NoError(L.LoadBufferWithEnv([]byte(`return plugin`), "buffer", "", env))
+	assert.NoError(L.PCall(0, 1, 0))
+	assert.Equal("a", L.ToString(-1))
+	L.Pop(1)
+
+	assert.NoError(L.LoadFileWithEnv("testdata/coro.lua", "", env))
+	L.Pop(1)
+
+	assert.Error(L.LoadWithEnv(strings.NewReader(`return +`), "bad", "", env))
+	assert.Equal(env, L.GetTop())
+}
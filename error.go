@@ -1,6 +1,10 @@
 package lua
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 // Error represents a Lua error with its status code and corresponding message.
 // It is returned by many operations when faults occur, matching the error codes of the Lua C API.
@@ -8,6 +12,8 @@ import "fmt"
 type Error struct {
 	status  int
 	message string
+	// err is the Go error which caused the Lua error, if any, such as a read error of Load.
+	err error
 }
 
 // Error implements the error interface for Lua Error, returning a formatted error string.
@@ -25,6 +31,11 @@ func (e *Error) Message() string {
 	return e.message
 }
 
+// Unwrap returns the Go error which caused the Lua error, or nil.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
 // UnprotectedError represents an error that occurs when an operation is attempted on a Lua state
 // that called without pcallk or pcall.
 type UnprotectedError struct {
@@ -34,3 +45,41 @@ type UnprotectedError struct {
 func (e *UnprotectedError) Error() string {
 	return fmt.Sprintf("Unprotected Error in call to Lua API (%s)", e.message)
 }
+
+// UnsupportedError reports an operation which is not available in the loaded Lua library,
+// for example a Lua 5.4 only function called on a Lua 5.1 state.
+// It is returned by methods with an error result, and raised as a panic by the others.
+// It matches errors.ErrUnsupported with errors.Is.
+type UnsupportedError struct {
+	name    string
+	version string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s is not supported by %s", e.name, e.version)
+}
+
+// Name returns the name of the unsupported C API function.
+func (e *UnsupportedError) Name() string {
+	return e.name
+}
+
+func (e *UnsupportedError) Unwrap() error {
+	return errors.ErrUnsupported
+}
+
+// SymbolError is returned when the Lua library misses some of the C API symbols required by the binding,
+// for example a stripped or custom build. It lists every missing symbol at once.
+type SymbolError struct {
+	path    string
+	missing []string
+}
+
+func (e *SymbolError) Error() string {
+	return fmt.Sprintf("lua library %s misses symbols: %s", e.path, strings.Join(e.missing, ", "))
+}
+
+// Missing returns the names of the missing symbols.
+func (e *SymbolError) Missing() []string {
+	return e.missing
+}
@@ -0,0 +1,33 @@
+package lua
+
+import (
+	"unsafe"
+)
+
+// extraSpace returns the address of the LUA_EXTRASPACE bytes stored right before the lua_State,
+// which is what the lua_getextraspace macro computes.
+// The binding expects the default LUA_EXTRASPACE of luaconf.h, the size of a pointer.
+// Panics if the library has no extra space, that is Lua 5.1 and LuaJIT.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_getextraspace
+func (s *State) extraSpace() *uintptr {
+	if s.rt.ffi.version < 503 {
+		panic(s.rt.ffi.unsupported("lua_getextraspace"))
+	}
+	return (*uintptr)(unsafe.Add(s.luaL, -int(unsafe.Sizeof(uintptr(0)))))
+}
+
+// SetExtra stores a handle in the extra space of the state, a raw memory area associated with the Lua state,
+// giving callbacks an O(1) access to a Go value such as a request context.
+// Like Lua does, new threads are created with a copy of the extra space of the main thread.
+// Available since Lua 5.3.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_getextraspace
+func (s *State) SetExtra(h Handle) {
+	*s.extraSpace() = uintptr(h)
+}
+
+// Extra returns the handle stored in the extra space of the state by SetExtra, or zero if none has been stored.
+// Available since Lua 5.3.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_getextraspace
+func (s *State) Extra() Handle {
+	return Handle(*s.extraSpace())
+}
@@ -0,0 +1,47 @@
+package lua_test
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestExtraSpace(assert *require.Assertions, L *lua.State) {
+	if L.Version() < 503 {
+		assert.Panics(func() { L.Extra() })
+		return
+	}
+
+	assert.Zero(L.Extra())
+
+	ctx := context.WithValue(context.Background(), registryKey{}, "request")
+	h := lua.NewHandle(ctx)
+	defer h.Delete()
+
+	L.SetExtra(h)
+	assert.Equal(h, L.Extra())
+
+	// New threads inherit the extra space of the main thread.
+	co := L.NewThread()
+	L.Pop(1)
+	assert.Equal(h, co.Extra())
+
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		ctx := L.Extra().Value().(context.Context)
+		L.PushString(ctx.Value(registryKey{}).(string))
+		return 1
+	}))
+	L.Call(0, 1)
+	assert.Equal("request", L.ToString(-1))
+	L.Pop(1)
+}
+
+func (s *Suite) TestHandle(assert *require.Assertions, L *lua.State) {
+	h := lua.NewHandle(42)
+	assert.NotZero(h)
+	assert.Equal(42, h.Value())
+	h.Delete()
+	assert.Panics(func() { h.Value() })
+	assert.Panics(func() { h.Delete() })
+}
@@ -1,6 +1,7 @@
 package lua
 
 import (
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
@@ -25,149 +26,217 @@ type LuaLReg struct {
 // ffi stores all dynamically loaded Lua C API entry points for runtime use.
 // This struct provides Go bindings to the Lua C API using purego FFI.
 // We use `ffi` tag to specify the function name and version requirements for each entry point.
-// The version requirements are specified using tags like "gte=503" for Lua 5.4.
+// The version requirements are specified using tags like "gte=503" for Lua 5.3 and later,
+// or "lte=501" for Lua 5.1 only. Entry points tagged with "jit" are only registered for LuaJIT.
+// Lua 5.1 and LuaJIT report the version 501.
+// Entry points tagged with "opt=<group>" belong to an optional API group, they may be left nil
+// when the library misses them and AllowMissingSymbols is used.
+// Entry points tagged with "alt=<symbol>" are also left nil without AllowMissingSymbols
+// when the library exports the alternative symbol, which the binding falls back to.
 type ffi struct {
 	lib     uintptr
 	version float64
+	jit     bool
+
+	// missing lists the optional entry points left nil because the library does not export them.
+	missing []string
 
 	// State manipulation
-	LuaNewstate    func(f uintptr, ud unsafe.Pointer) unsafe.Pointer `ffi:"lua_newstate,gte=503"`
-	LuaClose       func(L unsafe.Pointer)                            `ffi:"lua_close"`
-	LuaNewthread   func(L unsafe.Pointer) unsafe.Pointer             `ffi:"lua_newthread,gte=503"`
-	LuaClosethread func(L unsafe.Pointer, from unsafe.Pointer) int   `ffi:"lua_closethread,gte=504"`
-	LuaResetthread func(L unsafe.Pointer) int                        `ffi:"lua_resetthread,gte=504"`
+	LuaNewstate    func(f uintptr, ud unsafe.Pointer) unsafe.Pointer              `ffi:"lua_newstate,gte=501,lte=504"`
+	LuaNewstate505 func(f uintptr, ud unsafe.Pointer, seed uint32) unsafe.Pointer `ffi:"lua_newstate,gte=505"`
+	LuaClose       func(L unsafe.Pointer)                                         `ffi:"lua_close"`
+	LuaNewthread   func(L unsafe.Pointer) unsafe.Pointer                          `ffi:"lua_newthread,gte=501"`
+	LuaClosethread func(L unsafe.Pointer, from unsafe.Pointer) int                `ffi:"lua_closethread,gte=504,opt=closethread,alt=lua_resetthread"`
+	LuaResetthread func(L unsafe.Pointer) int                                     `ffi:"lua_resetthread,gte=504,lte=504,opt=closethread"`
+
+	LuaAtpanic func(L unsafe.Pointer, panicf uintptr) unsafe.Pointer `ffi:"lua_atpanic,gte=501"`
 
-	LuaAtpanic func(L unsafe.Pointer, panicf uintptr) unsafe.Pointer `ffi:"lua_atpanic,gte=503"`
+	LuaGetallocf func(L unsafe.Pointer, ud *uintptr) uintptr   `ffi:"lua_getallocf,gte=501"`
+	LuaSetallocf func(L unsafe.Pointer, f uintptr, ud uintptr) `ffi:"lua_setallocf,gte=501"`
 
 	LuaVersion func(L unsafe.Pointer) float64 `ffi:"lua_version,gte=503"`
 
+	// Garbage collection, see luaGcFunc for its arguments
+	LuaGc luaGcFunc `ffi:"lua_gc,gte=501"`
+
 	// Basic stack manipulation
 	LuaAbsindex   func(L unsafe.Pointer, idx int) int        `ffi:"lua_absindex,gte=503"`
-	LuaGettop     func(L unsafe.Pointer) int                 `ffi:"lua_gettop,gte=503"`
-	LuaSettop     func(L unsafe.Pointer, idx int)            `ffi:"lua_settop,gte=503"`
-	LuaPushvalue  func(L unsafe.Pointer, idx int)            `ffi:"lua_pushvalue,gte=503"`
+	LuaGettop     func(L unsafe.Pointer) int                 `ffi:"lua_gettop,gte=501"`
+	LuaSettop     func(L unsafe.Pointer, idx int)            `ffi:"lua_settop,gte=501"`
+	LuaPushvalue  func(L unsafe.Pointer, idx int)            `ffi:"lua_pushvalue,gte=501"`
 	LuaRotate     func(L unsafe.Pointer, idx, n int)         `ffi:"lua_rotate,gte=503"`
 	LuaCopy       func(L unsafe.Pointer, fromidx, toidx int) `ffi:"lua_copy,gte=503"`
-	LuaCheckstack func(L unsafe.Pointer, sz int) int         `ffi:"lua_checkstack,gte=503"`
-	LuaXmove      func(from, to unsafe.Pointer, n int)       `ffi:"lua_xmove,gte=503"`
+	LuaCheckstack func(L unsafe.Pointer, sz int) int         `ffi:"lua_checkstack,gte=501"`
+	LuaXmove      func(from, to unsafe.Pointer, n int)       `ffi:"lua_xmove,gte=501"`
+	LuaInsert     func(L unsafe.Pointer, idx int)            `ffi:"lua_insert,lte=501"`
+	LuaRemove     func(L unsafe.Pointer, idx int)            `ffi:"lua_remove,lte=501"`
+	LuaReplace    func(L unsafe.Pointer, idx int)            `ffi:"lua_replace,lte=501"`
+	LuaToclose    func(L unsafe.Pointer, idx int)            `ffi:"lua_toclose,gte=504"`
+	LuaCloseslot  func(L unsafe.Pointer, idx int)            `ffi:"lua_closeslot,gte=504,opt=closeslot"`
 
 	// Access functions
-	LuaIsnumber    func(L unsafe.Pointer, idx int) int  `ffi:"lua_isnumber,gte=503"`
-	LuaIsstring    func(L unsafe.Pointer, idx int) int  `ffi:"lua_isstring,gte=503"`
-	LuaIscfunction func(L unsafe.Pointer, idx int) int  `ffi:"lua_iscfunction,gte=503"`
+	LuaIsnumber    func(L unsafe.Pointer, idx int) int  `ffi:"lua_isnumber,gte=501"`
+	LuaIsstring    func(L unsafe.Pointer, idx int) int  `ffi:"lua_isstring,gte=501"`
+	LuaIscfunction func(L unsafe.Pointer, idx int) int  `ffi:"lua_iscfunction,gte=501"`
 	LuaIsinteger   func(L unsafe.Pointer, idx int) int  `ffi:"lua_isinteger,gte=503"`
-	LuaIsuserdata  func(L unsafe.Pointer, idx int) int  `ffi:"lua_isuserdata,gte=503"`
-	LuaType        func(L unsafe.Pointer, idx int) int  `ffi:"lua_type,gte=503"`
-	LuaTypename    func(L unsafe.Pointer, tp int) *byte `ffi:"lua_typename,gte=503"`
+	LuaIsuserdata  func(L unsafe.Pointer, idx int) int  `ffi:"lua_isuserdata,gte=501"`
+	LuaType        func(L unsafe.Pointer, idx int) int  `ffi:"lua_type,gte=501"`
+	LuaTypename    func(L unsafe.Pointer, tp int) *byte `ffi:"lua_typename,gte=501"`
 
 	LuaTonumberx   func(L unsafe.Pointer, idx int, isnum unsafe.Pointer) float64 `ffi:"lua_tonumberx,gte=503"`
 	LuaTointegerx  func(L unsafe.Pointer, idx int, isnum unsafe.Pointer) int64   `ffi:"lua_tointegerx,gte=503"`
-	LuaTolstring   func(L unsafe.Pointer, idx int, sz unsafe.Pointer) *byte      `ffi:"lua_tolstring,gte=503"`
-	LuaToboolean   func(L unsafe.Pointer, idx int) int                           `ffi:"lua_toboolean,gte=503"`
+	LuaTolstring   func(L unsafe.Pointer, idx int, sz unsafe.Pointer) *byte      `ffi:"lua_tolstring,gte=501"`
+	LuaToboolean   func(L unsafe.Pointer, idx int) int                           `ffi:"lua_toboolean,gte=501"`
 	LuaRawlen      func(L unsafe.Pointer, idx int) uint                          `ffi:"lua_rawlen,gte=503"`
-	LuaTocfunction func(L unsafe.Pointer, idx int) unsafe.Pointer                `ffi:"lua_tocfunction,gte=503"`
-	LuaTouserdata  func(L unsafe.Pointer, idx int) unsafe.Pointer                `ffi:"lua_touserdata,gte=503"`
-	LuaTothread    func(L unsafe.Pointer, idx int) unsafe.Pointer                `ffi:"lua_tothread,gte=503"`
-	LuaTopointer   func(L unsafe.Pointer, idx int) unsafe.Pointer                `ffi:"lua_topointer,gte=503"`
-
-	LuaRawequal func(L unsafe.Pointer, idx1 int, idx2 int) int         `ffi:"lua_rawequal,gte=503"`
+	LuaTocfunction func(L unsafe.Pointer, idx int) unsafe.Pointer                `ffi:"lua_tocfunction,gte=501"`
+	LuaTouserdata  func(L unsafe.Pointer, idx int) unsafe.Pointer                `ffi:"lua_touserdata,gte=501"`
+	LuaTothread    func(L unsafe.Pointer, idx int) unsafe.Pointer                `ffi:"lua_tothread,gte=501"`
+	LuaTopointer   func(L unsafe.Pointer, idx int) unsafe.Pointer                `ffi:"lua_topointer,gte=501"`
+	LuaTonumber    func(L unsafe.Pointer, idx int) float64                       `ffi:"lua_tonumber,lte=501"`
+	LuaTointeger   func(L unsafe.Pointer, idx int) int64                         `ffi:"lua_tointeger,lte=501"`
+	LuaObjlen      func(L unsafe.Pointer, idx int) uint                          `ffi:"lua_objlen,lte=501"`
+
+	LuaRawequal func(L unsafe.Pointer, idx1 int, idx2 int) int         `ffi:"lua_rawequal,gte=501"`
 	LuaCompare  func(L unsafe.Pointer, idx1 int, idx2 int, op int) int `ffi:"lua_compare,gte=503"`
 	LuaArith    func(L unsafe.Pointer, op int)                         `ffi:"lua_arith,gte=503"`
-	LuaConcat   func(L unsafe.Pointer, n int)                          `ffi:"lua_concat,gte=503"`
+	LuaConcat   func(L unsafe.Pointer, n int)                          `ffi:"lua_concat,gte=501"`
 	LuaLen      func(L unsafe.Pointer, idx int)                        `ffi:"lua_len,gte=503"`
+	LuaEqual    func(L unsafe.Pointer, idx1 int, idx2 int) int         `ffi:"lua_equal,lte=501"`
+	LuaLessthan func(L unsafe.Pointer, idx1 int, idx2 int) int         `ffi:"lua_lessthan,lte=501"`
 
 	// Push functions
-	LuaPushnil           func(L unsafe.Pointer)                         `ffi:"lua_pushnil,gte=503"`
-	LuaPushnumber        func(L unsafe.Pointer, n float64)              `ffi:"lua_pushnumber,gte=503"`
-	LuaPushinteger       func(L unsafe.Pointer, n int64)                `ffi:"lua_pushinteger,gte=503"`
-	LuaPushlstring       func(L unsafe.Pointer, s *byte, len int) *byte `ffi:"lua_pushlstring,gte=503"`
-	LuaPushstring        func(L unsafe.Pointer, s *byte) *byte          `ffi:"lua_pushstring,gte=503"`
-	LuaPushcclousure     func(L unsafe.Pointer, f uintptr, n int)       `ffi:"lua_pushcclosure,gte=503"`
-	LuaPushboolean       func(L unsafe.Pointer, b int) int              `ffi:"lua_pushboolean,gte=503"`
-	LuaPushlightuserdata func(L unsafe.Pointer, p unsafe.Pointer)       `ffi:"lua_pushlightuserdata,gte=503"`
-	LuaPushthread        func(L unsafe.Pointer) int                     `ffi:"lua_pushthread,gte=503"`
+	LuaPushnil           func(L unsafe.Pointer)                         `ffi:"lua_pushnil,gte=501"`
+	LuaPushnumber        func(L unsafe.Pointer, n float64)              `ffi:"lua_pushnumber,gte=501"`
+	LuaPushinteger       func(L unsafe.Pointer, n int64)                `ffi:"lua_pushinteger,gte=501"`
+	LuaPushlstring       func(L unsafe.Pointer, s *byte, len int) *byte `ffi:"lua_pushlstring,gte=501"`
+	LuaPushstring        func(L unsafe.Pointer, s *byte) *byte          `ffi:"lua_pushstring,gte=501"`
+	LuaPushcclousure     func(L unsafe.Pointer, f uintptr, n int)       `ffi:"lua_pushcclosure,gte=501"`
+	LuaPushboolean       func(L unsafe.Pointer, b int) int              `ffi:"lua_pushboolean,gte=501"`
+	LuaPushlightuserdata func(L unsafe.Pointer, p unsafe.Pointer)       `ffi:"lua_pushlightuserdata,gte=501"`
+	LuaPushthread        func(L unsafe.Pointer) int                     `ffi:"lua_pushthread,gte=501"`
 
 	// Table and field functions
-	LuaCreatetable func(L unsafe.Pointer, narr, nrec int)         `ffi:"lua_createtable,gte=503"`
-	LuaGettable    func(L unsafe.Pointer, idx int) int            `ffi:"lua_gettable,gte=503"`
-	LuaSettable    func(L unsafe.Pointer, idx int)                `ffi:"lua_settable,gte=503"`
-	LuaGetfield    func(L unsafe.Pointer, idx int, k *byte) int32 `ffi:"lua_getfield,gte=503"`
-	LuaSetfield    func(L unsafe.Pointer, idx int, k *byte)       `ffi:"lua_setfield,gte=503"`
+	LuaCreatetable func(L unsafe.Pointer, narr, nrec int)         `ffi:"lua_createtable,gte=501"`
+	LuaGettable    func(L unsafe.Pointer, idx int) int            `ffi:"lua_gettable,gte=501"`
+	LuaSettable    func(L unsafe.Pointer, idx int)                `ffi:"lua_settable,gte=501"`
+	LuaGetfield    func(L unsafe.Pointer, idx int, k *byte) int32 `ffi:"lua_getfield,gte=501"`
+	LuaSetfield    func(L unsafe.Pointer, idx int, k *byte)       `ffi:"lua_setfield,gte=501"`
 	LuaGeti        func(L unsafe.Pointer, idx int, n int64) int   `ffi:"lua_geti,gte=503"`
 	LuaSeti        func(L unsafe.Pointer, idx int, n int64)       `ffi:"lua_seti,gte=503"`
 	// Table raw functions
-	LuaRawget  func(L unsafe.Pointer, idx int) int32                   `ffi:"lua_rawget,gte=503"`
-	LuaRawset  func(L unsafe.Pointer, idx int)                         `ffi:"lua_rawset,gte=503"`
-	LuaRawgeti func(L unsafe.Pointer, idx int, n int64) int32          `ffi:"lua_rawgeti,gte=503"`
-	LuaRawseti func(L unsafe.Pointer, idx int, n int64)                `ffi:"lua_rawseti,gte=503"`
+	LuaRawget  func(L unsafe.Pointer, idx int) int32                   `ffi:"lua_rawget,gte=501"`
+	LuaRawset  func(L unsafe.Pointer, idx int)                         `ffi:"lua_rawset,gte=501"`
+	LuaRawgeti func(L unsafe.Pointer, idx int, n int64) int32          `ffi:"lua_rawgeti,gte=501"`
+	LuaRawseti func(L unsafe.Pointer, idx int, n int64)                `ffi:"lua_rawseti,gte=501"`
 	LuaRawgetp func(L unsafe.Pointer, idx int, p unsafe.Pointer) int32 `ffi:"lua_rawgetp,gte=503"`
 	LuaRawsetp func(L unsafe.Pointer, idx int, p unsafe.Pointer)       `ffi:"lua_rawsetp,gte=503"`
-	LuaNext    func(L unsafe.Pointer, idx int) int                     `ffi:"lua_next,gte=503"`
+	LuaNext    func(L unsafe.Pointer, idx int) int                     `ffi:"lua_next,gte=501"`
 	// Meta table functions
-	LuaGetmetatable func(L unsafe.Pointer, objindex int) int `ffi:"lua_getmetatable,gte=503"`
-	LuaSetmetatable func(L unsafe.Pointer, objindex int) int `ffi:"lua_setmetatable,gte=503"`
+	LuaGetmetatable func(L unsafe.Pointer, objindex int) int `ffi:"lua_getmetatable,gte=501"`
+	LuaSetmetatable func(L unsafe.Pointer, objindex int) int `ffi:"lua_setmetatable,gte=501"`
 
-	LuaSetupvalue func(L unsafe.Pointer, idx int, n int) *byte `ffi:"lua_setupvalue,gte=503"`
-	LuaGetupvalue func(L unsafe.Pointer, idx int, n int) *byte `ffi:"lua_getupvalue,gte=503"`
+	LuaSetupvalue func(L unsafe.Pointer, idx int, n int) *byte `ffi:"lua_setupvalue,gte=501"`
+	LuaGetupvalue func(L unsafe.Pointer, idx int, n int) *byte `ffi:"lua_getupvalue,gte=501"`
 
 	// Userdata functions
-	LuaNewuserdata   func(L unsafe.Pointer, sz int) unsafe.Pointer              `ffi:"lua_newuserdata,gte=503,lte=503"`
-	LuaGetuservalue  func(L unsafe.Pointer, idx int) int32                      `ffi:"lua_getuservalue,gte=503,lte=503"`
-	LuaSetuservalue  func(L unsafe.Pointer, idx int)                            `ffi:"lua_setuservalue,gte=503,lte=503"`
+	LuaNewuserdata   func(L unsafe.Pointer, sz int) unsafe.Pointer              `ffi:"lua_newuserdata,gte=501,lte=503"`
+	LuaGetuservalue  func(L unsafe.Pointer, idx int) int32                      `ffi:"lua_getuservalue,gte=503,lte=503,opt=uservalues"`
+	LuaSetuservalue  func(L unsafe.Pointer, idx int)                            `ffi:"lua_setuservalue,gte=503,lte=503,opt=uservalues"`
 	LuaNewuserdatauv func(L unsafe.Pointer, sz int, nuvlue int) unsafe.Pointer  `ffi:"lua_newuserdatauv,gte=504"`
-	LuaGetiuservalue func(L unsafe.Pointer, idx int, n int) int32               `ffi:"lua_getiuservalue,gte=504"`
-	LuaSetiuservalue func(L unsafe.Pointer, idx int, n int)                     `ffi:"lua_setiuservalue,gte=504"`
-	LuaLCheckudata   func(L unsafe.Pointer, ud int, tname *byte) unsafe.Pointer `ffi:"luaL_checkudata,gte=503"`
+	LuaGetiuservalue func(L unsafe.Pointer, idx int, n int) int32               `ffi:"lua_getiuservalue,gte=504,opt=uservalues"`
+	LuaSetiuservalue func(L unsafe.Pointer, idx int, n int) int                 `ffi:"lua_setiuservalue,gte=504,opt=uservalues"`
+	LuaLCheckudata   func(L unsafe.Pointer, ud int, tname *byte) unsafe.Pointer `ffi:"luaL_checkudata,gte=501"`
 	LuaLTestudata    func(L unsafe.Pointer, ud int, tname *byte) unsafe.Pointer `ffi:"luaL_testudata,gte=503"`
-
-	LuaGetglobal func(L unsafe.Pointer, name *byte) int32                                                   `ffi:"lua_getglobal,gte=503"`
-	LuaSetglobal func(L unsafe.Pointer, name *byte)                                                         `ffi:"lua_setglobal,gte=503"`
-	LuaCallk     func(L unsafe.Pointer, nargs, nresults int, ctx unsafe.Pointer, k uintptr)                 `ffi:"lua_callk,gte=503"`
-	LuaPcallk    func(L unsafe.Pointer, nargs, nresults, errfunc int, ctx unsafe.Pointer, k uintptr) int    `ffi:"lua_pcallk,gte=503"`
-	LuaLoad      func(L unsafe.Pointer, reader uintptr, dt unsafe.Pointer, chunkname *byte, mode *byte) int `ffi:"lua_load,gte=503"`
-
-	LuaSetwarnf func(L unsafe.Pointer, warnf uintptr, ud unsafe.Pointer) `ffi:"lua_setwarnf,gte=504"`
+	LuaGetfenv       func(L unsafe.Pointer, idx int)                            `ffi:"lua_getfenv,lte=501"`
+	LuaSetfenv       func(L unsafe.Pointer, idx int) int                        `ffi:"lua_setfenv,lte=501"`
+
+	LuaGetglobal func(L unsafe.Pointer, name *byte) int32                                                `ffi:"lua_getglobal,gte=503"`
+	LuaSetglobal func(L unsafe.Pointer, name *byte)                                                      `ffi:"lua_setglobal,gte=503"`
+	LuaCallk     func(L unsafe.Pointer, nargs, nresults int, ctx unsafe.Pointer, k uintptr)              `ffi:"lua_callk,gte=503"`
+	LuaPcallk    func(L unsafe.Pointer, nargs, nresults, errfunc int, ctx unsafe.Pointer, k uintptr) int `ffi:"lua_pcallk,gte=503"`
+	LuaLoad      func(L unsafe.Pointer, reader uintptr, dt uintptr, chunkname *byte, mode *byte) int     `ffi:"lua_load,gte=503"`
+	LuaCall      func(L unsafe.Pointer, nargs, nresults int)                                             `ffi:"lua_call,lte=501"`
+	LuaPcall     func(L unsafe.Pointer, nargs, nresults, errfunc int) int                                `ffi:"lua_pcall,lte=501"`
+	LuaLoad501   func(L unsafe.Pointer, reader uintptr, dt uintptr, chunkname *byte) int                 `ffi:"lua_load,lte=501"`
+	LuaDump      func(L unsafe.Pointer, writer uintptr, data uintptr, strip int) int                     `ffi:"lua_dump,gte=503"`
+	LuaDump501   func(L unsafe.Pointer, writer uintptr, data uintptr) int                                `ffi:"lua_dump,lte=501"`
+
+	LuaSetwarnf func(L unsafe.Pointer, warnf uintptr, ud unsafe.Pointer) `ffi:"lua_setwarnf,gte=504,opt=warnings"`
 
 	// Coroutine functions
-	LuaYieldk      func(L unsafe.Pointer, nresults int, ctx unsafe.Pointer, k uintptr) int        `ffi:"lua_yieldk,gte=503"`
+	LuaYieldk      func(L unsafe.Pointer, nresults int, ctx unsafe.Pointer, k uintptr) int        `ffi:"lua_yieldk,gte=503,opt=continuations"`
 	LuaResume      func(L unsafe.Pointer, from unsafe.Pointer, narg int, nres unsafe.Pointer) int `ffi:"lua_resume,gte=504"`
 	LuaResume503   func(L unsafe.Pointer, from unsafe.Pointer, narg int) int                      `ffi:"lua_resume,gte=503,lte=503"`
-	LuaStatus      func(L unsafe.Pointer) int                                                     `ffi:"lua_status,gte=503"`
-	LuaIsyieldable func(L unsafe.Pointer) int                                                     `ffi:"lua_isyieldable,gte=503"`
+	LuaResume501   func(L unsafe.Pointer, narg int) int                                           `ffi:"lua_resume,lte=501"`
+	LuaYield       func(L unsafe.Pointer, nresults int) int                                       `ffi:"lua_yield,lte=501"`
+	LuaStatus      func(L unsafe.Pointer) int                                                     `ffi:"lua_status,gte=501"`
+	LuaIsyieldable func(L unsafe.Pointer) int                                                     `ffi:"lua_isyieldable,gte=503,opt=continuations"`
 
 	LuaLNewstate func() unsafe.Pointer `ffi:"luaL_newstate"`
 	// Open all preloaded libraries.
-	LuaLOpenlibs func(L unsafe.Pointer) `ffi:"luaL_openlibs"`
+	LuaLOpenlibs func(L unsafe.Pointer) `ffi:"luaL_openlibs,lte=504"`
+	// Open the selected standard libraries, luaL_openlibs is a macro over it since Lua 5.5.
+	LuaLOpenselectedlibs func(L unsafe.Pointer, load, preload int) `ffi:"luaL_openselectedlibs,gte=505"`
 
-	LuaLNewmetatable func(L unsafe.Pointer, tname *byte) int        `ffi:"luaL_newmetatable,gte=503"`
+	LuaLNewmetatable func(L unsafe.Pointer, tname *byte) int        `ffi:"luaL_newmetatable,gte=501"`
 	LuaLSetmetatable func(L unsafe.Pointer, tname *byte)            `ffi:"luaL_setmetatable,gte=503"`
-	LuaLCallmeta     func(L unsafe.Pointer, ojbj int, e *byte) int  `ffi:"luaL_callmeta,gte=503"`
-	LuaLGetmetafield func(L unsafe.Pointer, obj int, e *byte) int32 `ffi:"luaL_getmetafield,gte=503"`
+	LuaLCallmeta     func(L unsafe.Pointer, ojbj int, e *byte) int  `ffi:"luaL_callmeta,gte=501"`
+	LuaLGetmetafield func(L unsafe.Pointer, obj int, e *byte) int32 `ffi:"luaL_getmetafield,gte=501"`
 
 	// Auxiliary functions
-	LuaLChecknumber  func(L unsafe.Pointer, idx int) float64                             `ffi:"luaL_checknumber,gte=503"`
-	LuaLCheckinteger func(L unsafe.Pointer, idx int) int64                               `ffi:"luaL_checkinteger,gte=503"`
-	LuaLChecklstring func(L unsafe.Pointer, idx int, sz unsafe.Pointer) *byte            `ffi:"luaL_checklstring,gte=503"`
-	LuaLChecktype    func(L unsafe.Pointer, idx int, t int)                              `ffi:"luaL_checktype,gte=503"`
-	LuaLCheckany     func(L unsafe.Pointer, idx int)                                     `ffi:"luaL_checkany,gte=503"`
-	LuaLOptnumber    func(L unsafe.Pointer, idx int, def float64) float64                `ffi:"luaL_optnumber,gte=503"`
-	LuaLOptinteger   func(L unsafe.Pointer, idx int, def int64) int64                    `ffi:"luaL_optinteger,gte=503"`
-	LuaLOptlstring   func(L unsafe.Pointer, idx int, def *byte, sz unsafe.Pointer) *byte `ffi:"luaL_optlstring,gte=503"`
-	LuaLCheckstack   func(L unsafe.Pointer, sz int, msg *byte) int                       `ffi:"luaL_checkstack,gte=503"`
+	LuaLChecknumber  func(L unsafe.Pointer, idx int) float64                             `ffi:"luaL_checknumber,gte=501"`
+	LuaLCheckinteger func(L unsafe.Pointer, idx int) int64                               `ffi:"luaL_checkinteger,gte=501"`
+	LuaLChecklstring func(L unsafe.Pointer, idx int, sz unsafe.Pointer) *byte            `ffi:"luaL_checklstring,gte=501"`
+	LuaLChecktype    func(L unsafe.Pointer, idx int, t int)                              `ffi:"luaL_checktype,gte=501"`
+	LuaLCheckany     func(L unsafe.Pointer, idx int)                                     `ffi:"luaL_checkany,gte=501"`
+	LuaLOptnumber    func(L unsafe.Pointer, idx int, def float64) float64                `ffi:"luaL_optnumber,gte=501"`
+	LuaLOptinteger   func(L unsafe.Pointer, idx int, def int64) int64                    `ffi:"luaL_optinteger,gte=501"`
+	LuaLOptlstring   func(L unsafe.Pointer, idx int, def *byte, sz unsafe.Pointer) *byte `ffi:"luaL_optlstring,gte=501"`
+	LuaLCheckstack   func(L unsafe.Pointer, sz int, msg *byte) int                       `ffi:"luaL_checkstack,gte=501"`
 	LuaLTolstring    func(L unsafe.Pointer, idx int, sz unsafe.Pointer) *byte            `ffi:"luaL_tolstring,gte=503"`
-
-	LuaLError       func(L unsafe.Pointer, msg *byte) int                                  `ffi:"luaL_error,gte=503"`
-	LuaLLoadstring  func(L unsafe.Pointer, s *byte) int                                    `ffi:"luaL_loadstring,gte=503"`
+	LuaLLen          func(L unsafe.Pointer, idx int) int64                               `ffi:"luaL_len,gte=503"`
+	LuaLWhere        func(L unsafe.Pointer, lvl int)                                     `ffi:"luaL_where,gte=501"`
+	LuaLGetsubtable  func(L unsafe.Pointer, idx int, fname *byte) int                    `ffi:"luaL_getsubtable,gte=503"`
+	LuaLArgerror     func(L unsafe.Pointer, arg int, extramsg *byte) int                 `ffi:"luaL_argerror,gte=501"`
+	LuaLTypeerror    func(L unsafe.Pointer, arg int, tname *byte) int                    `ffi:"luaL_typeerror,gte=504"`
+	LuaLTyperror     func(L unsafe.Pointer, narg int, tname *byte) int                   `ffi:"luaL_typerror,lte=501"`
+
+	LuaLError       func(L unsafe.Pointer, msg *byte) int                                  `ffi:"luaL_error,gte=501"`
+	LuaLLoadstring  func(L unsafe.Pointer, s *byte) int                                    `ffi:"luaL_loadstring,gte=501"`
 	LuaLLoadfilex   func(L unsafe.Pointer, filename *byte, mode *byte) int                 `ffi:"luaL_loadfilex,gte=503"`
 	LuaLLoadbufferx func(L unsafe.Pointer, buff *byte, sz int, name *byte, mode *byte) int `ffi:"luaL_loadbufferx,gte=503"`
+	LuaLLoadfile    func(L unsafe.Pointer, filename *byte) int                             `ffi:"luaL_loadfile,lte=501"`
+	LuaLLoadbuffer  func(L unsafe.Pointer, buff *byte, sz int, name *byte) int             `ffi:"luaL_loadbuffer,lte=501"`
 
 	LuaLSetfuncs func(L unsafe.Pointer, l unsafe.Pointer, nup int) `ffi:"luaL_setfuncs,gte=503"`
 
-	LuaLTraceback func(L unsafe.Pointer, L1 unsafe.Pointer, msg *byte, level int) int `ffi:"luaL_traceback,gte=503"`
+	LuaLTraceback func(L unsafe.Pointer, L1 unsafe.Pointer, msg *byte, level int) int `ffi:"luaL_traceback,gte=503,opt=traceback"`
+
+	// Buffer functions, the luaL_Buffer of Lua 5.1 has another layout and is not supported
+	LuaLBuffinit       func(L unsafe.Pointer, B unsafe.Pointer)               `ffi:"luaL_buffinit,gte=503"`
+	LuaLBuffinitsize   func(L unsafe.Pointer, B unsafe.Pointer, sz int) *byte `ffi:"luaL_buffinitsize,gte=503"`
+	LuaLPrepbuffsize   func(B unsafe.Pointer, sz int) *byte                   `ffi:"luaL_prepbuffsize,gte=503"`
+	LuaLAddlstring     func(B unsafe.Pointer, s *byte, l int)                 `ffi:"luaL_addlstring,gte=503"`
+	LuaLAddvalue       func(B unsafe.Pointer)                                 `ffi:"luaL_addvalue,gte=503"`
+	LuaLPushresult     func(B unsafe.Pointer)                                 `ffi:"luaL_pushresult,gte=503"`
+	LuaLPushresultsize func(B unsafe.Pointer, sz int)                         `ffi:"luaL_pushresultsize,gte=503"`
 
-	LuaLRef      func(L unsafe.Pointer, idx int) int                           `ffi:"luaL_ref,gte=503"`
-	LuaLUnref    func(L unsafe.Pointer, idx int, ref int)                      `ffi:"luaL_unref,gte=503"`
+	LuaLRef      func(L unsafe.Pointer, idx int) int                           `ffi:"luaL_ref,gte=501"`
+	LuaLUnref    func(L unsafe.Pointer, idx int, ref int)                      `ffi:"luaL_unref,gte=501"`
 	LuaLRequiref func(L unsafe.Pointer, modname *byte, openf uintptr, glb int) `ffi:"luaL_requiref,gte=503"`
+
+	// Debug interface
+	LuaGetstack     func(L unsafe.Pointer, level int, ar unsafe.Pointer) int  `ffi:"lua_getstack,gte=501"`
+	LuaGetinfo      func(L unsafe.Pointer, what *byte, ar unsafe.Pointer) int `ffi:"lua_getinfo,gte=501"`
+	LuaSethook      func(L unsafe.Pointer, f uintptr, mask int, count int)    `ffi:"lua_sethook,gte=501"`
+	LuaGethook      func(L unsafe.Pointer) uintptr                            `ffi:"lua_gethook,gte=501"`
+	LuaGethookmask  func(L unsafe.Pointer) int                                `ffi:"lua_gethookmask,gte=501"`
+	LuaGethookcount func(L unsafe.Pointer) int                                `ffi:"lua_gethookcount,gte=501"`
+	LuaGetlocal     func(L unsafe.Pointer, ar unsafe.Pointer, n int) *byte    `ffi:"lua_getlocal,gte=501"`
+	LuaSetlocal     func(L unsafe.Pointer, ar unsafe.Pointer, n int) *byte    `ffi:"lua_setlocal,gte=501"`
+
+	// LuaJIT extensions
+	LuaJITSetmode func(L unsafe.Pointer, idx int, mode int) int `ffi:"luaJIT_setmode,jit"`
 }
 
 // Lib returns the underlying dynamic library handle for this ffi instance.
@@ -175,10 +244,41 @@ func (ffi *ffi) Lib() uintptr {
 	return ffi.lib
 }
 
+// versionString returns the human readable name of the loaded Lua version, such as "Lua 5.4".
+func (ffi *ffi) versionString() string {
+	if ffi.jit {
+		return "LuaJIT"
+	}
+	v := int(ffi.version)
+	return fmt.Sprintf("Lua %d.%d", v/100, v%100)
+}
+
+// unsupported returns the error reported when the named entry point is not available in the loaded library.
+func (ffi *ffi) unsupported(name string) *UnsupportedError {
+	return &UnsupportedError{
+		name:    name,
+		version: ffi.versionString(),
+	}
+}
+
+// isLuaJIT reports whether the loaded library is LuaJIT, which exports the luaJIT_* extensions.
+func isLuaJIT(lib uintptr) bool {
+	_, err := findSymbol(lib, "luaJIT_setmode")
+	return err == nil
+}
+
 // getLuaVersion retrieves the Lua version from the loaded library.
 // It uses the lua_version function to determine the version number.
+// Lua 5.1 does not export lua_version, and the one of LuaJIT returns a pointer, so they are reported as 501.
 // With this, we can conditionally register functions based on the Lua version.
 func getLuaVersion(lib uintptr) (version float64) {
+	if isLuaJIT(lib) {
+		return 501
+	}
+	if _, err := findSymbol(lib, "lua_version"); err != nil {
+		return 501
+	}
+
 	var (
 		luaVersion   func(L unsafe.Pointer) float64
 		luaLNewState func() unsafe.Pointer
@@ -202,19 +302,29 @@ func getLuaVersion(lib uintptr) (version float64) {
 	return
 }
 
-// newFFI loads the Lua 5.4 dynamic library at the specified path and registers all available exported entrypoints.
-// It provides a Go ffi struct ready for low-level Lua C API interaction in memory.
-func newFFI(path string) (FFI *ffi, err error) {
-	lib, err := loadLibrary(path)
+// newFFI loads the Lua dynamic library at the specified path and registers all available exported entrypoints.
+// Every symbol is looked up before being registered, and the missing ones are reported together in a SymbolError.
+// With allowMissing, the missing symbols of optional API groups are left nil instead.
+// With local, the symbols of the library are not made available to the libraries loaded later.
+func newFFI(path string, allowMissing, local bool) (FFI *ffi, err error) {
+	lib, err := loadLibrary(path, local)
 	if err != nil {
 		return
 	}
 
+	for _, name := range []string{"luaL_newstate", "lua_close"} {
+		if _, serr := findSymbol(lib, name); serr != nil {
+			_ = freeLibrary(lib)
+			return nil, &SymbolError{path: path, missing: []string{name}}
+		}
+	}
+
 	ver := getLuaVersion(lib)
 
 	FFI = &ffi{
 		lib:     lib,
 		version: ver,
+		jit:     isLuaJIT(lib),
 	}
 
 	version := int(ver)
@@ -222,6 +332,7 @@ func newFFI(path string) (FFI *ffi, err error) {
 	t := reflect.TypeOf(FFI).Elem()
 	v := reflect.ValueOf(FFI).Elem()
 
+	var missing []string
 	for i := range t.NumField() {
 		field := t.Field(i)
 		if field.Type.Kind() != reflect.Func {
@@ -236,12 +347,31 @@ func newFFI(path string) (FFI *ffi, err error) {
 			continue
 		}
 		fname := tags[0]
-		var register = true
+		var (
+			register = true
+			optional bool
+			alt      string
+		)
 		for _, tag := range tags[1:] {
+			if tag == "jit" {
+				register = FFI.jit
+				if !register {
+					break
+				}
+				continue
+			}
 			tags := strings.Split(tag, "=")
 			if len(tags) != 2 {
 				continue
 			}
+			if tags[0] == "opt" {
+				optional = true
+				continue
+			}
+			if tags[0] == "alt" {
+				alt = tags[1]
+				continue
+			}
 			targetVersion, _ := strconv.Atoi(tags[1])
 			switch tags[0] {
 			case "gte":
@@ -262,9 +392,28 @@ func newFFI(path string) (FFI *ffi, err error) {
 			continue
 		}
 
+		if _, serr := findSymbol(lib, fname); serr != nil {
+			fallback := false
+			if alt != "" {
+				_, aerr := findSymbol(lib, alt)
+				fallback = aerr == nil
+			}
+			if optional && (allowMissing || fallback) {
+				FFI.missing = append(FFI.missing, fname)
+			} else {
+				missing = append(missing, fname)
+			}
+			continue
+		}
+
 		fptr := v.Field(i).Addr().Interface()
 
 		purego.RegisterLibFunc(fptr, lib, fname)
 	}
+
+	if len(missing) > 0 {
+		_ = freeLibrary(lib)
+		return nil, &SymbolError{path: path, missing: missing}
+	}
 	return
 }
@@ -0,0 +1,118 @@
+package lua
+
+import "fmt"
+
+// Garbage collector options of GC, numbered like in Lua 5.4.
+// The binding renumbers them for Lua 5.5, where LUA_GCSETPAUSE and LUA_GCSETSTEPMUL
+// are replaced by parameters of LUA_GCPARAM.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_gc
+const (
+	LUA_GCSTOP       = 0  // stops the collector
+	LUA_GCRESTART    = 1  // restarts the collector
+	LUA_GCCOLLECT    = 2  // performs a full collection cycle
+	LUA_GCCOUNT      = 3  // returns the memory in use in Kbytes
+	LUA_GCCOUNTB     = 4  // returns the remainder of the memory in use divided by 1024
+	LUA_GCSTEP       = 5  // performs a step of collection, returns 1 if it finished a cycle
+	LUA_GCSETPAUSE   = 6  // sets the pause of the collector, returns the previous value
+	LUA_GCSETSTEPMUL = 7  // sets the step multiplier of the collector, returns the previous value
+	LUA_GCISRUNNING  = 9  // returns whether the collector is running, since Lua 5.3
+	LUA_GCGEN        = 10 // changes the collector to generational mode, returns the previous mode, since Lua 5.4
+	LUA_GCINC        = 11 // changes the collector to incremental mode, returns the previous mode, since Lua 5.4
+	// LUA_GCPARAM sets a parameter of the collector to a value, or only reads it for a value of -1,
+	// and returns its previous value. Available since Lua 5.5.
+	LUA_GCPARAM = 12
+)
+
+// Parameters of the collector for LUA_GCPARAM, available since Lua 5.5.
+// See: https://www.lua.org/manual/5.5/manual.html#lua_gc
+const (
+	LUA_GCPMINORMUL   = 0 // controls the minor collections of the generational mode
+	LUA_GCPMAJORMINOR = 1 // controls the shift from major to minor collections
+	LUA_GCPMINORMAJOR = 2 // controls the shift from minor to major collections
+	LUA_GCPPAUSE      = 3 // pause between successive cycles, in percent
+	LUA_GCPSTEPMUL    = 4 // speed of the collector relative to allocation, in percent
+	LUA_GCPSTEPSIZE   = 5 // granularity of the collector
+)
+
+// Options of lua_gc in Lua 5.5, where they are numbered differently.
+const (
+	lua55GcIsRunning = 6
+	lua55GcGen       = 7
+	lua55GcInc       = 8
+	lua55GcParam     = 9
+)
+
+// GC controls the garbage collector with the option what and its arguments, and returns the result of the option.
+// The arguments follow lua_gc of Lua 5.4: LUA_GCSTEP takes the step size, LUA_GCSETPAUSE and LUA_GCSETSTEPMUL
+// the new value, LUA_GCGEN the minor and major multipliers and LUA_GCINC the pause, step multiplier and step size,
+// where zero keeps the current value. LUA_GCPARAM takes the parameter and its value.
+// With Lua 5.5, LUA_GCSETPAUSE and LUA_GCSETSTEPMUL set the LUA_GCPPAUSE and LUA_GCPSTEPMUL parameters,
+// and the arguments of LUA_GCGEN and LUA_GCINC are set as parameters, except the major multiplier
+// and the step size which have no equivalent and panic with an UnsupportedError.
+// LUA_GCPARAM panics with an UnsupportedError before Lua 5.5.
+// Options unknown to the library return -1.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_gc
+func (s *State) GC(what int, args ...int) int {
+	if len(args) > 3 {
+		panic(fmt.Sprintf("lua: GC takes at most 3 arguments, got %d", len(args)))
+	}
+	var a [3]int
+	copy(a[:], args)
+
+	if s.rt.ffi.version < 505 {
+		if what == LUA_GCPARAM {
+			panic(s.rt.ffi.unsupported("lua_gc(LUA_GCPARAM)"))
+		}
+		return s.rt.ffi.callGc(s.luaL, what, a)
+	}
+
+	switch what {
+	case LUA_GCSETPAUSE:
+		return s.gcParam(LUA_GCPPAUSE, a[0])
+	case LUA_GCSETSTEPMUL:
+		return s.gcParam(LUA_GCPSTEPMUL, a[0])
+	case LUA_GCISRUNNING:
+		return s.rt.ffi.callGc(s.luaL, lua55GcIsRunning, a)
+	case LUA_GCGEN:
+		if a[1] != 0 {
+			panic(s.rt.ffi.unsupported("lua_gc(LUA_GCGEN) major multiplier"))
+		}
+		if a[0] != 0 {
+			s.gcParam(LUA_GCPMINORMUL, a[0])
+		}
+		return s.gcMode(s.rt.ffi.callGc(s.luaL, lua55GcGen, [3]int{}))
+	case LUA_GCINC:
+		if a[2] != 0 {
+			panic(s.rt.ffi.unsupported("lua_gc(LUA_GCINC) step size"))
+		}
+		if a[0] != 0 {
+			s.gcParam(LUA_GCPPAUSE, a[0])
+		}
+		if a[1] != 0 {
+			s.gcParam(LUA_GCPSTEPMUL, a[1])
+		}
+		return s.gcMode(s.rt.ffi.callGc(s.luaL, lua55GcInc, [3]int{}))
+	case LUA_GCPARAM:
+		return s.gcParam(a[0], a[1])
+	case lua55GcInc:
+		// Not an option of Lua 5.4, while Lua 5.5 would change the mode.
+		return -1
+	}
+	return s.rt.ffi.callGc(s.luaL, what, a)
+}
+
+// gcParam sets the collector parameter param of Lua 5.5 to value and returns its previous value.
+func (s *State) gcParam(param, value int) int {
+	return s.rt.ffi.callGc(s.luaL, lua55GcParam, [3]int{param, value})
+}
+
+// gcMode converts a collector mode returned by Lua 5.5 into LUA_GCGEN or LUA_GCINC.
+func (s *State) gcMode(mode int) int {
+	switch mode {
+	case lua55GcGen:
+		return LUA_GCGEN
+	case lua55GcInc:
+		return LUA_GCINC
+	}
+	return mode
+}
@@ -0,0 +1,14 @@
+package lua
+
+import "unsafe"
+
+// luaGcFunc is the type of lua_gc, whose extra arguments are variadic since Lua 5.4.
+// On Apple arm64 the variadic arguments are passed on the stack rather than in registers,
+// so the argument registers are filled first: the data argument of Lua 5.1 and 5.3 is in the first of them,
+// and the variadic arguments of Lua 5.4 and 5.5 follow on the stack.
+type luaGcFunc func(L unsafe.Pointer, what int, data, _, _, _, _, _ int, a1, a2, a3 int) int
+
+// callGc calls lua_gc with the option what and up to three arguments.
+func (ffi *ffi) callGc(L unsafe.Pointer, what int, a [3]int) int {
+	return ffi.LuaGc(L, what, a[0], 0, 0, 0, 0, 0, a[0], a[1], a[2])
+}
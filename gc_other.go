@@ -0,0 +1,15 @@
+//go:build !(darwin && arm64)
+
+package lua
+
+import "unsafe"
+
+// luaGcFunc is the type of lua_gc, whose extra arguments are variadic since Lua 5.4.
+// The integer variadic arguments are passed like the fixed ones on these platforms,
+// and the data argument of Lua 5.1 and 5.3 is the first of them.
+type luaGcFunc func(L unsafe.Pointer, what int, a1, a2, a3 int) int
+
+// callGc calls lua_gc with the option what and up to three arguments.
+func (ffi *ffi) callGc(L unsafe.Pointer, what int, a [3]int) int {
+	return ffi.LuaGc(L, what, a[0], a[1], a[2])
+}
@@ -0,0 +1,41 @@
+package lua_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestGC(assert *require.Assertions, L *lua.State) {
+	assert.Zero(L.GC(lua.LUA_GCCOLLECT))
+	assert.Positive(L.GC(lua.LUA_GCCOUNT))
+
+	L.GC(lua.LUA_GCSTOP)
+	if L.Version() >= 503 {
+		assert.Zero(L.GC(lua.LUA_GCISRUNNING))
+	}
+	L.GC(lua.LUA_GCRESTART)
+	if L.Version() >= 503 {
+		assert.Equal(1, L.GC(lua.LUA_GCISRUNNING))
+	}
+
+	// The pause is kept in an encoded form, which may round the value.
+	L.GC(lua.LUA_GCSETPAUSE, 200)
+	assert.InDelta(200, L.GC(lua.LUA_GCSETPAUSE, 300), 20)
+	assert.InDelta(300, L.GC(lua.LUA_GCSETPAUSE, 200), 30)
+
+	if L.Version() >= 504 {
+		L.GC(lua.LUA_GCINC)
+		assert.Equal(lua.LUA_GCINC, L.GC(lua.LUA_GCGEN))
+		assert.Equal(lua.LUA_GCGEN, L.GC(lua.LUA_GCINC, 200))
+	}
+
+	if L.Version() >= 505 {
+		prev := L.GC(lua.LUA_GCPARAM, lua.LUA_GCPSTEPMUL, -1)
+		assert.Positive(prev)
+		assert.Equal(prev, L.GC(lua.LUA_GCPARAM, lua.LUA_GCPSTEPMUL, prev))
+	} else {
+		assert.Panics(func() {
+			L.GC(lua.LUA_GCPARAM, lua.LUA_GCPSTEPMUL, -1)
+		})
+	}
+}
@@ -4,9 +4,11 @@ go 1.24.3
 
 require (
 	github.com/ebitengine/purego v0.8.4
+	github.com/google/pprof v0.0.0-20250403155104-27863c87afa6
 	github.com/smasher164/mem v0.0.0-20200311200026-6e9ed23f934d
 	github.com/stretchr/testify v1.10.0
 	golang.org/x/sys v0.33.0
+	golang.org/x/term v0.32.0
 )
 
 require (
@@ -0,0 +1,47 @@
+package lua
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Handle identifies a Go value by an integer, so that it may be stored in memory owned by C,
+// such as the extra space of a Lua state, without handing a Go pointer to Lua.
+// It is the purego counterpart of runtime/cgo.Handle.
+type Handle uintptr
+
+var (
+	handles   sync.Map
+	handleIdx atomic.Uintptr
+)
+
+// NewHandle returns a handle for the given value.
+// The handle is valid until Delete is called, and keeps the value alive meanwhile.
+func NewHandle(v any) Handle {
+	h := handleIdx.Add(1)
+	if h == 0 {
+		panic("lua: ran out of handle space")
+	}
+
+	handles.Store(h, v)
+	return Handle(h)
+}
+
+// Value returns the value of a valid handle.
+// Panics if the handle is invalid.
+func (h Handle) Value() any {
+	v, ok := handles.Load(uintptr(h))
+	if !ok {
+		panic("lua: misuse of an invalid Handle")
+	}
+	return v
+}
+
+// Delete invalidates the handle, releasing its value.
+// Panics if the handle is already invalid.
+func (h Handle) Delete() {
+	_, ok := handles.LoadAndDelete(uintptr(h))
+	if !ok {
+		panic("lua: misuse of an invalid Handle")
+	}
+}
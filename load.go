@@ -0,0 +1,211 @@
+package lua
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// defaultLoadChunkSize is the size of the buffer Load reads the chunk into, unless set by WithLoadChunkSize.
+const defaultLoadChunkSize = 4096
+
+// maxEmptyReads is the number of reads returning no data and no error tolerated by Load,
+// mirroring the limit of bufio before io.ErrNoProgress.
+const maxEmptyReads = 100
+
+// WithLoadChunkSize sets the size of the buffer Load reads chunks into, 4096 bytes by default.
+// A larger buffer reduces the number of reader callbacks for very large scripts.
+// It applies to the state and to its threads.
+func WithLoadChunkSize(size int) stateOptFunc {
+	return func(o *stateOpt) {
+		o.loadChunkSize = size
+	}
+}
+
+// loadCtx is the state of a Load call shared with the reader callback.
+// It is passed to C as a Handle, so that lua_load only holds an integer rather than a Go pointer.
+type loadCtx struct {
+	r   io.Reader
+	buf []byte
+	// pinner pins buf, which C reads after the reader callback has returned.
+	pinner runtime.Pinner
+	// done is set once the reader reported EOF or err.
+	done bool
+	err  error
+}
+
+var reader = purego.NewCallback(func(_ unsafe.Pointer, ud uintptr, sz *int) *byte {
+	ctx := Handle(ud).Value().(*loadCtx)
+	if ctx.done {
+		return nil
+	}
+
+	for range maxEmptyReads {
+		n, err := ctx.r.Read(ctx.buf)
+		if err != nil {
+			ctx.done = true
+			if err != io.EOF {
+				ctx.err = err
+			}
+		}
+		if n > 0 {
+			// The data read along with an error is still part of the chunk,
+			// the end of input is reported by the next call.
+			*sz = n
+			return &ctx.buf[0]
+		}
+		if ctx.done {
+			return nil
+		}
+	}
+	ctx.done = true
+	ctx.err = io.ErrNoProgress
+	return nil
+})
+
+// loadWriter receives the whole content of the in-memory readers known to write it at once,
+// and loads it in place with luaL_loadbufferx.
+type loadWriter struct {
+	s         *State
+	chunkname string
+	mode      []string
+	loaded    bool
+	err       error
+}
+
+func (w *loadWriter) Write(p []byte) (int, error) {
+	if w.loaded {
+		return 0, errors.New("lua: chunk written in several parts")
+	}
+	w.loaded = true
+	w.err = w.s.LoadBufferx(p, w.chunkname, w.mode...)
+	return len(p), nil
+}
+
+func (w *loadWriter) WriteString(str string) (int, error) {
+	// C only reads the bytes, so the string is handed over without a copy.
+	return w.Write(unsafe.Slice(unsafe.StringData(str), len(str)))
+}
+
+// Load loads a Lua chunk from an io.Reader, compiling but not executing the code. This mirrors lua_load.
+// The chunk of a *bytes.Reader, *strings.Reader or *bytes.Buffer is loaded in place without copies,
+// other readers are read through a buffer reused for the whole call, see WithLoadChunkSize.
+// A read error other than io.EOF stops the loading and is returned wrapped in an Error with status LUA_ERRFILE.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_load
+func (s *State) Load(r io.Reader, chunkname string, mode ...string) (err error) {
+	switch r.(type) {
+	case *bytes.Reader, *strings.Reader, *bytes.Buffer:
+		w := &loadWriter{s: s, chunkname: chunkname, mode: mode}
+		_, err = r.(io.WriterTo).WriteTo(w)
+		if err != nil {
+			return
+		}
+		if !w.loaded {
+			// Nothing was written for an empty reader.
+			return s.LoadBufferx(nil, chunkname, mode...)
+		}
+		return w.err
+	}
+
+	cname, _ := bytePtrFromString(chunkname)
+	var m *byte
+	if len(mode) > 0 {
+		m, _ = bytePtrFromString(mode[0])
+	}
+
+	size := defaultLoadChunkSize
+	if g := s.canonical().group; g.loadChunkSize > 0 {
+		size = g.loadChunkSize
+	}
+	ctx := &loadCtx{
+		r:   r,
+		buf: make([]byte, size),
+	}
+	ctx.pinner.Pin(&ctx.buf[0])
+	defer ctx.pinner.Unpin()
+
+	h := NewHandle(ctx)
+	defer h.Delete()
+
+	var status int
+	if s.rt.ffi.version < 503 {
+		if err = s.checkMode51("lua_load", mode); err != nil {
+			return
+		}
+		status = s.rt.ffi.LuaLoad501(s.luaL, reader, uintptr(h), cname)
+	} else {
+		status = s.rt.ffi.LuaLoad(s.luaL, reader, uintptr(h), cname, m)
+	}
+
+	if ctx.err != nil {
+		// Drop the function or the error message of the truncated chunk.
+		s.Pop(1)
+		return &Error{
+			status:  LUA_ERRFILE,
+			message: fmt.Sprintf("cannot read %s: %v", chunkname, ctx.err),
+			err:     ctx.err,
+		}
+	}
+	return s.CheckError(status)
+}
+
+// LoadFS loads a Lua chunk from the file name of fsys, compiling but not executing the code,
+// so that scripts embedded with go:embed can be loaded like luaL_loadfilex loads files.
+// A leading UTF-8 BOM and a first line starting with # are skipped, keeping the line numbers,
+// and the chunk is named "@name". An empty mode means "bt".
+// Returns an Error with status LUA_ERRFILE wrapping the fs error if the file cannot be read.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_loadfilex
+func (s *State) LoadFS(fsys fs.FS, name string, mode string) (err error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		what := "read"
+		if errors.Is(err, fs.ErrNotExist) {
+			what = "open"
+		}
+		return &Error{
+			status:  LUA_ERRFILE,
+			message: fmt.Sprintf("cannot %s %s: %v", what, name, err),
+			err:     err,
+		}
+	}
+
+	return s.LoadBufferx(skipFileHeader(data), "@"+name, modes(mode)...)
+}
+
+// DoFS loads and runs the Lua chunk from the file name of fsys. See LoadFS for how it is loaded.
+func (s *State) DoFS(fsys fs.FS, name string) (err error) {
+	err = s.LoadFS(fsys, name, "")
+	if err != nil {
+		return
+	}
+	return s.PCall(0, LUA_MULTRET, 0)
+}
+
+// skipFileHeader strips a UTF-8 BOM and a first line starting with #, such as a shebang, like luaL_loadfilex.
+// The newline ending the skipped line is kept so that the line numbers do not change,
+// unless the chunk is precompiled.
+func skipFileHeader(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	if len(data) == 0 || data[0] != '#' {
+		return data
+	}
+
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil
+	}
+	if i+1 < len(data) && data[i+1] == luaSignature[0] {
+		return data[i+1:]
+	}
+	return data[i:]
+}
+
+// luaSignature marks the beginning of a precompiled chunk.
+const luaSignature = "\x1bLua"
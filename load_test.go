@@ -0,0 +1,38 @@
+package lua_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestLoadFS(assert *require.Assertions, L *lua.State) {
+	fsys := fstest.MapFS{
+		"plain.lua":   {Data: []byte("return 1 + 1")},
+		"shebang.lua": {Data: []byte("\xEF\xBB\xBF#!/usr/bin/env lua\nlocal x = 1\nerror('line three')\n")},
+		"only.lua":    {Data: []byte("#!/usr/bin/env lua")},
+	}
+
+	assert.NoError(L.DoFS(fsys, "plain.lua"))
+	assert.EqualValues(2, L.ToInteger(-1))
+	L.Pop(1)
+
+	err := L.DoFS(fsys, "shebang.lua")
+	var luaErr *lua.Error
+	assert.True(errors.As(err, &luaErr))
+	assert.Contains(luaErr.Message(), "shebang.lua:3: line three")
+
+	assert.NoError(L.LoadFS(fsys, "only.lua", "t"))
+	L.Pop(1)
+
+	assert.Error(L.LoadFS(fsys, "plain.lua", "b"))
+
+	err = L.LoadFS(fsys, "missing.lua", "")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	assert.True(errors.As(err, &luaErr))
+	assert.Equal(lua.LUA_ERRFILE, luaErr.Status())
+	assert.Equal(0, L.GetTop())
+}
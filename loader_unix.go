@@ -27,8 +27,16 @@ func bytePtrToString(p *byte) string {
 	return unix.BytePtrToString(p)
 }
 
-func loadLibrary(path string) (uintptr, error) {
-	return purego.Dlopen(path, purego.RTLD_LAZY|purego.RTLD_GLOBAL)
+// releaseLoadedFile reports that the file of a loaded library can be removed at once,
+// as the library stays mapped until it is closed.
+const releaseLoadedFile = true
+
+func loadLibrary(path string, local bool) (uintptr, error) {
+	mode := purego.RTLD_LAZY | purego.RTLD_GLOBAL
+	if local {
+		mode = purego.RTLD_LAZY | purego.RTLD_LOCAL
+	}
+	return purego.Dlopen(path, mode)
 }
 
 func freeLibrary(handle uintptr) error {
@@ -41,3 +49,7 @@ func freeLibrary(handle uintptr) error {
 	}
 	return nil
 }
+
+func findSymbol(handle uintptr, name string) (uintptr, error) {
+	return purego.Dlsym(handle, name)
+}
@@ -26,7 +26,11 @@ func bytePtrToString(p *byte) string {
 	return windows.BytePtrToString(p)
 }
 
-func loadLibrary(path string) (uintptr, error) {
+// releaseLoadedFile reports that the file of a loaded library can be removed at once,
+// which Windows refuses until the library is freed.
+const releaseLoadedFile = false
+
+func loadLibrary(path string, _ bool) (uintptr, error) {
 	handle, err := windows.LoadLibrary(path)
 	if err != nil {
 		return 0, err
@@ -55,3 +59,7 @@ func getProcAddress(handle uintptr, name string) (uintptr, error) {
 	}
 	return proc, nil
 }
+
+func findSymbol(handle uintptr, name string) (uintptr, error) {
+	return getProcAddress(handle, name)
+}
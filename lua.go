@@ -1,85 +1,394 @@
 package lua
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
 )
 
-// luaLib is a singleton instance for managing the loaded Lua dynamic library.
-// Use Init to load a library before using it.
-// Use Deinit to release the library when it is no longer needed.
-var luaLib = new(lib)
+// ErrRuntimeInUse is returned when closing a runtime which still has open states.
+var ErrRuntimeInUse = errors.New("lua: runtime is in use")
 
-type lib struct {
+var (
+	// defaultRuntime is the runtime used by the package level functions such as NewState and NewCallback.
+	// Use Init to load a library into it before using it.
+	// Use Deinit to release the library when it is no longer needed.
+	defaultRuntime atomic.Pointer[Runtime]
+	// defaultMu serializes Init and Deinit.
+	defaultMu sync.Mutex
+)
+
+// Runtime is a loaded Lua dynamic library.
+// Several runtimes may be opened at the same time, even for different Lua versions,
+// and every State created by a runtime is bound to the ffi table of that runtime.
+type Runtime struct {
 	ffi *ffi
+
+	// cleanup releases the file the library has been loaded from, if it was created by the runtime.
+	cleanup func() error
+
+	panicOnce sync.Once
+	panicf    uintptr
+
+	closerOnce sync.Once
+	closeMeta  uintptr
+	gcMeta     uintptr
+
+	hookOnce sync.Once
+	hookf    uintptr
+
+	sentinelOnce sync.Once
+	sentinelGc   uintptr
+
+	closed atomic.Bool
+
+	// mu guards the live states, which prevent the runtime from being closed.
+	// The callbacks are not counted: they only run inside the states, and a Lua error raised by a callback
+	// unwinds its Go frames without running their deferred calls.
+	mu     sync.Mutex
+	states int
+	// idle is closed once no state is open, if someone waits for it.
+	idle chan struct{}
+
+	registry stateRegistry
+	cStrings cStringCache
 }
 
-func (l *lib) assert() {
-	if l == nil || l.ffi == nil {
-		panic("lua library is not loaded, call lua.Init to load a library first")
+func (rt *Runtime) assert() {
+	if rt == nil || rt.closed.Load() {
+		panic("lua library is not loaded, call lua.Init or lua.Open to load a library first")
 	}
 }
 
-// Init loads a Lua dynamic library from the given path to the global singleton instance.
+// enter records a new live state.
+// Panics if the runtime is closed.
+func (rt *Runtime) enter() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.closed.Load() {
+		panic("lua library is not loaded, call lua.Init or lua.Open to load a library first")
+	}
+	rt.states++
+}
+
+// leave releases a live state recorded by enter,
+// waking up CloseContext once the runtime is idle.
+func (rt *Runtime) leave() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.states--
+	if rt.states == 0 && rt.idle != nil {
+		close(rt.idle)
+		rt.idle = nil
+	}
+}
+
+type initOpt struct {
+	want         string
+	allowMissing bool
+	global       bool
+	ignoreEnv    bool
+}
+
+// initOptFunc is an option setter for customizing how a Lua library is located and loaded (internal use).
+type initOptFunc func(o *initOpt)
+
+// AllowMissingSymbols loads libraries which miss the symbols of optional API groups,
+// such as a build without luaL_traceback. The missing entry points are left unavailable,
+// see Capabilities for the groups provided by the library.
+// Without it, any missing symbol makes the loading fail with a SymbolError.
+func AllowMissingSymbols() initOptFunc {
+	return func(o *initOpt) {
+		o.allowMissing = true
+	}
+}
+
+// GlobalSymbols loads the library with RTLD_GLOBAL on unix-like systems, so that C modules loaded by require,
+// such as lpeg or luasocket, can resolve the Lua API from the global namespace.
+// The symbols of libraries of different versions opened this way collide. It is the default of Init.
+// It has no effect on Windows.
+func GlobalSymbols() initOptFunc {
+	return func(o *initOpt) {
+		o.global = true
+	}
+}
+
+// LocalSymbols loads the library with RTLD_LOCAL on unix-like systems, the default of Open,
+// so that the symbols of libraries opened by different runtimes do not collide.
+// C modules loaded by require fail to load into the states of such a runtime. It has no effect on Windows.
+func LocalSymbols() initOptFunc {
+	return func(o *initOpt) {
+		o.global = false
+	}
+}
+
+// Open loads a Lua dynamic library from the given path as a new Runtime.
+// The library is loaded with RTLD_LOCAL on unix-like systems so that libraries of different versions
+// can be opened side by side, pass GlobalSymbols for C modules to link against it.
+// Returns an error if the library cannot be loaded, or a SymbolError if it misses symbols.
+func Open(path string, o ...initOptFunc) (rt *Runtime, err error) {
+	opt := &initOpt{}
+	for _, fn := range o {
+		fn(opt)
+	}
+
+	ffi, err := newFFI(path, opt.allowMissing, !opt.global)
+	if err != nil {
+		return
+	}
+
+	rt = &Runtime{
+		ffi: ffi,
+	}
+	return
+}
+
+// OpenBytes loads a Lua dynamic library from its content as a new Runtime,
+// for example a library embedded with go:embed, so that it does not depend on a path on the host.
+// On Linux the library is written to an anonymous file created by memfd_create,
+// otherwise to a private temporary file. The file is released as soon as the library is loaded
+// on unix-like systems, and by Close on Windows.
 // Returns an error if the library cannot be loaded.
+func OpenBytes(lib []byte, o ...initOptFunc) (rt *Runtime, err error) {
+	path, cleanup, err := createLibraryFile(lib)
+	if err != nil {
+		return
+	}
+
+	rt, err = Open(path, o...)
+	if err != nil {
+		_ = cleanup()
+		return
+	}
+	if releaseLoadedFile {
+		if err = cleanup(); err != nil {
+			_ = rt.Close()
+			return nil, err
+		}
+		return
+	}
+	rt.cleanup = cleanup
+	return
+}
+
+// Close releases the Lua dynamic library of the runtime.
+// Returns an error wrapping ErrRuntimeInUse if a state created by the runtime is still open,
+// use CloseContext to wait for them instead.
+// Panics if the runtime is already closed.
+func (rt *Runtime) Close() (err error) {
+	rt.assert()
+
+	_, err = rt.closeIdle()
+	return
+}
+
+// CloseContext waits until every state created by the runtime is closed,
+// then releases the Lua dynamic library of the runtime.
+// Returns the context error if ctx is done before that.
+// Panics if the runtime is already closed.
+func (rt *Runtime) CloseContext(ctx context.Context) (err error) {
+	rt.assert()
+
+	for {
+		idle, err := rt.closeIdle()
+		if idle == nil {
+			return err
+		}
+
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// closeIdle releases the library if the runtime is idle.
+// Otherwise it returns an error wrapping ErrRuntimeInUse and a channel closed once the runtime becomes idle.
+func (rt *Runtime) closeIdle() (idle <-chan struct{}, err error) {
+	rt.mu.Lock()
+	if rt.closed.Load() {
+		rt.mu.Unlock()
+		panic("lua library is not loaded, call lua.Init or lua.Open to load a library first")
+	}
+	if rt.states > 0 {
+		if rt.idle == nil {
+			rt.idle = make(chan struct{})
+		}
+		idle = rt.idle
+		err = fmt.Errorf("%w: %d states open", ErrRuntimeInUse, rt.states)
+		rt.mu.Unlock()
+		return
+	}
+	rt.closed.Store(true)
+	rt.mu.Unlock()
+
+	err = freeLibrary(rt.ffi.lib)
+	if err != nil {
+		rt.closed.Store(false)
+		return
+	}
+
+	if rt.cleanup != nil {
+		err = rt.cleanup()
+		rt.cleanup = nil
+	}
+	return
+}
+
+// Init loads a Lua dynamic library from the given path to the default runtime.
+// Unlike Open, the library is loaded with RTLD_GLOBAL so that C modules can link against it,
+// unless LocalSymbols is passed.
+// Returns an error if the library cannot be loaded, or a SymbolError if it misses symbols.
 // Calling Init for multiple times without deinit the previous library will result in an error.
-func Init(path string) (err error) {
-	if luaLib != nil && luaLib.ffi != nil {
+func Init(path string, o ...initOptFunc) (err error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultRuntime.Load() != nil {
 		return fmt.Errorf("previous lua library is not closed, call lua.Deinit first")
 	}
 
-	ffi, err := newFFI(path)
+	rt, err := Open(path, append([]initOptFunc{GlobalSymbols()}, o...)...)
 	if err != nil {
 		return
 	}
 
-	*luaLib = lib{
-		ffi: ffi,
+	defaultRuntime.Store(rt)
+
+	return
+}
+
+// InitFromBytes loads a Lua dynamic library from its content to the default runtime,
+// for example a library embedded with go:embed. See OpenBytes for how the library is loaded,
+// and Init for its symbols.
+// The file backing the library is released once loaded, or by Deinit on Windows.
+// Calling InitFromBytes for multiple times without deinit the previous library will result in an error.
+func InitFromBytes(lib []byte, o ...initOptFunc) (err error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultRuntime.Load() != nil {
+		return fmt.Errorf("previous lua library is not closed, call lua.Deinit first")
+	}
+
+	rt, err := OpenBytes(lib, append([]initOptFunc{GlobalSymbols()}, o...)...)
+	if err != nil {
+		return
 	}
 
+	defaultRuntime.Store(rt)
+
 	return
 }
 
-// Deinit releases the loaded Lua dynamic library from the global singleton instance.
+// Deinit releases the loaded Lua dynamic library from the default runtime.
+// Returns an error wrapping ErrRuntimeInUse if a state of the default runtime is still open,
+// use DeinitContext to wait for them instead.
 // Panics if the library is not initialized.
 func Deinit() (err error) {
-	luaLib.assert()
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	rt := defaultRuntime.Load()
+	rt.assert()
+
+	err = rt.Close()
+	if err == nil {
+		defaultRuntime.Store(nil)
+	}
+	return
+}
+
+// DeinitContext waits until every state of the default runtime is closed,
+// then releases the loaded Lua dynamic library from the default runtime.
+// Returns the context error if ctx is done before that.
+// Panics if the library is not initialized.
+func DeinitContext(ctx context.Context) (err error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	rt := defaultRuntime.Load()
+	rt.assert()
 
-	err = freeLibrary(luaLib.ffi.lib)
+	err = rt.CloseContext(ctx)
 	if err == nil {
-		luaLib.ffi = nil
+		defaultRuntime.Store(nil)
 	}
 	return
 }
 
-// NewState creates a new Lua runtime state.
+// NewState creates a new Lua runtime state with the default runtime.
 // Additional options may be provided for custom allocators and user data.
 // Returns a State
 // Panics if the library is not initialized.
 func NewState(o ...stateOptFunc) (L *State) {
-	luaLib.assert()
+	rt := defaultRuntime.Load()
+	rt.assert()
+
+	return rt.NewState(o...)
+}
+
+// NewState creates a new Lua runtime state bound to the runtime.
+// Additional options may be provided for custom allocators and user data.
+// The runtime cannot be closed until the state is closed.
+// Panics if the runtime is closed.
+func (rt *Runtime) NewState(o ...stateOptFunc) (L *State) {
+	rt.assert()
 
 	opt := &stateOpt{}
 	for _, fn := range o {
 		fn(opt)
 	}
 
-	luaL := newState(opt)
+	rt.enter()
+	var luaL unsafe.Pointer
+	defer func() {
+		if luaL == nil {
+			rt.leave()
+		}
+	}()
+	luaL = rt.newState(opt)
+
+	L = rt.BuildState(luaL, o...)
+	L.main = true
+	rt.register(L)
+	L.group.loadChunkSize = opt.loadChunkSize
 
-	L = BuildState(luaL, o...)
+	if rt.ffi.version >= 503 {
+		// The extra space of the main thread is not guaranteed to be initialized by lua_newstate
+		L.SetExtra(0)
+	}
 
 	// Convert Lua errors into Go panics
-	L.AtPanic(defaultPanicf)
+	L.AtPanic(rt.defaultPanicf())
 
 	return
 }
 
-// BuildState create a existing Lua state from a given lua_State pointer.
+// BuildState create a existing Lua state from a given lua_State pointer with the default runtime.
 // Panics if the library is not initialized.
 func BuildState(L unsafe.Pointer, o ...stateOptFunc) (state *State) {
-	luaLib.assert()
+	rt := defaultRuntime.Load()
+	rt.assert()
+
+	return rt.BuildState(L, o...)
+}
+
+// BuildState create a existing Lua state from a given lua_State pointer,
+// which must belong to a Lua state created by the runtime's library.
+// Panics if the runtime is closed.
+func (rt *Runtime) BuildState(L unsafe.Pointer, o ...stateOptFunc) (state *State) {
+	rt.assert()
 
 	opt := &stateOpt{}
 	for _, fn := range o {
@@ -90,41 +399,154 @@ func BuildState(L unsafe.Pointer, o ...stateOptFunc) (state *State) {
 		state = opt.ptr
 		*state = State{
 			luaL: L,
+			rt:   rt,
 		}
 	} else {
 		state = &State{
 			luaL: L,
+			rt:   rt,
 		}
 	}
 
 	return
 }
 
-// FFI returns the underlying ffi instance for advanced usage.
+// Version returns the version number of the Lua library loaded by the runtime, such as 504.
+// Panics if the runtime is closed.
+func (rt *Runtime) Version() float64 {
+	rt.assert()
+
+	return rt.ffi.version
+}
+
+// IsJIT reports whether the library loaded by the runtime is LuaJIT.
+// LuaJIT is driven through the Lua 5.1 API, so its version is reported as 501.
+// Panics if the runtime is closed.
+func (rt *Runtime) IsJIT() bool {
+	rt.assert()
+
+	return rt.ffi.jit
+}
+
+// CapabilityReport describes the optional API groups provided by a loaded Lua library.
+// A group is unavailable when the Lua version does not have it,
+// or when the library misses its symbols and has been loaded with AllowMissingSymbols.
+// The State methods of an unavailable group return or panic with an UnsupportedError.
+type CapabilityReport struct {
+	// Continuations reports lua_yieldk with continuation functions and lua_isyieldable.
+	Continuations bool
+	// Warnings reports lua_setwarnf.
+	Warnings bool
+	// UserValues reports the user values of full userdata.
+	UserValues bool
+	// CloseThread reports lua_closethread or lua_resetthread.
+	CloseThread bool
+	// Traceback reports luaL_traceback.
+	Traceback bool
+	// CloseSlot reports lua_closeslot, added by Lua 5.4.3.
+	CloseSlot bool
+	// Missing lists the symbols of optional groups which the library does not export.
+	Missing []string
+}
+
+// Capabilities reports the optional API groups provided by the library of the default runtime.
+// Panics if the library is not initialized.
+func Capabilities() CapabilityReport {
+	rt := defaultRuntime.Load()
+	rt.assert()
+
+	return rt.Capabilities()
+}
+
+// Capabilities reports the optional API groups provided by the library of the runtime.
+// Panics if the runtime is closed.
+func (rt *Runtime) Capabilities() CapabilityReport {
+	rt.assert()
+
+	ffi := rt.ffi
+	return CapabilityReport{
+		Continuations: ffi.LuaYieldk != nil && ffi.LuaIsyieldable != nil,
+		Warnings:      ffi.LuaSetwarnf != nil,
+		UserValues: ffi.version < 503 ||
+			(ffi.LuaGetuservalue != nil && ffi.LuaSetuservalue != nil) ||
+			(ffi.LuaGetiuservalue != nil && ffi.LuaSetiuservalue != nil),
+		CloseThread: ffi.LuaClosethread != nil || ffi.LuaResetthread != nil,
+		Traceback:   ffi.LuaLTraceback != nil,
+		CloseSlot:   ffi.LuaCloseslot != nil,
+		Missing:     append([]string(nil), ffi.missing...),
+	}
+}
+
+// FFI returns the underlying ffi instance of the default runtime for advanced usage.
 // Panics if the library is not initialized.
 func FFI() *ffi {
-	luaLib.assert()
+	rt := defaultRuntime.Load()
+	rt.assert()
 
-	return luaLib.ffi
+	return rt.ffi
+}
+
+// FFI returns the underlying ffi instance of the runtime for advanced usage.
+// Panics if the runtime is closed.
+func (rt *Runtime) FFI() *ffi {
+	rt.assert()
+
+	return rt.ffi
 }
 
 // NewCallback creates a C function pointer that wraps a Go function
 // that accepts a State and returns an int.
+// The State passed to the Go function is the canonical State of the calling Lua thread,
+// the same one returned by NewState, NewThread or ToThread, so it may be compared and carry Go data.
+// It is bound to the default runtime at the time of the call,
+// use Runtime.NewCallback for functions pushed into states of other runtimes.
 // The returned pointer can be used with PushCFunction or PushCClousure.
 // Due to the limitation of Purego, only a limited number (2000) of callbacks
 // may be created in a single Go process, and any memory allocated for
 // these callbacks is never released.
 func NewCallback(f GoFunc) uintptr {
-	return purego.NewCallback(func(L unsafe.Pointer) int {
-		state := BuildState(L)
-		return f(state)
-	})
+	return nameCallback(purego.NewCallback(func(L unsafe.Pointer) int {
+		rt := defaultRuntime.Load()
+		rt.assert()
+
+		return rt.callback(L, f)
+	}), f)
+}
+
+// NewCallback creates a C function pointer that wraps a Go function
+// that accepts the canonical State of the calling Lua thread, bound to the runtime, and returns an int.
+// The returned pointer can be used with PushCFunction or PushCClousure.
+// Due to the limitation of Purego, only a limited number (2000) of callbacks
+// may be created in a single Go process, and any memory allocated for
+// these callbacks is never released.
+func (rt *Runtime) NewCallback(f GoFunc) uintptr {
+	return nameCallback(purego.NewCallback(func(L unsafe.Pointer) int {
+		return rt.callback(L, f)
+	}), f)
+}
+
+// callbackNames maps the C function pointers created by NewCallback to the names of their Go functions,
+// so that profiles can show the Go callbacks among the Lua frames.
+var callbackNames sync.Map
+
+// nameCallback records the name of the Go function f of the callback cb.
+func nameCallback(cb uintptr, f GoFunc) uintptr {
+	if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+		callbackNames.Store(cb, fn.Name())
+	}
+	return cb
+}
+
+// callback runs the Go function of a callback in the canonical State of the calling thread.
+func (rt *Runtime) callback(L unsafe.Pointer, f GoFunc) int {
+	return f(rt.state(L, nil))
 }
 
 // stateOptFunc is an option setter for customizing State creation (internal use).
 type stateOptFunc func(o *stateOpt)
 
 // WithAlloc sets a custom memory allocation function for the Lua state.
+// To wrap the default allocator of the library rather than reimplementing it, use State.WrapAlloc.
 // Due to the limitation of Purego, only a limited number of callbacks may be created in a single Go
 // process, and any memory allocated for these callbacks is never released.
 // UNSAFE: The userdata must be a pointer type, and it is the caller's responsibility to ensure
@@ -1,56 +1,27 @@
 package lua_test
 
 import (
-	"fmt"
-	"os"
-	"path/filepath"
 	"reflect"
-	"runtime"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	"go.yuchanns.xyz/lua"
+	"go.yuchanns.xyz/lua/luatest"
 )
 
 type Suite struct {
-}
-
-func findLocalLuaLibrary(version string) (string, error) {
-	var pattern string
-
-	switch runtime.GOOS {
-	case "windows":
-		pattern = fmt.Sprintf("lua%s/.lua/lib/lua*%s*.dll", version, version)
-	case "darwin":
-		pattern = fmt.Sprintf("lua%s/.lua/lib/*lua*%s*.dylib", version, version)
-	default: // linux and other unix-like systems
-		pattern = fmt.Sprintf("lua%s/.lua/lib/*lua*%s*.so*", version, version)
-	}
-
-	matches, err := filepath.Glob(pattern)
-	if err != nil {
-		return "", fmt.Errorf("failed to search for Lua library with pattern %s: %w", pattern, err)
-	}
-
-	if len(matches) == 0 {
-		return "", fmt.Errorf("no Lua library found matching pattern %s", pattern)
-	}
-
-	// Return the first match if multiple files are found
-	return matches[0], nil
+	path string
 }
 
 func (s *Suite) Setup() (err error) {
-	version := os.Getenv("LUA_VERSION")
-	if version == "" {
-		version = "54"
-	}
-	path, err := findLocalLuaLibrary(version)
+	s.path, err = lua.FindLibrary(lua.Want(luatest.Version()))
 	if err != nil {
 		return
 	}
-	err = lua.Init(path)
+	// The libraries are opened with local symbols by every test,
+	// so that the runtimes of other versions opened alongside do not resolve to the symbols of this one.
+	err = lua.Init(s.path, lua.LocalSymbols())
 	if err != nil {
 		return
 	}
@@ -58,8 +29,8 @@ func (s *Suite) Setup() (err error) {
 	return nil
 }
 
-func (s *Suite) TearDown() {
-	_ = lua.Deinit()
+func (s *Suite) TearDown(t *testing.T) {
+	require.NoError(t, lua.Deinit())
 }
 
 type funcWithState = func(*Suite, *require.Assertions, *lua.State)
@@ -99,7 +70,7 @@ func TestSuite(t *testing.T) {
 
 	assert.NoError(suite.Setup())
 
-	t.Cleanup(suite.TearDown)
+	t.Cleanup(func() { suite.TearDown(t) })
 
 	L := lua.NewState()
 	t.Cleanup(L.Close)
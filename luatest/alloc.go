@@ -0,0 +1,98 @@
+// Package luatest provides helpers to test Go code using go.yuchanns.xyz/lua,
+// such as a fault-injecting allocator and a harness checking how the code behaves
+// when Lua runs out of memory at any point.
+package luatest
+
+import (
+	"unsafe"
+
+	"go.yuchanns.xyz/lua"
+)
+
+// Allocation describes an allocation request which may be failed by a FaultAllocator.
+type Allocation struct {
+	// N is the position of the request among the counted ones, starting at 1.
+	N int
+	// Ptr is the block to reallocate, or nil for a new block.
+	Ptr unsafe.Pointer
+	// OSize is the size of Ptr, or the kind of object being allocated when Ptr is nil.
+	OSize int
+	// NSize is the requested size.
+	NSize int
+}
+
+// FaultAllocator wraps the allocator of Lua states to fail some of their allocation requests.
+// Only the requests which allocate a new block or grow one are counted and may be failed,
+// since Lua assumes that freeing and shrinking never fail.
+// A FaultAllocator is not safe for concurrent use, it should be installed in a single state.
+type FaultAllocator struct {
+	fail     func(a Allocation) bool
+	disabled bool
+	count    int
+	failed   int
+}
+
+// NewFaultAllocator returns an allocator failing the requests for which fail returns true.
+func NewFaultAllocator(fail func(a Allocation) bool) *FaultAllocator {
+	return &FaultAllocator{fail: fail}
+}
+
+// FailNth returns an allocator failing the nth counted request only.
+// Lua retries a failed request after a full garbage collection,
+// so a single failure may not be visible, see FailFrom.
+func FailNth(n int) *FaultAllocator {
+	return NewFaultAllocator(func(a Allocation) bool {
+		return a.N == n
+	})
+}
+
+// FailFrom returns an allocator failing the nth counted request and all the following ones,
+// which makes Lua raise a memory error at the nth request.
+func FailFrom(n int) *FaultAllocator {
+	return NewFaultAllocator(func(a Allocation) bool {
+		return a.N >= n
+	})
+}
+
+// Install wraps the current allocator of the state L with f.
+func (f *FaultAllocator) Install(L *lua.State) {
+	L.WrapAlloc(f.alloc)
+}
+
+// Enable starts counting and failing requests, which is the initial behaviour.
+func (f *FaultAllocator) Enable() {
+	f.disabled = false
+}
+
+// Disable stops counting and failing requests, for example while a state is prepared.
+func (f *FaultAllocator) Disable() {
+	f.disabled = true
+}
+
+// Reset zeroes the counters of requests.
+func (f *FaultAllocator) Reset() {
+	f.count = 0
+	f.failed = 0
+}
+
+// Count returns the number of requests counted since the last Reset.
+func (f *FaultAllocator) Count() int {
+	return f.count
+}
+
+// Failed returns the number of requests failed since the last Reset.
+func (f *FaultAllocator) Failed() int {
+	return f.failed
+}
+
+func (f *FaultAllocator) alloc(next lua.Allocator, ptr unsafe.Pointer, osize, nsize int) unsafe.Pointer {
+	grows := nsize > 0 && (ptr == nil || nsize > osize)
+	if grows && !f.disabled {
+		f.count++
+		if f.fail(Allocation{N: f.count, Ptr: ptr, OSize: osize, NSize: nsize}) {
+			f.failed++
+			return nil
+		}
+	}
+	return next.Alloc(ptr, osize, nsize)
+}
@@ -0,0 +1,157 @@
+package luatest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.yuchanns.xyz/lua"
+)
+
+// harnessOpt holds the options of EveryAllocation.
+type harnessOpt struct {
+	newState func() *lua.State
+	setup    func(L *lua.State)
+	check    func(err error) error
+}
+
+// harnessOptFunc is an option setter for EveryAllocation.
+type harnessOptFunc func(o *harnessOpt)
+
+// WithNewState sets the function creating the state of each run,
+// which is a state of the default runtime with the standard libraries opened by default.
+func WithNewState(fn func() *lua.State) harnessOptFunc {
+	return func(o *harnessOpt) {
+		o.newState = fn
+	}
+}
+
+// WithSetup sets a function preparing the state of each run before allocation failures are injected,
+// such as registering the Go module under test.
+// The function runs once per allocation of the scenario, so it must not create callbacks with lua.NewCallback:
+// Purego only allows a limited number (2000) of them in a process and never releases them.
+// Create the callbacks once beforehand and only push them in the setup.
+func WithSetup(fn func(L *lua.State)) harnessOptFunc {
+	return func(o *harnessOpt) {
+		o.setup = fn
+	}
+}
+
+// WithErrorCheck sets the function validating the error returned by the scenario when an allocation failed.
+// By default the error must be a memory error, see IsMemoryError.
+func WithErrorCheck(fn func(err error) error) harnessOptFunc {
+	return func(o *harnessOpt) {
+		o.check = fn
+	}
+}
+
+// IsMemoryError reports whether err is a Lua error caused by a memory allocation failure:
+// a LUA_ERRMEM error, a LUA_ERRERR error raised while handling one,
+// or an error whose message has been converted from it.
+func IsMemoryError(err error) bool {
+	var luaErr *lua.Error
+	if !errors.As(err, &luaErr) {
+		return false
+	}
+	switch luaErr.Status() {
+	case lua.LUA_ERRMEM, lua.LUA_ERRERR:
+		return true
+	}
+	return strings.Contains(luaErr.Message(), "not enough memory")
+}
+
+func checkMemoryError(err error) error {
+	if IsMemoryError(err) {
+		return nil
+	}
+	return err
+}
+
+// EveryAllocation runs scenario once without failure to count its allocations,
+// then once more for each of them on a new state, making Lua run out of memory at that allocation.
+// The scenario should run its Lua operations in protected mode and return their error.
+// Each failing run is reported to t when:
+//   - a Go panic escapes from the scenario, such as a Lua error raised in unprotected mode;
+//   - the scenario returns an error which is not a memory error, see WithErrorCheck;
+//   - the stack is not at the level it was before the scenario after an error,
+//     or not at the level of the run without failure after a success.
+func EveryAllocation(t testing.TB, scenario func(L *lua.State) error, o ...harnessOptFunc) {
+	t.Helper()
+
+	opt := &harnessOpt{
+		newState: func() *lua.State {
+			L := lua.NewState()
+			L.OpenLibs()
+			return L
+		},
+		check: checkMemoryError,
+	}
+	for _, fn := range o {
+		fn(opt)
+	}
+
+	count, top, err := runScenario(opt, scenario, NewFaultAllocator(func(Allocation) bool { return false }))
+	if err != nil {
+		t.Fatalf("scenario fails without allocation failure: %v", err)
+	}
+
+	for n := 1; n <= count; n++ {
+		f := FailFrom(n)
+		failedTop, err := func() (top int, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &panicError{value: r}
+				}
+			}()
+			_, top, err = runScenario(opt, scenario, f)
+			return
+		}()
+
+		var p *panicError
+		switch {
+		case errors.As(err, &p):
+			t.Errorf("allocation %d of %d: Go panic: %v", n, count, p.value)
+		case err != nil:
+			if cerr := opt.check(err); cerr != nil {
+				t.Errorf("allocation %d of %d: unexpected error: %v", n, count, cerr)
+			}
+			if failedTop != 0 {
+				t.Errorf("allocation %d of %d: stack imbalance of %d after the error", n, count, failedTop)
+			}
+		default:
+			if failedTop != top {
+				t.Errorf("allocation %d of %d: stack imbalance of %d after the success", n, count, failedTop-top)
+			}
+		}
+	}
+}
+
+// runScenario runs the scenario on a new state with the allocator f,
+// returning the number of allocations and the change of the stack level.
+func runScenario(opt *harnessOpt, scenario func(L *lua.State) error, f *FaultAllocator) (count, top int, err error) {
+	L := opt.newState()
+	defer L.Close()
+
+	f.Disable()
+	f.Install(L)
+	if opt.setup != nil {
+		opt.setup(L)
+	}
+	base := L.GetTop()
+
+	f.Reset()
+	f.Enable()
+	err = scenario(L)
+	f.Disable()
+
+	return f.Count(), L.GetTop() - base, err
+}
+
+// panicError carries a Go panic recovered from a scenario.
+type panicError struct {
+	value any
+}
+
+func (e *panicError) Error() string {
+	return "panic"
+}
@@ -0,0 +1,99 @@
+package luatest_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+	"go.yuchanns.xyz/lua/luatest"
+)
+
+func TestMain(m *testing.M) {
+	luatest.Main(m)
+}
+
+func TestFaultAllocator(t *testing.T) {
+	assert := require.New(t)
+
+	L := lua.NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	f := luatest.FailFrom(1)
+	f.Install(L)
+
+	err := L.DoString(`local t = {} for i = 1, 100 do t[i] = {} end`)
+	assert.True(luatest.IsMemoryError(err), "%v", err)
+	assert.Positive(f.Failed())
+
+	f.Disable()
+	f.Reset()
+	assert.NoError(L.DoString(`local t = {} for i = 1, 100 do t[i] = {} end`))
+	assert.Zero(f.Count())
+}
+
+func TestEveryAllocation(t *testing.T) {
+	luatest.EveryAllocation(t, func(L *lua.State) error {
+		return L.DoString(`
+			local t = {}
+			for i = 1, 20 do t[i] = tostring(i) .. "!" end
+			return table.concat(t, ",")
+		`)
+	})
+
+	// The callback is created once, the setup runs for every allocation.
+	build := lua.NewCallback(func(L *lua.State) int {
+		L.NewTable()
+		for i := range 10 {
+			L.PushString(fmt.Sprintf("value %d", i))
+			L.SetField(-2, fmt.Sprintf("key%d", i))
+		}
+		return 1
+	})
+	luatest.EveryAllocation(t, func(L *lua.State) error {
+		L.GetGlobal("build")
+		return L.PCall(0, 1, 0)
+	}, luatest.WithSetup(func(L *lua.State) {
+		L.PushCFunction(build)
+		L.SetGlobal("build")
+	}))
+}
+
+// recorder collects the failures reported by the harness.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestEveryAllocationImbalance(t *testing.T) {
+	assert := require.New(t)
+
+	r := &recorder{TB: t}
+	luatest.EveryAllocation(r, func(L *lua.State) error {
+		// The value is left on the stack when DoString fails.
+		L.PushInteger(1)
+		err := L.DoString(`local t = {} for i = 1, 10 do t[i] = {} end`)
+		if err == nil {
+			L.Pop(1)
+		}
+		return err
+	})
+	assert.NotEmpty(r.errors)
+	assert.Contains(r.errors[0], "stack imbalance of 1 after the error")
+}
+
+func TestVersion(t *testing.T) {
+	assert := require.New(t)
+
+	t.Setenv("LUA_VERSION", "")
+	assert.Equal("5.4", luatest.Version())
+	t.Setenv("LUA_VERSION", "53")
+	assert.Equal("5.3", luatest.Version())
+	t.Setenv("LUA_VERSION", "luajit")
+	assert.Equal("luajit", luatest.Version())
+}
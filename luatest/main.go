@@ -0,0 +1,76 @@
+package luatest
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"go.yuchanns.xyz/lua"
+)
+
+// mainOpt holds the options of Main.
+type mainOpt struct {
+	use func(rt *lua.Runtime)
+}
+
+// mainOptFunc is an option setter for Main.
+type mainOptFunc func(o *mainOpt)
+
+// WithRuntime makes Main open the library as a separate runtime passed to fn before the tests run,
+// instead of initializing the default runtime.
+func WithRuntime(fn func(rt *lua.Runtime)) mainOptFunc {
+	return func(o *mainOpt) {
+		o.use = fn
+	}
+}
+
+// Version returns the Lua version under test from the LUA_VERSION environment variable
+// in the form expected by lua.Want, such as "5.4" for "54", or "luajit".
+// It defaults to Lua 5.4.
+func Version() string {
+	version := os.Getenv("LUA_VERSION")
+	if version == "" {
+		version = "54"
+	}
+	if strings.EqualFold(version, "luajit") || len(version) < 2 {
+		return version
+	}
+	return version[:1] + "." + version[1:]
+}
+
+// Main is meant to be called from TestMain. It initializes the default runtime with the library
+// of the version under test, see Version, runs the tests of m, releases the runtime and exits.
+// The tests fail when the runtime cannot be released, for instance because a state was left open.
+func Main(m *testing.M, o ...mainOptFunc) {
+	opt := &mainOpt{}
+	for _, fn := range o {
+		fn(opt)
+	}
+
+	var closeRuntime func() error
+	if opt.use != nil {
+		rt, err := lua.OpenAuto(lua.Want(Version()))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opt.use(rt)
+		closeRuntime = rt.Close
+	} else {
+		if err := lua.InitAuto(lua.Want(Version())); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		closeRuntime = lua.Deinit
+	}
+
+	code := m.Run()
+	if err := closeRuntime(); err != nil {
+		fmt.Fprintln(os.Stderr, "release the Lua runtime:", err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
@@ -90,6 +90,9 @@ local function lua_dll(version)
 	end
 end
 
-for _, version in ipairs({ 54, 53 }) do
-	lua_dll(version)
+-- The versions to build are chosen with `luamake -versions 55,54`.
+-- Lua 5.4 and 5.3 are built by default, Lua 5.5 only when requested.
+local versions = lm.versions or "54,53"
+for version in tostring(versions):gmatch("%d+") do
+	lua_dll(tonumber(version))
 end
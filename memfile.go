@@ -0,0 +1,38 @@
+package lua
+
+import (
+	"os"
+	"runtime"
+)
+
+// createTempLibrary writes the library to a private temporary file,
+// returning its path and a function removing it once the library is released.
+func createTempLibrary(lib []byte) (path string, cleanup func() error, err error) {
+	pattern := "liblua-*.so"
+	switch runtime.GOOS {
+	case "windows":
+		pattern = "lua-*.dll"
+	case "darwin":
+		pattern = "liblua-*.dylib"
+	}
+
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return
+	}
+	path = f.Name()
+
+	_, err = f.Write(lib)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", nil, err
+	}
+
+	cleanup = func() error {
+		return os.Remove(path)
+	}
+	return
+}
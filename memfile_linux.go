@@ -0,0 +1,35 @@
+//go:build linux
+
+package lua
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// createLibraryFile writes the library to an anonymous file created by memfd_create,
+// so that nothing is left on the file system. It falls back to a private temporary file
+// when memfd_create is not available.
+func createLibraryFile(lib []byte) (path string, cleanup func() error, err error) {
+	fd, err := unix.MemfdCreate("liblua", unix.MFD_CLOEXEC)
+	if err != nil {
+		return createTempLibrary(lib)
+	}
+
+	for b := lib; len(b) > 0; {
+		var n int
+		n, err = unix.Write(fd, b)
+		if err != nil {
+			_ = unix.Close(fd)
+			return createTempLibrary(lib)
+		}
+		b = b[n:]
+	}
+
+	path = fmt.Sprintf("/proc/self/fd/%d", fd)
+	cleanup = func() error {
+		return unix.Close(fd)
+	}
+	return
+}
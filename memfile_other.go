@@ -0,0 +1,9 @@
+//go:build !linux
+
+package lua
+
+// createLibraryFile writes the library to a private temporary file,
+// as anonymous files are only available on Linux.
+func createLibraryFile(lib []byte) (path string, cleanup func() error, err error) {
+	return createTempLibrary(lib)
+}
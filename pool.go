@@ -0,0 +1,371 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by StatePool.Get after the pool has been closed.
+var ErrPoolClosed = errors.New("lua: state pool is closed")
+
+// poolBaselineKey is the registry key of the globals snapshot taken after a pooled state is warmed up.
+var poolBaselineKey byte
+
+type poolOpt struct {
+	init        func(L *State) error
+	maxSize     int
+	idleTimeout time.Duration
+	stateOpts   []stateOptFunc
+}
+
+// poolOptFunc is an option setter for customizing StatePool creation (internal use).
+type poolOptFunc func(o *poolOpt)
+
+// WithPoolInit sets the function used to warm up every new state of the pool,
+// such as opening libraries and loading a prelude.
+// The globals left by the init function become the baseline restored on each Put.
+func WithPoolInit(fn func(L *State) error) poolOptFunc {
+	return func(o *poolOpt) {
+		o.init = fn
+	}
+}
+
+// WithPoolMaxSize limits the number of states owned by the pool, both idle and in use.
+// Get blocks once the limit is reached until a state is returned or discarded.
+// A size less than or equal to zero means no limit.
+func WithPoolMaxSize(size int) poolOptFunc {
+	return func(o *poolOpt) {
+		o.maxSize = size
+	}
+}
+
+// WithPoolIdleTimeout closes states which have stayed idle in the pool longer than d.
+// A zero duration keeps idle states until the pool is closed.
+func WithPoolIdleTimeout(d time.Duration) poolOptFunc {
+	return func(o *poolOpt) {
+		o.idleTimeout = d
+	}
+}
+
+// WithPoolStateOptions sets the options passed to NewState for every state created by the pool.
+func WithPoolStateOptions(o ...stateOptFunc) poolOptFunc {
+	return func(opt *poolOpt) {
+		opt.stateOpts = o
+	}
+}
+
+type pooledState struct {
+	L     *State
+	since time.Time
+}
+
+// StatePool keeps warmed up Lua states for reuse, so that servers running one state per request
+// do not pay for creating a state, opening libraries and loading a prelude each time.
+// A StatePool is safe for concurrent use by multiple goroutines,
+// while each State it hands out must only be used by one goroutine at a time.
+type StatePool struct {
+	rt  *Runtime
+	opt poolOpt
+
+	mu   sync.Mutex
+	idle []pooledState
+	// owned maps the states of the pool to whether they are idle or being returned by Put,
+	// so that a state put twice is not handed out twice.
+	owned  map[*State]bool
+	wait   chan struct{}
+	closed bool
+	done   chan struct{}
+}
+
+// NewStatePool creates a pool of Lua states of the default runtime configured by the given options.
+// Panics if the library is not initialized.
+func NewStatePool(o ...poolOptFunc) (p *StatePool) {
+	rt := defaultRuntime.Load()
+	rt.assert()
+
+	return rt.NewStatePool(o...)
+}
+
+// NewStatePool creates a pool of Lua states of the runtime configured by the given options.
+// Panics if the runtime is closed.
+func (rt *Runtime) NewStatePool(o ...poolOptFunc) (p *StatePool) {
+	rt.assert()
+
+	p = &StatePool{
+		rt:    rt,
+		owned: make(map[*State]bool),
+		wait:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for _, fn := range o {
+		fn(&p.opt)
+	}
+
+	if p.opt.idleTimeout > 0 {
+		go p.evictLoop()
+	}
+	return
+}
+
+// Get returns an idle state from the pool, or creates a new one when none is idle.
+// If the pool has reached its max size, Get waits until a state is returned or ctx is done.
+func (p *StatePool) Get(ctx context.Context) (L *State, err error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if n := len(p.idle); n > 0 {
+			L = p.idle[n-1].L
+			p.idle = p.idle[:n-1]
+			p.owned[L] = false
+			p.mu.Unlock()
+			return
+		}
+		if p.opt.maxSize <= 0 || len(p.owned) < p.opt.maxSize {
+			// Reserve the slot before creating the state outside of the lock.
+			L = &State{}
+			p.owned[L] = false
+			p.mu.Unlock()
+			return p.create(L)
+		}
+		wait := p.wait
+		p.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Put returns a state obtained from Get to the pool.
+// The stack must be empty and the globals are reset to the baseline taken after warm-up,
+// the Go values attached with SetData are dropped and the extra space is cleared.
+// States that are tainted by a memory error or a panic, or whose stack is not empty,
+// are closed instead of being reused.
+// Putting a state which is not in use, such as a state already put back, does nothing.
+func (p *StatePool) Put(L *State) {
+	if L == nil {
+		return
+	}
+
+	p.mu.Lock()
+	idle, ok := p.owned[L]
+	if !ok || idle {
+		p.mu.Unlock()
+		return
+	}
+	p.owned[L] = true
+	closed := p.closed
+	p.mu.Unlock()
+
+	if closed || L.tainted || L.luaL == nil || p.reset(L) != nil {
+		p.discard(L)
+		return
+	}
+
+	p.mu.Lock()
+	p.idle = append(p.idle, pooledState{L: L, since: time.Now()})
+	p.signal()
+	p.mu.Unlock()
+}
+
+// Discard closes a state obtained from Get instead of returning it to the pool.
+// Use it when the state may be left in an inconsistent condition.
+// Discarding a state which is not in use does nothing.
+func (p *StatePool) Discard(L *State) {
+	if L == nil {
+		return
+	}
+
+	p.mu.Lock()
+	idle, ok := p.owned[L]
+	if !ok || idle {
+		p.mu.Unlock()
+		return
+	}
+	p.owned[L] = true
+	p.mu.Unlock()
+
+	p.discard(L)
+}
+
+// Close closes all idle states and stops the pool.
+// States still in use are closed when they are returned with Put.
+func (p *StatePool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	for _, e := range idle {
+		delete(p.owned, e.L)
+	}
+	p.signal()
+	p.mu.Unlock()
+
+	close(p.done)
+	for _, e := range idle {
+		e.L.Close()
+	}
+}
+
+// create warms up the state L reserved in the owned slots,
+// and frees its slot if warming up fails or panics.
+func (p *StatePool) create(L *State) (_ *State, err error) {
+	ok := false
+	defer func() {
+		if !ok {
+			p.discard(L)
+		}
+	}()
+	if err = p.warmUp(L); err != nil {
+		return nil, err
+	}
+	ok = true
+	return L, nil
+}
+
+func (p *StatePool) warmUp(L *State) (err error) {
+	o := make([]stateOptFunc, 0, len(p.opt.stateOpts)+1)
+	o = append(o, p.opt.stateOpts...)
+	p.rt.NewState(append(o, WithStatePointer(L))...)
+
+	if p.opt.init != nil {
+		err = p.opt.init(L)
+		if err != nil {
+			return
+		}
+	}
+	if L.GetTop() != 0 {
+		return fmt.Errorf("lua: pool init left %d values on the stack", L.GetTop())
+	}
+
+	// Take a shallow copy of the globals as the baseline restored on Put.
+	L.NewTable()
+	L.PushGlobalTable()
+	L.PushNil()
+	for L.Next(-2) {
+		L.PushValue(-2)
+		L.Insert(-2)
+		L.RawSet(-5)
+	}
+	L.Pop(1)
+	L.RawSetP(LUA_REGISTRYINDEX, &poolBaselineKey)
+	return
+}
+
+func (p *StatePool) reset(L *State) (err error) {
+	if L.GetTop() != 0 {
+		return fmt.Errorf("lua: pooled state returned with %d values on the stack", L.GetTop())
+	}
+
+	if L.RawGetP(LUA_REGISTRYINDEX, &poolBaselineKey) != LUA_TTABLE {
+		L.Pop(1)
+		return fmt.Errorf("lua: pooled state has lost its globals baseline")
+	}
+	L.PushGlobalTable()
+
+	// Drop or restore the globals which differ from the baseline.
+	// Assigning existing fields during traversal is allowed by lua_next.
+	L.PushNil()
+	for L.Next(-2) {
+		L.PushValue(-2)
+		L.RawGet(-5)
+		if !L.RawEqual(-1, -2) {
+			L.PushValue(-3)
+			L.Insert(-2)
+			L.RawSet(-5)
+		} else {
+			L.Pop(1)
+		}
+		L.Pop(1)
+	}
+
+	// Bring back the globals which have been removed.
+	L.PushNil()
+	for L.Next(-3) {
+		L.PushValue(-2)
+		L.RawGet(-4)
+		if L.IsNil(-1) {
+			L.Pop(1)
+			L.PushValue(-2)
+			L.Insert(-2)
+			L.RawSet(-4)
+		} else {
+			L.Pop(2)
+		}
+	}
+	L.Pop(2)
+
+	L.clearData()
+	L.SetHook(nil, 0, 0)
+	if L.Version() >= 503 {
+		L.SetExtra(0)
+	}
+	return
+}
+
+func (p *StatePool) discard(L *State) {
+	L.Close()
+
+	p.mu.Lock()
+	delete(p.owned, L)
+	p.signal()
+	p.mu.Unlock()
+}
+
+// signal wakes up every Get waiting for a state. It must be called with p.mu held.
+func (p *StatePool) signal() {
+	close(p.wait)
+	p.wait = make(chan struct{})
+}
+
+func (p *StatePool) evictLoop() {
+	interval := p.opt.idleTimeout / 2
+	if interval <= 0 {
+		interval = p.opt.idleTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.evict(now)
+		}
+	}
+}
+
+func (p *StatePool) evict(now time.Time) {
+	var expired []*State
+
+	p.mu.Lock()
+	// Idle states are appended in order, so the oldest ones are at the front.
+	n := 0
+	for n < len(p.idle) && now.Sub(p.idle[n].since) >= p.opt.idleTimeout {
+		expired = append(expired, p.idle[n].L)
+		delete(p.owned, p.idle[n].L)
+		n++
+	}
+	if n > 0 {
+		p.idle = append(p.idle[:0], p.idle[n:]...)
+		p.signal()
+	}
+	p.mu.Unlock()
+
+	for _, L := range expired {
+		L.Close()
+	}
+}
@@ -0,0 +1,170 @@
+package lua_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestStatePool(assert *require.Assertions, t *testing.T) {
+	var inits int
+	pool := lua.NewStatePool(lua.WithPoolInit(func(L *lua.State) error {
+		inits++
+		L.OpenLibs()
+		return L.DoString(`prelude = { answer = 42 }`)
+	}))
+	t.Cleanup(pool.Close)
+
+	ctx := context.Background()
+
+	L, err := pool.Get(ctx)
+	assert.NoError(err)
+	assert.Equal(1, inits)
+
+	assert.NoError(L.DoString(`leaked = true; prelude = nil; print = nil`))
+	pool.Put(L)
+
+	L2, err := pool.Get(ctx)
+	assert.NoError(err)
+	assert.Same(L, L2)
+	assert.Equal(1, inits)
+
+	L2.GetGlobal("leaked")
+	assert.Equal(lua.LUA_TNIL, L2.Type(-1))
+	L2.Pop(1)
+	L2.GetGlobal("prelude")
+	assert.Equal(lua.LUA_TTABLE, L2.Type(-1))
+	L2.Pop(1)
+	L2.GetGlobal("print")
+	assert.Equal(lua.LUA_TFUNCTION, L2.Type(-1))
+	L2.Pop(1)
+
+	// A state returned with a dirty stack must not be reused.
+	L2.PushInteger(1)
+	pool.Put(L2)
+
+	L3, err := pool.Get(ctx)
+	assert.NoError(err)
+	assert.NotSame(L2, L3)
+	assert.Equal(2, inits)
+	pool.Put(L3)
+}
+
+func (s *Suite) TestStatePoolTainted(assert *require.Assertions, t *testing.T) {
+	pool := lua.NewStatePool()
+	t.Cleanup(pool.Close)
+
+	ctx := context.Background()
+
+	L, err := pool.Get(ctx)
+	assert.NoError(err)
+
+	// An unprotected error raises a Go panic, which taints the state.
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		return L.Errorf("boom")
+	}))
+	assert.Error(L.PCall(0, 0, 0))
+	L.SetTop(0)
+	pool.Put(L)
+
+	L2, err := pool.Get(ctx)
+	assert.NoError(err)
+	assert.NotSame(L, L2)
+	pool.Put(L2)
+}
+
+func (s *Suite) TestStatePoolMaxSize(assert *require.Assertions, t *testing.T) {
+	pool := lua.NewStatePool(lua.WithPoolMaxSize(1))
+	t.Cleanup(pool.Close)
+
+	L, err := pool.Get(context.Background())
+	assert.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = pool.Get(ctx)
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		pool.Put(L)
+	}()
+	L2, err := pool.Get(context.Background())
+	assert.NoError(err)
+	assert.Same(L, L2)
+	pool.Discard(L2)
+
+	L3, err := pool.Get(context.Background())
+	assert.NoError(err)
+	assert.NotSame(L2, L3)
+	pool.Put(L3)
+
+	pool.Close()
+	_, err = pool.Get(context.Background())
+	assert.ErrorIs(err, lua.ErrPoolClosed)
+}
+
+func (s *Suite) TestStatePoolIdleEviction(assert *require.Assertions, t *testing.T) {
+	pool := lua.NewStatePool(lua.WithPoolIdleTimeout(20 * time.Millisecond))
+	t.Cleanup(pool.Close)
+
+	L, err := pool.Get(context.Background())
+	assert.NoError(err)
+	pool.Put(L)
+
+	time.Sleep(100 * time.Millisecond)
+
+	L2, err := pool.Get(context.Background())
+	assert.NoError(err)
+	assert.NotSame(L, L2)
+	pool.Put(L2)
+}
+
+func (s *Suite) TestStatePoolDoublePut(assert *require.Assertions, t *testing.T) {
+	pool := lua.NewStatePool()
+	t.Cleanup(pool.Close)
+
+	ctx := context.Background()
+
+	L, err := pool.Get(ctx)
+	assert.NoError(err)
+	pool.Put(L)
+	pool.Put(L)
+	pool.Discard(L)
+
+	L2, err := pool.Get(ctx)
+	assert.NoError(err)
+	assert.Same(L, L2)
+	L3, err := pool.Get(ctx)
+	assert.NoError(err)
+	assert.NotSame(L2, L3)
+	pool.Put(L2)
+	pool.Put(L3)
+}
+
+func (s *Suite) TestStatePoolInitPanic(assert *require.Assertions, t *testing.T) {
+	fail := true
+	pool := lua.NewStatePool(lua.WithPoolMaxSize(1), lua.WithPoolInit(func(L *lua.State) error {
+		if fail {
+			panic("init")
+		}
+		return nil
+	}))
+	t.Cleanup(pool.Close)
+
+	assert.PanicsWithValue("init", func() {
+		_, _ = pool.Get(context.Background())
+	})
+
+	// The slot reserved for the state which panicked is free again.
+	fail = false
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	L, err := pool.Get(ctx)
+	assert.NoError(err)
+	pool.Put(L)
+}
@@ -0,0 +1,114 @@
+package lua
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// The field numbers of the messages of profile.proto used by the profiler.
+// See: https://github.com/google/pprof/blob/main/proto/profile.proto
+const (
+	pprofProfileSampleType    = 1
+	pprofProfileSample        = 2
+	pprofProfileLocation      = 4
+	pprofProfileFunction      = 5
+	pprofProfileStringTable   = 6
+	pprofProfileTimeNanos     = 9
+	pprofProfileDurationNanos = 10
+	pprofProfilePeriodType    = 11
+	pprofProfilePeriod        = 12
+
+	pprofValueTypeType = 1
+	pprofValueTypeUnit = 2
+
+	pprofSampleLocationID = 1
+	pprofSampleValue      = 2
+
+	pprofLocationID   = 1
+	pprofLocationLine = 4
+
+	pprofLineFunctionID = 1
+	pprofLineLine       = 2
+
+	pprofFunctionID         = 1
+	pprofFunctionName       = 2
+	pprofFunctionSystemName = 3
+	pprofFunctionFilename   = 4
+	pprofFunctionStartLine  = 5
+)
+
+// protoBuffer encodes protocol buffer messages, with only the wire types needed by profile.proto.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) key(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// uintField encodes a varint field, omitted when zero as proto3 does.
+func (b *protoBuffer) uintField(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) intField(field int, x int64) {
+	b.uintField(field, uint64(x))
+}
+
+// uintsField encodes a packed repeated varint field.
+func (b *protoBuffer) uintsField(field int, xs []uint64) {
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytesField(field, packed.data)
+}
+
+func (b *protoBuffer) intsField(field int, xs []int64) {
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(uint64(x))
+	}
+	b.bytesField(field, packed.data)
+}
+
+// bytesField encodes a length-delimited field, which is never omitted so that empty strings keep their index.
+func (b *protoBuffer) bytesField(field int, p []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(p)))
+	b.data = append(b.data, p...)
+}
+
+func (b *protoBuffer) stringField(field int, s string) {
+	b.key(field, 2)
+	b.varint(uint64(len(s)))
+	b.data = append(b.data, s...)
+}
+
+// messageField encodes the embedded message written by fn.
+func (b *protoBuffer) messageField(field int, fn func(m *protoBuffer)) {
+	var m protoBuffer
+	fn(&m)
+	b.bytesField(field, m.data)
+}
+
+// writeGzip writes the encoded message compressed by gzip, as pprof files are.
+func (b *protoBuffer) writeGzip(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,267 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrHookInUse is returned when starting a tool which needs the debug hook of a state which already has one.
+var ErrHookInUse = errors.New("lua: debug hook is already set")
+
+// maxProfileDepth limits the number of frames recorded for a sample.
+const maxProfileDepth = 128
+
+// profileOpt holds the options of StartProfile.
+type profileOpt struct {
+	period int
+}
+
+// profileOptFunc is an option setter for StartProfile.
+type profileOptFunc func(o *profileOpt)
+
+// WithSamplePeriod sets the number of VM instructions between two samples, 1000 by default.
+func WithSamplePeriod(instructions int) profileOptFunc {
+	return func(o *profileOpt) {
+		o.period = instructions
+	}
+}
+
+// Profile samples the Lua call stacks of a state, it is created by StartProfile.
+type Profile struct {
+	L      *State
+	w      io.Writer
+	period int
+	start  time.Time
+
+	// mu guards the samples, which are taken by the hook while Stop may be called from another goroutine.
+	mu      sync.Mutex
+	stopped bool
+
+	samples   map[string]*profileSample
+	order     []*profileSample
+	locations map[profileLocation]uint64
+	functions map[profileFunction]uint64
+	strings   map[string]int64
+	table     []string
+}
+
+// profileSample counts the samples of a call stack.
+type profileSample struct {
+	locations []uint64
+	count     int64
+}
+
+// profileFunction identifies a function of the profile.
+type profileFunction struct {
+	name, systemName, filename string
+	startLine                  int
+}
+
+// profileLocation identifies a line of a function of the profile.
+type profileLocation struct {
+	function uint64
+	line     int
+}
+
+// StartProfile starts a sampling CPU profile of the Lua code run by the thread L,
+// written to w in the pprof format by Stop, for go tool pprof.
+// A sample of the call stack is taken every period of VM instructions, see WithSamplePeriod,
+// through a count hook, so the time spent in C functions and Go callbacks is not sampled,
+// although they appear in the stacks of the Lua functions they call.
+// The Go callbacks created by NewCallback are named after their Go function.
+// When L is the main thread, the coroutines created afterwards are sampled too, the other ones are not,
+// since the Go hook of a coroutine falls back to the one of the main thread, see SetHook.
+// Returns ErrHookInUse if the thread already has a debug hook.
+func StartProfile(L *State, w io.Writer, o ...profileOptFunc) (p *Profile, err error) {
+	opt := &profileOpt{period: 1000}
+	for _, fn := range o {
+		fn(opt)
+	}
+	if opt.period <= 0 {
+		return nil, fmt.Errorf("lua: invalid sample period %d", opt.period)
+	}
+	if L.GetHookMask() != 0 {
+		return nil, ErrHookInUse
+	}
+
+	p = &Profile{
+		L:         L,
+		w:         w,
+		period:    opt.period,
+		start:     time.Now(),
+		samples:   make(map[string]*profileSample),
+		locations: make(map[profileLocation]uint64),
+		functions: make(map[profileFunction]uint64),
+		strings:   map[string]int64{"": 0},
+		table:     []string{""},
+	}
+	L.SetHook(p.sample, LUA_MASKCOUNT, opt.period)
+	return
+}
+
+// Stop stops the profile and writes it.
+// It may be called from another goroutine while the state runs.
+func (p *Profile) Stop() error {
+	p.L.SetHook(nil, 0, 0)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return errors.New("lua: profile already stopped")
+	}
+	p.stopped = true
+	return p.encode(time.Since(p.start)).writeGzip(p.w)
+}
+
+// sample is the count hook recording the call stack of the running thread.
+func (p *Profile) sample(L *State, _ *Debug) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return
+	}
+
+	var (
+		key       strings.Builder
+		locations []uint64
+	)
+	for level := 0; level < maxProfileDepth; level++ {
+		ar, ok := L.GetStack(level)
+		if !ok {
+			break
+		}
+		id := p.location(L, ar)
+		locations = append(locations, id)
+		fmt.Fprintf(&key, "%x,", id)
+	}
+
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &profileSample{locations: locations}
+		p.samples[key.String()] = s
+		p.order = append(p.order, s)
+	}
+	s.count++
+}
+
+// location returns the id of the location of the activation record ar.
+func (p *Profile) location(L *State, ar *Debug) uint64 {
+	L.GetInfo("Sln", ar)
+
+	fn := profileFunction{filename: ar.ShortSrc, startLine: ar.LineDefined}
+	if strings.HasPrefix(ar.Source, "@") {
+		fn.filename = ar.Source[1:]
+	}
+	switch {
+	case ar.What == "main":
+		fn.name = "main chunk"
+	case ar.What == "C":
+		fn.name = ar.Name
+		if name := p.callbackName(L, ar); name != "" {
+			fn.name = name
+		}
+		if fn.name == "" {
+			fn.name = "?"
+		}
+		fn.filename, fn.startLine = "[C]", 0
+	case ar.Name != "":
+		fn.name = ar.Name
+	default:
+		fn.name = fmt.Sprintf("function <%s:%d>", ar.ShortSrc, ar.LineDefined)
+	}
+	fn.systemName = fmt.Sprintf("%s:%d", ar.ShortSrc, ar.LineDefined)
+
+	function, ok := p.functions[fn]
+	if !ok {
+		function = uint64(len(p.functions) + 1)
+		p.functions[fn] = function
+	}
+
+	loc := profileLocation{function: function, line: max(ar.CurrentLine, 0)}
+	id, ok := p.locations[loc]
+	if !ok {
+		id = uint64(len(p.locations) + 1)
+		p.locations[loc] = id
+	}
+	return id
+}
+
+// callbackName returns the name of the Go function of the C function running at ar, if it is a Go callback.
+func (p *Profile) callbackName(L *State, ar *Debug) string {
+	if !L.GetInfo("f", ar) {
+		return ""
+	}
+	defer L.Pop(1)
+
+	name, _ := callbackNames.Load(uintptr(L.ToCFunction(-1)))
+	s, _ := name.(string)
+	return s
+}
+
+// str returns the index of s in the string table of the profile.
+func (p *Profile) str(s string) int64 {
+	i, ok := p.strings[s]
+	if !ok {
+		i = int64(len(p.table))
+		p.strings[s] = i
+		p.table = append(p.table, s)
+	}
+	return i
+}
+
+// encode encodes the profile as a profile.proto message.
+func (p *Profile) encode(duration time.Duration) *protoBuffer {
+	b := &protoBuffer{}
+	valueType := func(field int, typ, unit string) {
+		b.messageField(field, func(m *protoBuffer) {
+			m.intField(pprofValueTypeType, p.str(typ))
+			m.intField(pprofValueTypeUnit, p.str(unit))
+		})
+	}
+	valueType(pprofProfileSampleType, "samples", "count")
+	valueType(pprofProfileSampleType, "instructions", "count")
+
+	for _, s := range p.order {
+		b.messageField(pprofProfileSample, func(m *protoBuffer) {
+			m.uintsField(pprofSampleLocationID, s.locations)
+			m.intsField(pprofSampleValue, []int64{s.count, s.count * int64(p.period)})
+		})
+	}
+
+	for loc, id := range p.locations {
+		b.messageField(pprofProfileLocation, func(m *protoBuffer) {
+			m.uintField(pprofLocationID, id)
+			m.messageField(pprofLocationLine, func(l *protoBuffer) {
+				l.uintField(pprofLineFunctionID, loc.function)
+				l.intField(pprofLineLine, int64(loc.line))
+			})
+		})
+	}
+
+	for fn, id := range p.functions {
+		b.messageField(pprofProfileFunction, func(m *protoBuffer) {
+			m.uintField(pprofFunctionID, id)
+			m.intField(pprofFunctionName, p.str(fn.name))
+			m.intField(pprofFunctionSystemName, p.str(fn.systemName))
+			m.intField(pprofFunctionFilename, p.str(fn.filename))
+			m.intField(pprofFunctionStartLine, int64(fn.startLine))
+		})
+	}
+
+	b.intField(pprofProfileTimeNanos, p.start.UnixNano())
+	b.intField(pprofProfileDurationNanos, int64(duration))
+	valueType(pprofProfilePeriodType, "instructions", "count")
+	b.intField(pprofProfilePeriod, int64(p.period))
+
+	// The string table is complete once everything else has been encoded.
+	for _, s := range p.table {
+		b.stringField(pprofProfileStringTable, s)
+	}
+	return b
+}
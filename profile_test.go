@@ -0,0 +1,94 @@
+package lua_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestProfile(assert *require.Assertions, L *lua.State) {
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		L.PushValue(1)
+		L.Call(0, 0)
+		return 0
+	}))
+	L.SetGlobal("viaGo")
+
+	var out bytes.Buffer
+	p, err := lua.StartProfile(L, &out, lua.WithSamplePeriod(100))
+	assert.NoError(err)
+
+	_, err = lua.StartProfile(L, io.Discard)
+	assert.ErrorIs(err, lua.ErrHookInUse)
+
+	assert.NoError(L.LoadBuffer([]byte(`
+		local function hot(n)
+			local s = 0
+			for i = 1, n do s = s + i % 7 end
+			return s
+		end
+		viaGo(function() hot(100000) end)
+	`), "@script.lua"))
+	assert.NoError(L.PCall(0, 0, 0))
+
+	assert.NoError(p.Stop())
+	assert.Error(p.Stop())
+	assert.Zero(L.GetHookMask())
+
+	prof, err := profile.Parse(&out)
+	assert.NoError(err)
+	assert.NoError(prof.CheckValid())
+
+	assert.Equal([]*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "instructions", Unit: "count"}}, prof.SampleType)
+	assert.Equal(&profile.ValueType{Type: "instructions", Unit: "count"}, prof.PeriodType)
+	assert.EqualValues(100, prof.Period)
+
+	locations := make(map[uint64]*profile.Location)
+	for _, loc := range prof.Location {
+		assert.NotContains(locations, loc.ID)
+		locations[loc.ID] = loc
+	}
+	functions := make(map[uint64]*profile.Function)
+	for _, fn := range prof.Function {
+		assert.NotContains(functions, fn.ID)
+		functions[fn.ID] = fn
+	}
+
+	type frame struct {
+		name, filename string
+		startLine      int64
+		line           int64
+	}
+	var hot []frame
+	assert.NotEmpty(prof.Sample)
+	for _, sample := range prof.Sample {
+		assert.Len(sample.Value, 2)
+		assert.Equal(sample.Value[0]*100, sample.Value[1])
+
+		var stack []frame
+		for _, loc := range sample.Location {
+			assert.Same(loc, locations[loc.ID])
+			assert.Len(loc.Line, 1)
+			fn := loc.Line[0].Function
+			assert.Same(fn, functions[fn.ID])
+			stack = append(stack, frame{fn.Name, fn.Filename, fn.StartLine, loc.Line[0].Line})
+		}
+		if len(stack) > 0 && stack[0].name == "hot" {
+			hot = stack
+		}
+	}
+
+	// The stacks of the hot loop go from hot to the main chunk through the Go callback.
+	assert.Len(hot, 4, "%v", hot)
+	assert.Equal("script.lua", hot[0].filename)
+	assert.EqualValues(2, hot[0].startLine)
+	assert.True(hot[0].line >= 3 && hot[0].line <= 5, "%v", hot[0])
+	assert.Equal(frame{"function <script.lua:7>", "script.lua", 7, 7}, hot[1])
+	assert.True(strings.HasSuffix(hot[2].name, "TestProfile.func1"), "%v", hot[2])
+	assert.Equal(frame{hot[2].name, "[C]", 0, 0}, hot[2])
+	assert.Equal(frame{"main chunk", "script.lua", 0, 7}, hot[3])
+}
@@ -0,0 +1,309 @@
+package lua
+
+import (
+	"slices"
+	"sync"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// threadsKey is the registry key of the weak table mapping the coroutines known to the runtime registry
+// to their sentinel, a userdata whose __gc metamethod forgets the coroutine once it is collected.
+var threadsKey byte
+
+// threadSentinelMetaTable is the name of the metatable of the coroutine sentinels.
+const threadSentinelMetaTable = "lua.thread"
+
+// stateGroup holds the canonical States of a main Lua state and of its threads.
+type stateGroup struct {
+	main *State
+
+	// mu guards the members and the Go data of every State of the group.
+	mu      sync.Mutex
+	members []unsafe.Pointer
+
+	// loadChunkSize is the buffer size of Load set by WithLoadChunkSize.
+	loadChunkSize int
+
+	// allocs are the allocators installed by WrapAlloc, released after lua_close.
+	allocs []Handle
+}
+
+// stateRegistry maps the lua_State pointers of a runtime to their canonical State,
+// grouping the threads under the main thread they belong to.
+type stateRegistry struct {
+	mu     sync.RWMutex
+	states map[unsafe.Pointer]*State
+	groups map[unsafe.Pointer]*stateGroup
+}
+
+// register records L as the canonical State of a new main Lua state.
+func (rt *Runtime) register(L *State) {
+	g := &stateGroup{
+		main:    L,
+		members: []unsafe.Pointer{L.luaL},
+	}
+	L.group = g
+
+	r := &rt.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.states == nil {
+		r.states = make(map[unsafe.Pointer]*State)
+		r.groups = make(map[unsafe.Pointer]*stateGroup)
+	}
+	r.states[L.luaL] = L
+	r.groups[L.luaL] = g
+}
+
+// unregister forgets the main Lua state L and all of its threads, once it is closed.
+func (rt *Runtime) unregister(L unsafe.Pointer) {
+	r := &rt.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.groups[L]
+	if !ok {
+		return
+	}
+	delete(r.groups, L)
+	for _, member := range g.members {
+		delete(r.states, member)
+	}
+}
+
+// state returns the canonical State of the lua_State pointer L, creating it on first use.
+// The thread is added to group if given, otherwise to the group of its main thread.
+// A coroutine is forgotten once it is collected, and its canonical State is checked against its sentinel,
+// since Lua may reuse the address of a collected coroutine before its sentinel is finalized.
+func (rt *Runtime) state(L unsafe.Pointer, group *stateGroup) (s *State) {
+	r := &rt.registry
+	r.mu.RLock()
+	s, ok := r.states[L]
+	r.mu.RUnlock()
+	if ok && (s.sentinel == nil || rt.sentinelOf(L) == s.sentinel) {
+		return
+	}
+
+	var main unsafe.Pointer
+	if group == nil {
+		main = rt.mainThreadOf(L)
+	}
+	// The sentinel is created before locking the registry, since allocating it may run finalizers.
+	var sentinel unsafe.Pointer
+	if !rt.isMainThread(L) {
+		sentinel = rt.newSentinel(L)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok = r.states[L]; ok {
+		if sentinel == nil {
+			return
+		}
+		// A collected coroutine had the same address.
+		r.remove(s)
+	}
+	if r.states == nil {
+		r.states = make(map[unsafe.Pointer]*State)
+		r.groups = make(map[unsafe.Pointer]*stateGroup)
+	}
+
+	if group == nil {
+		group, ok = r.groups[main]
+		if !ok {
+			// A state which has not been created by NewState, such as one given to BuildState.
+			// With Lua 5.1, it may also be a coroutine, which is then the main thread of its group.
+			group = &stateGroup{}
+			group.main = &State{luaL: main, rt: rt, group: group}
+			group.members = []unsafe.Pointer{main}
+			r.groups[main] = group
+			r.states[main] = group.main
+			if main == L {
+				group.main.sentinel = sentinel
+				return group.main
+			}
+		}
+	}
+
+	s = &State{luaL: L, rt: rt, group: group, sentinel: sentinel}
+	group.mu.Lock()
+	group.members = append(group.members, L)
+	group.mu.Unlock()
+	r.states[L] = s
+	return
+}
+
+// remove drops the coroutine s from the registry and from its group.
+// It must be called with r.mu held.
+func (r *stateRegistry) remove(s *State) {
+	delete(r.states, s.luaL)
+	g := s.group
+	if g.main == s {
+		delete(r.groups, s.luaL)
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if i := slices.Index(g.members, s.luaL); i >= 0 {
+		g.members = slices.Delete(g.members, i, i+1)
+	}
+}
+
+// forget drops the coroutine L from the registry once its sentinel is collected,
+// unless L is now the address of another coroutine.
+func (rt *Runtime) forget(L, sentinel unsafe.Pointer) {
+	r := &rt.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.states[L]; ok && s.sentinel == sentinel {
+		r.remove(s)
+	}
+}
+
+// isMainThread reports whether L is the main thread of its Lua state.
+func (rt *Runtime) isMainThread(L unsafe.Pointer) bool {
+	main := rt.ffi.LuaPushthread(L) == 1
+	rt.ffi.LuaSettop(L, -2)
+	return main
+}
+
+// pushThreads pushes the weak table of the coroutine sentinels, creating it on first use.
+func (s *State) pushThreads() {
+	if s.RawGetP(LUA_REGISTRYINDEX, &threadsKey) == LUA_TTABLE {
+		return
+	}
+	s.Pop(1)
+	s.NewTable()
+	s.CreateTable(0, 1)
+	s.PushString("k")
+	s.SetField(-2, "__mode")
+	s.SetIMetaTable(-2)
+	s.PushValue(-1)
+	s.RawSetP(LUA_REGISTRYINDEX, &threadsKey)
+}
+
+// newSentinel creates the sentinel of the coroutine L, a userdata holding L
+// which stays reachable as long as L through the weak table of the sentinels.
+func (rt *Runtime) newSentinel(L unsafe.Pointer) unsafe.Pointer {
+	// The State is only used for the stack operations, it is not the canonical one.
+	t := &State{luaL: L, rt: rt}
+	t.pushThreads()
+	t.PushThread()
+	p := t.NewUserData(int(unsafe.Sizeof(L)))
+	*(*unsafe.Pointer)(p) = L
+	if t.NewMetaTable(threadSentinelMetaTable) {
+		t.PushCFunction(rt.sentinelCallback())
+		t.SetField(-2, "__gc")
+	}
+	t.SetIMetaTable(-2)
+	t.RawSet(-3)
+	t.Pop(1)
+	return p
+}
+
+// sentinelOf returns the sentinel of the coroutine L, or nil if L has none.
+func (rt *Runtime) sentinelOf(L unsafe.Pointer) (p unsafe.Pointer) {
+	t := &State{luaL: L, rt: rt}
+	if t.RawGetP(LUA_REGISTRYINDEX, &threadsKey) == LUA_TTABLE {
+		t.PushThread()
+		t.RawGet(-2)
+		p = t.ToUserData(-1)
+		t.Pop(1)
+	}
+	t.Pop(1)
+	return
+}
+
+// sentinelCallback returns the __gc metamethod of the coroutine sentinels, forgetting their coroutine.
+// It is created once per runtime because purego callbacks are never released.
+func (rt *Runtime) sentinelCallback() uintptr {
+	rt.sentinelOnce.Do(func() {
+		rt.sentinelGc = purego.NewCallback(func(L unsafe.Pointer) int {
+			if p := rt.ffi.LuaTouserdata(L, 1); p != nil {
+				rt.forget(*(*unsafe.Pointer)(p), p)
+			}
+			return 0
+		})
+	})
+	return rt.sentinelGc
+}
+
+// mainThreadOf returns the main thread of the Lua state L from the registry.
+// Lua 5.1 does not keep the main thread in the registry, so L is returned instead.
+func (rt *Runtime) mainThreadOf(L unsafe.Pointer) unsafe.Pointer {
+	if rt.ffi.version < 503 {
+		return L
+	}
+	rt.ffi.LuaRawgeti(L, LUA_REGISTRYINDEX, LUA_RIDX_MAINTHREAD)
+	main := rt.ffi.LuaTothread(L, -1)
+	rt.ffi.LuaSettop(L, -2)
+	return main
+}
+
+// canonical returns the canonical State sharing the lua_State pointer of s,
+// which is s itself unless s has been created by BuildState.
+func (s *State) canonical() *State {
+	if s.group != nil {
+		return s
+	}
+	return s.rt.state(s.luaL, nil)
+}
+
+// MainThread returns the State of the main thread the state belongs to.
+// With Lua 5.1 and LuaJIT, a coroutine which has not been obtained from NewThread or ToThread
+// of a known state is considered as its own main thread.
+func (s *State) MainThread() *State {
+	return s.canonical().group.main
+}
+
+// SetData attaches a Go value to the state under key, replacing the previous one.
+// A nil value removes the key. Like context.WithValue, keys should be of an unexported type.
+// The values are dropped when the main state is closed, or when the coroutine is collected.
+func (s *State) SetData(key, value any) {
+	c := s.canonical()
+	g := c.group
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if value == nil {
+		delete(c.data, key)
+		return
+	}
+	if c.data == nil {
+		c.data = make(map[any]any)
+	}
+	c.data[key] = value
+}
+
+// Data returns the Go value attached to the state under key by SetData, or nil.
+func (s *State) Data(key any) any {
+	c := s.canonical()
+	g := c.group
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return c.data[key]
+}
+
+// clearData drops the Go values attached to the state and to its threads.
+func (s *State) clearData() {
+	c := s.canonical()
+	g := c.group
+
+	r := &s.rt.registry
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, member := range g.members {
+		if m, ok := r.states[member]; ok {
+			m.data = nil
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package lua_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+type registryKey struct{}
+
+func (s *Suite) TestCanonicalState(assert *require.Assertions, L *lua.State) {
+	var seen []*lua.State
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		seen = append(seen, L)
+		return 0
+	}))
+	L.SetGlobal("record")
+
+	assert.NoError(L.DoString(`record() record()`))
+	assert.Len(seen, 2)
+	assert.Same(L, seen[0])
+	assert.Same(L, seen[1])
+	assert.Same(L, L.MainThread())
+
+	co := L.NewThread()
+	assert.Same(L, co.MainThread())
+	assert.Same(co, L.ToThread(-1))
+	L.Pop(1)
+
+	co.GetGlobal("record")
+	co.Call(0, 0)
+	assert.Len(seen, 3)
+	assert.Same(co, seen[2])
+
+	if L.Version() >= 503 {
+		// Threads created from Lua are grouped under their main thread as well.
+		seen = nil
+		assert.NoError(L.DoString(`coroutine.wrap(record)()`))
+		assert.Len(seen, 1)
+		assert.NotSame(L, seen[0])
+		assert.Same(L, seen[0].MainThread())
+	}
+}
+
+func (s *Suite) TestStateData(assert *require.Assertions, L *lua.State) {
+	assert.Nil(L.Data(registryKey{}))
+
+	L.SetData(registryKey{}, "main")
+	assert.Equal("main", L.Data(registryKey{}))
+
+	co := L.NewThread()
+	L.Pop(1)
+	assert.Nil(co.Data(registryKey{}))
+	co.SetData(registryKey{}, 42)
+	assert.Equal(42, co.Data(registryKey{}))
+	assert.Equal("main", L.Data(registryKey{}))
+
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		L.PushString(L.Data(registryKey{}).(string))
+		return 1
+	}))
+	L.Call(0, 1)
+	assert.Equal("main", L.ToString(-1))
+	L.Pop(1)
+
+	// States built from the raw pointer share the data of the canonical one.
+	assert.Equal("main", lua.BuildState(L.L()).Data(registryKey{}))
+
+	L.SetData(registryKey{}, nil)
+	assert.Nil(L.Data(registryKey{}))
+}
+
+func (s *Suite) TestStateDataCollectedCoroutine(assert *require.Assertions, L *lua.State) {
+	var inherited int
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		if L.Data(registryKey{}) != nil {
+			inherited++
+		}
+		L.SetData(registryKey{}, true)
+		return 0
+	}))
+	L.SetGlobal("mark")
+
+	// Lua reuses the addresses of the collected coroutines,
+	// whose canonical States and data must not be handed to the new ones.
+	assert.NoError(L.DoString(`
+		for i = 1, 200 do
+			coroutine.wrap(function() mark() end)()
+			collectgarbage()
+		end
+	`))
+	assert.Zero(inherited)
+	assert.Nil(L.Data(registryKey{}))
+}
@@ -0,0 +1,68 @@
+package lua
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+	"syscall"
+)
+
+// FileResult produces the return values for file-related functions in the standard library
+// (io.open, os.rename, file:seek, etc.) from the Go error of the operation, and returns their count.
+// On success, it pushes true. On failure, it pushes nil, the message "fname: error" (or only the error without fname)
+// and the system error number, or 0 if the error does not carry one.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_fileresult
+func (s *State) FileResult(err error, fname string) int {
+	if err == nil {
+		s.PushBoolean(true)
+		return 1
+	}
+
+	msg := err.Error()
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		// Go already mentions the operation and the path, Lua only the path.
+		msg = pathErr.Err.Error()
+	}
+	if fname != "" {
+		msg = fname + ": " + msg
+	}
+
+	var errno syscall.Errno
+	errors.As(err, &errno)
+
+	s.PushNil()
+	s.PushString(msg)
+	s.PushInteger(int64(errno))
+	return 3
+}
+
+// ExecResult produces the return values for process-related functions in the standard library
+// (os.execute and io.close) from the error returned by exec.Cmd.Run or Wait, and returns their count.
+// It pushes true or nil for the success, "exit" or "signal" for the way the process terminated,
+// and the exit status or the signal number.
+// An error which does not come from the process itself is reported like FileResult.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_execresult
+func (s *State) ExecResult(err error) int {
+	if err == nil {
+		s.PushBoolean(true)
+		s.PushString("exit")
+		s.PushInteger(0)
+		return 3
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return s.FileResult(err, "")
+	}
+
+	s.PushNil()
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		s.PushString("signal")
+		s.PushInteger(int64(ws.Signal()))
+	} else {
+		s.PushString("exit")
+		s.PushInteger(int64(exitErr.ExitCode()))
+	}
+	return 3
+}
@@ -0,0 +1,60 @@
+package lua_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+)
+
+func (s *Suite) TestFileResult(assert *require.Assertions, L *lua.State) {
+	assert.Equal(1, L.FileResult(nil, "x"))
+	assert.True(L.ToBoolean(-1))
+	L.Pop(1)
+
+	_, err := os.Open(filepath.Join(os.TempDir(), "lua-no-such-file"))
+	assert.Equal(3, L.FileResult(err, "missing"))
+	assert.True(L.IsNil(-3))
+	assert.Equal("missing: "+syscall.ENOENT.Error(), L.ToString(-2))
+	assert.Equal(int64(syscall.ENOENT), L.ToInteger(-1))
+	L.Pop(3)
+
+	assert.Equal(3, L.FileResult(os.ErrClosed, ""))
+	assert.Equal(os.ErrClosed.Error(), L.ToString(-2))
+	assert.Equal(int64(0), L.ToInteger(-1))
+	L.Pop(3)
+}
+
+func (s *Suite) TestExecResult(assert *require.Assertions, t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no sh on windows")
+	}
+	L := lua.NewState()
+	defer L.Close()
+
+	assert.Equal(3, L.ExecResult(exec.Command("sh", "-c", "exit 0").Run()))
+	assert.True(L.ToBoolean(-3))
+	assert.Equal("exit", L.ToString(-2))
+	assert.Equal(int64(0), L.ToInteger(-1))
+	L.Pop(3)
+
+	assert.Equal(3, L.ExecResult(exec.Command("sh", "-c", "exit 3").Run()))
+	assert.True(L.IsNil(-3))
+	assert.Equal("exit", L.ToString(-2))
+	assert.Equal(int64(3), L.ToInteger(-1))
+	L.Pop(3)
+
+	assert.Equal(3, L.ExecResult(exec.Command("sh", "-c", "kill -TERM $$").Run()))
+	assert.Equal("signal", L.ToString(-2))
+	assert.Equal(int64(syscall.SIGTERM), L.ToInteger(-1))
+	L.Pop(3)
+
+	assert.Equal(3, L.ExecResult(exec.Command("lua-no-such-command").Run()))
+	assert.True(L.IsNil(-3))
+	L.Pop(3)
+}
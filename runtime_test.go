@@ -0,0 +1,196 @@
+package lua_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.yuchanns.xyz/lua"
+	"go.yuchanns.xyz/lua/luatest"
+)
+
+func (s *Suite) TestRuntime(assert *require.Assertions, t *testing.T) {
+	rt, err := lua.Open(s.path)
+	assert.NoError(err)
+	t.Cleanup(func() { _ = rt.Close() })
+
+	L := rt.NewState()
+	t.Cleanup(L.Close)
+	L.OpenLibs()
+	assert.Same(rt, L.Runtime())
+
+	L.PushCFunction(rt.NewCallback(func(L *lua.State) int {
+		assert.Same(rt, L.Runtime())
+		L.PushInteger(L.CheckInteger(1) * 2)
+		return 1
+	}))
+	L.SetGlobal("double")
+	assert.NoError(L.DoString(`assert(double(21) == 42)`))
+
+	co := L.NewThread()
+	assert.Same(rt, co.Runtime())
+	L.Pop(1)
+}
+
+func (s *Suite) TestRuntimeMultipleVersions(assert *require.Assertions, t *testing.T) {
+	var runtimes []*lua.Runtime
+	for _, version := range []string{"5.3", "5.4", "5.5"} {
+		rt, err := lua.OpenAuto(lua.Want(version))
+		if err != nil {
+			continue
+		}
+		t.Cleanup(func() { _ = rt.Close() })
+		runtimes = append(runtimes, rt)
+	}
+	if len(runtimes) < 2 {
+		t.Skip("at least two of the Lua 5.3, 5.4 and 5.5 libraries are required")
+	}
+
+	for _, rt := range runtimes {
+		L := rt.NewState()
+		L.OpenLibs()
+		assert.NoError(L.DoString(`return _VERSION`))
+		assert.Equal(rt.Version(), L.Version())
+		assert.Equal(fmt.Sprintf("Lua 5.%d", int(rt.Version())%100), L.ToString(-1))
+		L.Close()
+	}
+}
+
+func (s *Suite) TestRuntimeFromBytes(assert *require.Assertions, t *testing.T) {
+	// The library built by make.lua is read at runtime rather than embedded with go:embed,
+	// so that the package still compiles before luamake has been run.
+	lib, err := os.ReadFile(s.path)
+	assert.NoError(err)
+
+	rt, err := lua.OpenBytes(lib)
+	assert.NoError(err)
+
+	L := rt.NewState()
+	L.OpenLibs()
+	assert.NoError(L.DoString(`return 6 * 7`))
+	assert.EqualValues(42, L.ToInteger(-1))
+	L.Close()
+
+	assert.NoError(rt.Close())
+
+	assert.Error(lua.InitFromBytes(lib))
+
+	_, err = lua.OpenBytes([]byte("not a shared library"))
+	assert.Error(err)
+}
+
+func (s *Suite) TestRuntimeLifecycle(assert *require.Assertions, t *testing.T) {
+	rt, err := lua.Open(s.path)
+	assert.NoError(err)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			L := rt.NewState()
+			L.PushCFunction(rt.NewCallback(func(L *lua.State) int {
+				return 0
+			}))
+			L.Call(0, 0)
+			L.Close()
+		}()
+	}
+	wg.Wait()
+
+	L := rt.NewState()
+	assert.ErrorIs(rt.Close(), lua.ErrRuntimeInUse)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(rt.CloseContext(ctx), context.DeadlineExceeded)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		L.Close()
+	}()
+	assert.NoError(rt.CloseContext(context.Background()))
+
+	assert.Panics(func() { rt.NewState() })
+}
+
+func (s *Suite) TestRuntimeCloseAfterCallbackError(assert *require.Assertions, t *testing.T) {
+	rt, err := lua.Open(s.path)
+	assert.NoError(err)
+
+	L := rt.NewState()
+	L.OpenLibs()
+	L.PushCFunction(rt.NewCallback(func(L *lua.State) int {
+		return L.Errorf("callback error")
+	}))
+	L.SetGlobal("fail")
+	L.PushCFunction(rt.NewCallback(func(L *lua.State) int {
+		L.CheckInteger(1)
+		return 0
+	}))
+	L.SetGlobal("check")
+
+	// The errors unwind the Go frames of the callbacks and of the hook up to the pcall of Lua.
+	assert.NoError(L.DoString(`
+		assert(not pcall(fail))
+		assert(not pcall(check, "not a number"))
+	`))
+	L.SetHook(func(L *lua.State, _ *lua.Debug) {
+		L.SetHook(nil, 0, 0)
+		L.Errorf("hook error")
+	}, lua.LUA_MASKCOUNT, 1)
+	assert.Error(L.DoString(`local x = 0 for i = 1, 10 do x = x + i end`))
+	L.Close()
+
+	assert.NoError(rt.Close())
+}
+
+// TestInitFromBytes loads the default runtime from bytes in a child process of the test binary,
+// since the default runtime of this process is held by the Suite.
+// The temporary directory of the child is checked to be left empty by the library file.
+func TestInitFromBytes(t *testing.T) {
+	assert := require.New(t)
+
+	if os.Getenv("LUA_TEST_INIT_FROM_BYTES") == "" {
+		tmp := t.TempDir()
+		cmd := exec.Command(os.Args[0], "-test.run=^TestInitFromBytes$", "-test.v")
+		cmd.Env = append(os.Environ(), "LUA_TEST_INIT_FROM_BYTES=1", "TMPDIR="+tmp, "TMP="+tmp, "TEMP="+tmp)
+		out, err := cmd.CombinedOutput()
+		assert.NoError(err, string(out))
+		assert.Contains(string(out), "--- PASS: TestInitFromBytes")
+		return
+	}
+
+	path, err := lua.FindLibrary(lua.Want(luatest.Version()))
+	assert.NoError(err)
+	lib, err := os.ReadFile(path)
+	assert.NoError(err)
+
+	tempFiles := func() []os.DirEntry {
+		entries, err := os.ReadDir(os.TempDir())
+		assert.NoError(err)
+		return entries
+	}
+
+	assert.NoError(lua.InitFromBytes(lib))
+	if runtime.GOOS != "windows" {
+		// The library file is removed as soon as the library is loaded.
+		assert.Empty(tempFiles())
+	}
+
+	L := lua.NewState()
+	L.OpenLibs()
+	assert.NoError(L.DoString(`return 6 * 7`))
+	assert.EqualValues(42, L.ToInteger(-1))
+	L.Close()
+
+	assert.NoError(lua.Deinit())
+	assert.Empty(tempFiles())
+	assert.Panics(func() { lua.NewState() })
+}
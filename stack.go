@@ -1,81 +1,147 @@
 package lua
 
+import (
+	"unsafe"
+)
+
 // RawEqual reports whether the values at the given indices are primitively equal (using Lua's raw equality).
 // See: https://www.lua.org/manual/5.4/manual.html#lua_rawequal
 func (s *State) RawEqual(idx1, idx2 int) bool {
-	return luaLib.ffi.LuaRawequal(s.luaL, idx1, idx2) != 0
+	return s.rt.ffi.LuaRawequal(s.luaL, s.index(idx1), s.index(idx2)) != 0
 }
 
 // Compare compares two values at the given indices with the specified Lua comparison operation opcode.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_compare
 func (s *State) Compare(idx1, idx2, op int) bool {
-	return luaLib.ffi.LuaCompare(s.luaL, idx1, idx2, op) != 0
+	if s.rt.ffi.version < 503 {
+		switch op {
+		case LUA_OPEQ:
+			return s.rt.ffi.LuaEqual(s.luaL, s.index(idx1), s.index(idx2)) != 0
+		case LUA_OPLT:
+			return s.rt.ffi.LuaLessthan(s.luaL, s.index(idx1), s.index(idx2)) != 0
+		}
+		panic(s.rt.ffi.unsupported("lua_compare"))
+	}
+	return s.rt.ffi.LuaCompare(s.luaL, s.index(idx1), s.index(idx2), op) != 0
 }
 
 // Arith performs the given Lua arithmetic operation using the provided opcode on the top values of the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_arith
 func (s *State) Arith(op int) {
-	luaLib.ffi.LuaArith(s.luaL, op)
+	if s.rt.ffi.version < 503 {
+		panic(s.rt.ffi.unsupported("lua_arith"))
+	}
+	s.rt.ffi.LuaArith(s.luaL, op)
 }
 
 // Concat concatenates the top n values from the stack and pushes the result.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_concat
 func (s *State) Concat(n int) {
-	luaLib.ffi.LuaConcat(s.luaL, n)
+	s.rt.ffi.LuaConcat(s.luaL, n)
 }
 
 // Len computes the length of the value at the given stack index and pushes the result.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_len
 func (s *State) Len(idx int) {
-	luaLib.ffi.LuaLen(s.luaL, idx)
+	if s.rt.ffi.version < 503 {
+		s.len51(idx)
+		return
+	}
+	s.rt.ffi.LuaLen(s.luaL, s.index(idx))
+}
+
+// Length returns the length of the value at idx as a number, like the # operator.
+// It honours the __len metamethod and raises an error if the result is not an integer.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_len
+func (s *State) Length(idx int) int64 {
+	if s.rt.ffi.version < 503 {
+		return s.length51(idx)
+	}
+	return s.rt.ffi.LuaLLen(s.luaL, s.index(idx))
+}
+
+// ToClose marks the given index in the stack as a to-be-closed slot.
+// Like a to-be-closed variable in Lua, the value at that slot will be closed by its __close metamethod
+// when it goes out of scope: when the slot is removed by SetTop or Pop, when the function returns,
+// or when an error unwinds the stack. The value must have a __close metamethod or be a false value.
+// Available since Lua 5.4.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_toclose
+func (s *State) ToClose(idx int) {
+	if s.rt.ffi.LuaToclose == nil {
+		panic(s.rt.ffi.unsupported("lua_toclose"))
+	}
+	s.rt.ffi.LuaToclose(s.luaL, s.index(idx))
+}
+
+// CloseSlot closes the to-be-closed slot at the given index and sets its value to nil.
+// The index must be the last index previously marked to be closed by ToClose that is still active.
+// Available since Lua 5.4.3.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_closeslot
+func (s *State) CloseSlot(idx int) {
+	if s.rt.ffi.LuaCloseslot == nil {
+		panic(s.rt.ffi.unsupported("lua_closeslot"))
+	}
+	s.rt.ffi.LuaCloseslot(s.luaL, s.index(idx))
 }
 
 // AbsIndex converts a possibly negative stack index into an absolute one.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_absindex
 func (s *State) AbsIndex(idx int) int {
-	return luaLib.ffi.LuaAbsindex(s.luaL, idx)
+	if s.rt.ffi.version < 503 {
+		return s.absIndex51(idx)
+	}
+	return s.rt.ffi.LuaAbsindex(s.luaL, s.index(idx))
 }
 
 // GetTop returns the current top index of the stack (number of elements).
 // See: https://www.lua.org/manual/5.4/manual.html#lua_gettop
 func (s *State) GetTop() int {
-	return luaLib.ffi.LuaGettop(s.luaL)
+	return s.rt.ffi.LuaGettop(s.luaL)
 }
 
 // SetTop sets the stack top to the given index, popping or pushing as needed.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_settop
 func (s *State) SetTop(idx int) {
-	luaLib.ffi.LuaSettop(s.luaL, idx)
+	s.rt.ffi.LuaSettop(s.luaL, idx)
 }
 
 // PushValue pushes a copy of the element at the given stack index onto the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_pushvalue
 func (s *State) PushValue(idx int) {
-	luaLib.ffi.LuaPushvalue(s.luaL, idx)
+	s.rt.ffi.LuaPushvalue(s.luaL, s.index(idx))
 }
 
 // Rotate performs a circular rotation of n elements at the given index.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_rotate
 func (s *State) Rotate(idx, n int) {
-	luaLib.ffi.LuaRotate(s.luaL, idx, n)
+	if s.rt.ffi.version < 503 {
+		s.rotate51(idx, n)
+		return
+	}
+	s.rt.ffi.LuaRotate(s.luaL, idx, n)
 }
 
 // Copy copies the value at fromidx to toidx in the stack, overwriting the destination.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_copy
 func (s *State) Copy(fromidx, toidx int) {
-	luaLib.ffi.LuaCopy(s.luaL, fromidx, toidx)
+	if s.rt.ffi.version < 503 {
+		s.rt.ffi.LuaPushvalue(s.luaL, s.index(fromidx))
+		s.rt.ffi.LuaReplace(s.luaL, s.index(toidx))
+		return
+	}
+	s.rt.ffi.LuaCopy(s.luaL, s.index(fromidx), s.index(toidx))
 }
 
 // CheckStack ensures there is space for at least sz more elements on the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_checkstack
 func (s *State) CheckStack(sz int) bool {
-	return luaLib.ffi.LuaCheckstack(s.luaL, sz) != 0
+	return s.rt.ffi.LuaCheckstack(s.luaL, sz) != 0
 }
 
 // XMove moves n values between stacks of different Lua states.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_xmove
 func (s *State) XMove(to *State, n int) {
-	luaLib.ffi.LuaXmove(s.luaL, to.luaL, n)
+	s.rt.ffi.LuaXmove(s.luaL, to.luaL, n)
 }
 
 // Pop removes n values from the top of the stack, equivalent to SetTop(-n-1).
@@ -103,40 +169,55 @@ func (s *State) Replace(idx int) {
 // PushNil pushes a nil value onto the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_pushnil
 func (s *State) PushNil() {
-	luaLib.ffi.LuaPushnil(s.luaL)
+	s.rt.ffi.LuaPushnil(s.luaL)
 }
 
 // PushNumber pushes a float64 value onto the stack as a Lua number.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_pushnumber
 func (s *State) PushNumber(n float64) {
-	luaLib.ffi.LuaPushnumber(s.luaL, n)
+	s.rt.ffi.LuaPushnumber(s.luaL, n)
 }
 
 // PushInteger pushes an int64 value onto the stack as a Lua integer.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_pushinteger
 func (s *State) PushInteger(n int64) {
-	luaLib.ffi.LuaPushinteger(s.luaL, n)
+	s.rt.ffi.LuaPushinteger(s.luaL, n)
 }
 
 // PushLString pushes a given Go string onto the stack as a Lua string with explicit length.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_pushlstring
 func (s *State) PushLString(sv string) (ret *byte) {
-	p, _ := bytePtrFromString(sv)
-	ret = luaLib.ffi.LuaPushlstring(s.luaL, p, len(sv))
+	p := &emptyCString
+	if sv != "" {
+		// lua_pushlstring copies the bytes, so the Go string is handed over without a copy
+		p = unsafe.StringData(sv)
+	}
+	ret = s.rt.ffi.LuaPushlstring(s.luaL, p, len(sv))
+	if s.rt.ffi.version < 503 {
+		// lua_pushlstring returns nothing in Lua 5.1
+		ret = s.rt.ffi.LuaTolstring(s.luaL, -1, nil)
+	}
 	return
 }
 
 // PushString pushes a null-terminated string as a Lua string onto the stack.
+// Like lua_pushstring reading a C string, the string is cut at its first NUL byte.
+// Use PushLString to push strings with embedded zeros.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_pushstring
 func (s *State) PushString(sv string) (ret *byte) {
-	p, _ := bytePtrFromString(sv)
-	ret = luaLib.ffi.LuaPushstring(s.luaL, p)
+	// lua_pushstring is lua_pushlstring up to the NUL byte, which avoids a NUL-terminated copy
+	p, n := cStringData(sv)
+	ret = s.rt.ffi.LuaPushlstring(s.luaL, p, n)
+	if s.rt.ffi.version < 503 {
+		// lua_pushlstring returns nothing in Lua 5.1
+		ret = s.rt.ffi.LuaTolstring(s.luaL, -1, nil)
+	}
 	return
 }
 
 // GetUpValue retrieves the name of the n-th upvalue of a function at funcindex.
 func (s *State) SetUpValue(funcindex int, n int) (name string) {
-	namePtr := luaLib.ffi.LuaSetupvalue(s.luaL, funcindex, n)
+	namePtr := s.rt.ffi.LuaSetupvalue(s.luaL, s.index(funcindex), n)
 	if namePtr != nil {
 		name = bytePtrToString(namePtr)
 	}
@@ -145,7 +226,7 @@ func (s *State) SetUpValue(funcindex int, n int) (name string) {
 
 // GetUpValue retrieves the name of the n-th upvalue of a function at funcindex.
 func (s *State) GetUpValue(funcindex int, n int) (name string) {
-	namePtr := luaLib.ffi.LuaGetupvalue(s.luaL, funcindex, n)
+	namePtr := s.rt.ffi.LuaGetupvalue(s.luaL, s.index(funcindex), n)
 	if namePtr != nil {
 		name = bytePtrToString(namePtr)
 	}
@@ -164,7 +245,7 @@ func (s *State) PushBoolean(b bool) int {
 	if b {
 		v = 1
 	}
-	return luaLib.ffi.LuaPushboolean(s.luaL, v)
+	return s.rt.ffi.LuaPushboolean(s.luaL, v)
 }
 
 // PushLightUserData pushes a light userdata onto the stack.
@@ -172,7 +253,7 @@ func (s *State) PushBoolean(b bool) int {
 // that the pointer remains valid for the lifetime of the Lua state.
 func (s *State) PushLightUserData(ud any) {
 	p := toLightUserData(ud)
-	luaLib.ffi.LuaPushlightuserdata(s.luaL, p)
+	s.rt.ffi.LuaPushlightuserdata(s.luaL, p)
 }
 
 // PushCFunction pushes a C function pointer as a Lua C closure with no upvalues.
@@ -185,5 +266,5 @@ func (s *State) PushCFunction(f uintptr) {
 // PushCClousure pushes a C function pointer as a Lua C closure with n upvalues.
 // A C function pointer can be created from a Go function using NewCallback,
 func (s *State) PushCClousure(f uintptr, n int) {
-	luaLib.ffi.LuaPushcclousure(s.luaL, f, n)
+	s.rt.ffi.LuaPushcclousure(s.luaL, f, n)
 }
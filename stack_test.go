@@ -504,3 +504,37 @@ func (s *Suite) TestStackLen(assert *require.Assertions, L *lua.State) {
 
 	L.Pop(2)
 }
+
+func (s *Suite) TestLength(assert *require.Assertions, L *lua.State) {
+	L.PushString("hello")
+	assert.Equal(int64(5), L.Length(-1))
+	L.Pop(1)
+
+	assert.NoError(L.DoString(`
+		list = {1, 2, 3}
+		sized = setmetatable({}, {__len = function() return 7 end})
+		broken = setmetatable({}, {__len = function() return "many" end})
+	`))
+
+	L.GetGlobal("list")
+	assert.Equal(int64(3), L.Length(-1))
+	L.Pop(1)
+
+	L.GetGlobal("sized")
+	assert.Equal(int64(7), L.Length(-1))
+	L.Pop(1)
+	assert.Equal(0, L.GetTop())
+
+	L.GetGlobal("broken")
+	assert.Panics(func() {
+		L.Length(-1)
+	})
+}
+
+func (s *Suite) TestPushStringEmbeddedZero(assert *require.Assertions, L *lua.State) {
+	L.PushString("abc\x00def")
+	assert.EqualValues(3, L.RawLen(-1))
+	L.PushLString("abc\x00def")
+	assert.EqualValues(7, L.RawLen(-1))
+	L.Pop(2)
+}
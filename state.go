@@ -2,16 +2,19 @@ package lua
 
 import (
 	"fmt"
-	"io"
+	"math/rand/v2"
+	"runtime"
+	"strings"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
 )
 
 type stateOpt struct {
-	alloc    uintptr
-	userData unsafe.Pointer
-	ptr      *State
+	alloc         uintptr
+	userData      unsafe.Pointer
+	ptr           *State
+	loadChunkSize int
 }
 
 // State represents a single Lua interpreter state, holding runtime and memory context.
@@ -19,24 +22,60 @@ type stateOpt struct {
 // See: https://www.lua.org/manual/5.4/manual.html#lua_State
 type State struct {
 	luaL unsafe.Pointer
-}
-
-func newState(o *stateOpt) (L unsafe.Pointer) {
-	ffi := luaLib.ffi
-	if o.userData != nil && o.alloc != 0 {
-		L = ffi.LuaNewstate(o.alloc, o.userData)
-	} else {
+	rt   *Runtime
+
+	// tainted is set once the state hit a memory error or a Go panic,
+	// after which it is no longer safe to be reused.
+	tainted bool
+	// main is set for the states created by NewState, which keep their runtime open until closed.
+	main bool
+
+	// group is set for the canonical States kept by the runtime registry,
+	// data holds their Go values set by SetData, guarded by the group.
+	group *stateGroup
+	data  map[any]any
+	// hook is the Go debug hook set by SetHook, guarded by the group.
+	hook HookFunc
+	// sentinel is the userdata of a canonical coroutine, whose collection removes it from the registry.
+	sentinel unsafe.Pointer
+}
+
+func (rt *Runtime) newState(o *stateOpt) (L unsafe.Pointer) {
+	ffi := rt.ffi
+	switch {
+	case o.userData == nil || o.alloc == 0:
 		L = ffi.LuaLNewstate()
+	case ffi.version >= 505:
+		// lua_newstate takes the seed for string hashing since Lua 5.5
+		L = ffi.LuaNewstate505(o.alloc, o.userData, rand.Uint32())
+	default:
+		L = ffi.LuaNewstate(o.alloc, o.userData)
+	}
+	if L == nil {
+		// LuaJIT on 64-bit platforms refuses custom allocators with lua_newstate
+		panic(fmt.Sprintf("lua: cannot create a state with %s, out of memory or unsupported allocator", ffi.versionString()))
 	}
 
 	return
 }
 
-var defaultPanicf = NewCallback(func(L *State) int {
-	err := L.checkUnprotectedError()
+// defaultPanicf returns the panic handler converting unprotected Lua errors into Go panics.
+// It is created once per runtime because purego callbacks are never released.
+func (rt *Runtime) defaultPanicf() uintptr {
+	rt.panicOnce.Do(func() {
+		rt.panicf = rt.NewCallback(func(L *State) int {
+			err := L.checkUnprotectedError()
 
-	panic(err)
-})
+			panic(err)
+		})
+	})
+	return rt.panicf
+}
+
+// Runtime returns the runtime the state is bound to.
+func (s *State) Runtime() *Runtime {
+	return s.rt
+}
 
 // L returns the underlying unsafe.Pointer to the Lua state, allowing direct access to and modify the C API.
 func (s *State) L() unsafe.Pointer {
@@ -46,7 +85,12 @@ func (s *State) L() unsafe.Pointer {
 // OpenLibs loads all standard Lua libraries into the current state.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_openlibs
 func (s *State) OpenLibs() {
-	luaLib.ffi.LuaLOpenlibs(s.luaL)
+	if s.rt.ffi.version >= 505 {
+		// luaL_openlibs(L) is defined as luaL_openselectedlibs(L, ~0, 0) since Lua 5.5
+		s.rt.ffi.LuaLOpenselectedlibs(s.luaL, ^0, 0)
+		return
+	}
+	s.rt.ffi.LuaLOpenlibs(s.luaL)
 }
 
 // Close properly shuts down and deallocates the Lua state, freeing any owned resources.
@@ -57,8 +101,17 @@ func (s *State) Close() {
 		return
 	}
 
-	luaLib.ffi.LuaClose(s.luaL)
+	g := s.canonical().group
+	// The finalizers run by lua_close may still call back into Go with the threads of the state.
+	s.rt.ffi.LuaClose(s.luaL)
+	s.rt.unregister(s.luaL)
 	s.luaL = nil
+	g.releaseAllocs()
+
+	if s.main {
+		s.main = false
+		s.rt.leave()
+	}
 }
 
 type GoFunc func(L *State) int
@@ -68,13 +121,13 @@ type GoFunc func(L *State) int
 // process, and any memory allocated for these callbacks is never released.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_atpanic
 func (s *State) AtPanic(panicf uintptr) (old unsafe.Pointer) {
-	return luaLib.ffi.LuaAtpanic(s.luaL, panicf)
+	return s.rt.ffi.LuaAtpanic(s.luaL, panicf)
 }
 
 // Version returns the current version of the Lua runtime loaded in this state.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_version
 func (s *State) Version() float64 {
-	return luaLib.ffi.version
+	return s.rt.ffi.version
 }
 
 // CheckError transforms a Lua C API error code into a Go error,
@@ -83,6 +136,9 @@ func (s *State) CheckError(status int) error {
 	if status == LUA_OK {
 		return nil
 	}
+	if status == LUA_ERRMEM {
+		s.tainted = true
+	}
 	msg := s.ToString(-1)
 	s.Pop(1)
 	return &Error{
@@ -101,61 +157,61 @@ func (s *State) checkUnprotectedError() error {
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_error
 func (s *State) Errorf(format string, args ...any) int {
 	msg := fmt.Sprintf(format, args...)
-	b, _ := bytePtrFromString(msg)
-	return luaLib.ffi.LuaLError(s.luaL, b)
+	// The message is the format of luaL_error, its conversions are already done.
+	b, _ := bytePtrFromString(strings.ReplaceAll(msg, "%", "%%"))
+	return s.rt.ffi.LuaLError(s.luaL, b)
+}
+
+// Where pushes onto the stack a string identifying the current position of the control at level lvl in the call stack,
+// in the format chunkname:currentline:. Level 0 is the running function,
+// level 1 is the function that called the running function, etc.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_where
+func (s *State) Where(lvl int) string {
+	s.rt.ffi.LuaLWhere(s.luaL, lvl)
+	return s.toGoString(-1)
+}
+
+// Gsub creates a copy of string str, replacing any occurrence of the string p with the string r,
+// pushes the resulting string onto the stack and returns it.
+// The replacement is done on the Go side, so that all the strings may contain embedded zeros.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_gsub
+func (s *State) Gsub(str, p, r string) string {
+	result := strings.ReplaceAll(str, p, r)
+	s.PushString(result)
+	return result
 }
 
 // Traceback pushes a traceback message onto the stack, useful for debugging.
 func (s *State) Traceback(L1 *State, message string, level int) {
+	if s.rt.ffi.LuaLTraceback == nil {
+		panic(s.rt.ffi.unsupported("luaL_traceback"))
+	}
 	b, _ := bytePtrFromString(message)
-	luaLib.ffi.LuaLTraceback(s.luaL, L1.luaL, b, level)
+	s.rt.ffi.LuaLTraceback(s.luaL, L1.luaL, b, level)
 }
 
 // SetGlobal sets a global variable in the Lua environment using the value at the top of the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_setglobal
 func (s *State) SetGlobal(name string) {
-	n, _ := bytePtrFromString(name)
-	luaLib.ffi.LuaSetglobal(s.luaL, n)
+	n := s.rt.cString(name)
+	if s.rt.ffi.version < 503 {
+		// lua_setglobal is a macro over LUA_GLOBALSINDEX in Lua 5.1
+		s.rt.ffi.LuaSetfield(s.luaL, LUA_GLOBALSINDEX, n)
+		return
+	}
+	s.rt.ffi.LuaSetglobal(s.luaL, n)
 }
 
 // GetGlobal retrieves a global variable from the Lua environment and pushes it onto the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_getglobal
 func (s *State) GetGlobal(name string) {
-	n, _ := bytePtrFromString(name)
-	luaLib.ffi.LuaGetglobal(s.luaL, n)
-}
-
-var reader = purego.NewCallback(func(_ unsafe.Pointer, ud unsafe.Pointer, sz *int) *byte {
-	buf := make([]byte, 4096)
-	reader := *(*io.Reader)(ud)
-	n, err := reader.Read(buf)
-	if err != nil {
-		if err == io.EOF {
-			return nil
-		}
-		return nil
-	}
-	if n == 0 {
-		return nil
-	}
-	*sz = n
-	return &buf[0]
-})
-
-// Load loads a Lua chunk from an io.Reader, compiling but not executing the code. This mirrors lua_load.
-// See: https://www.lua.org/manual/5.4/manual.html#lua_load
-func (s *State) Load(r io.Reader, chunkname string, mode ...string) (err error) {
-	cname, _ := bytePtrFromString(chunkname)
-	var m *byte
-	if len(mode) > 0 {
-		m, _ = bytePtrFromString(mode[0])
+	n := s.rt.cString(name)
+	if s.rt.ffi.version < 503 {
+		// lua_getglobal is a macro over LUA_GLOBALSINDEX in Lua 5.1
+		s.rt.ffi.LuaGetfield(s.luaL, LUA_GLOBALSINDEX, n)
+		return
 	}
-
-	// SAFETY: it is safe to pass the reader as an unsafe.Pointer because
-	// the reader immediately consumes the data from the io.Reader after
-	// the call to LuaLoad. So it will not outlive the io.Reader.
-	err = s.CheckError(luaLib.ffi.LuaLoad(s.luaL, reader, unsafe.Pointer(&r), cname, m))
-	return
+	s.rt.ffi.LuaGetglobal(s.luaL, n)
 }
 
 // LoadBuffer loads a Lua chunk from a byte slice with the given chunk name.
@@ -175,8 +231,19 @@ func (s *State) LoadBufferx(buff []byte, name string, mode ...string) (err error
 	var sz = len(buff)
 	if sz > 0 {
 		bf = &buff[0]
+		// Go callbacks such as a custom allocator may run while C reads the buffer
+		var pinner runtime.Pinner
+		pinner.Pin(bf)
+		defer pinner.Unpin()
+	}
+	if s.rt.ffi.version < 503 {
+		if err = s.checkMode51("luaL_loadbufferx", mode); err != nil {
+			return
+		}
+		err = s.CheckError(s.rt.ffi.LuaLLoadbuffer(s.luaL, bf, sz, b))
+		return
 	}
-	err = s.CheckError(luaLib.ffi.LuaLLoadbufferx(s.luaL, bf, sz, b, m))
+	err = s.CheckError(s.rt.ffi.LuaLLoadbufferx(s.luaL, bf, sz, b, m))
 	return
 }
 
@@ -194,7 +261,11 @@ func (s *State) DoString(scode string) (err error) {
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_loadstring
 func (s *State) LoadString(scode string) (err error) {
 	n, _ := bytePtrFromString(scode)
-	err = s.CheckError(luaLib.ffi.LuaLLoadstring(s.luaL, n))
+	// Go callbacks such as a custom allocator may run while C reads the code
+	var pinner runtime.Pinner
+	pinner.Pin(n)
+	defer pinner.Unpin()
+	err = s.CheckError(s.rt.ffi.LuaLLoadstring(s.luaL, n))
 	return
 }
 
@@ -216,7 +287,14 @@ func (s *State) LoadFilex(filename string, mode ...string) (err error) {
 	if len(mode) > 0 {
 		m, _ = bytePtrFromString(mode[0])
 	}
-	err = s.CheckError(luaLib.ffi.LuaLLoadfilex(s.luaL, fname, m))
+	if s.rt.ffi.version < 503 {
+		if err = s.checkMode51("luaL_loadfilex", mode); err != nil {
+			return
+		}
+		err = s.CheckError(s.rt.ffi.LuaLLoadfile(s.luaL, fname))
+		return
+	}
+	err = s.CheckError(s.rt.ffi.LuaLLoadfilex(s.luaL, fname, m))
 	return
 }
 
@@ -238,6 +316,11 @@ func (s *State) PCall(nargs, nresults, errfunc int) (err error) {
 func (s *State) PCallK(nargs, nresults, errfunc int, ctx unsafe.Pointer, k LuaKFunction) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
+			if e, ok := r.(*UnsupportedError); ok {
+				err = e
+				return
+			}
+			s.tainted = true
 			err = &Error{
 				status:  LUA_ERRRUN,
 				message: fmt.Sprintf("%v", r),
@@ -259,22 +342,36 @@ func (s *State) Call(nargs, nresults int) {
 // process, and any memory allocated for these callbacks is never released.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_callk
 func (s *State) CallK(nargs, nresults int, ctx unsafe.Pointer, k LuaKFunction) {
+	if s.rt.ffi.version < 503 {
+		// Lua 5.1 has no continuations
+		if k != nil {
+			panic(s.rt.ffi.unsupported("lua_callk"))
+		}
+		s.rt.ffi.LuaCall(s.luaL, nargs, nresults)
+		return
+	}
 	var kb uintptr
 	if k != nil {
 		kb = purego.NewCallback(k)
 	}
-	luaLib.ffi.LuaCallk(s.luaL, nargs, nresults, ctx, kb)
+	s.rt.ffi.LuaCallk(s.luaL, nargs, nresults, ctx, kb)
 }
 
 type WarnFunc func(L *State, msg string, tocont int)
 
 // SetWarnf sets a Go warning callback for this Lua state, called on warnings/errors from the Lua VM.
+// The callback receives the State of the main thread, ud is handed to lua_setwarnf as is.
 // Due to the limitation of Purego, only a limited number of callbacks may be created in a single Go
 // process, and any memory allocated for these callbacks is never released.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_setwarnf
 func (s *State) SetWarnf(fn WarnFunc, ud unsafe.Pointer) {
-	luaLib.ffi.LuaSetwarnf(s.luaL, purego.NewCallback(func(ud unsafe.Pointer, msg *byte, tocont int) {
-		fn(BuildState(ud), bytePtrToString(msg), tocont)
+	if s.rt.ffi.LuaSetwarnf == nil {
+		panic(s.rt.ffi.unsupported("lua_setwarnf"))
+	}
+	// The warning function does not receive the thread, only ud which belongs to the caller.
+	main := s.MainThread()
+	s.rt.ffi.LuaSetwarnf(s.luaL, purego.NewCallback(func(_ unsafe.Pointer, msg *byte, tocont int) {
+		fn(main, bytePtrToString(msg), tocont)
 	}), ud)
 }
 
@@ -282,22 +379,26 @@ func (s *State) SetWarnf(fn WarnFunc, ud unsafe.Pointer) {
 // Due to the limitation of Purego, only a limited number of callbacks may be created in a single Go
 // process, and any memory allocated for these callbacks is never released.
 func (s *State) Requiref(modname string, openf uintptr, global bool) {
+	if s.rt.ffi.version < 503 {
+		s.requiref51(modname, openf, global)
+		return
+	}
 	mname, _ := bytePtrFromString(modname)
 	var glb int
 	if global {
 		glb = 1
 	}
-	luaLib.ffi.LuaLRequiref(s.luaL, mname, openf, glb)
+	s.rt.ffi.LuaLRequiref(s.luaL, mname, openf, glb)
 }
 
 // Ref creates a reference to the value at the given stack index, returning a unique reference ID.
 func (s *State) Ref(idx int) int {
-	return luaLib.ffi.LuaLRef(s.luaL, idx)
+	return s.rt.ffi.LuaLRef(s.luaL, s.index(idx))
 }
 
 // Unref removes a reference created by Ref, the entry is removed from the table.
 func (s *State) Unref(idx int, ref int) {
-	luaLib.ffi.LuaLUnref(s.luaL, idx, ref)
+	s.rt.ffi.LuaLUnref(s.luaL, s.index(idx), ref)
 }
 
 type Reg struct {
@@ -309,9 +410,18 @@ type Reg struct {
 // A null Reg will be added as a sentinel to mark the end of the list inside the method
 // so callers do not need to add it manually.
 func (s *State) SetFuncs(l []*Reg, nup int) {
+	if s.rt.ffi.version < 503 {
+		s.setFuncs51(l, nup)
+		return
+	}
+	// The array and the names it points to are Go memory read by C, pin them for the duration of the call.
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
 	var ll = make([]LuaLReg, 0, len(l)+1)
 	for _, reg := range l {
 		name, _ := bytePtrFromString(reg.Name)
+		pinner.Pin(name)
 		s.PushCFunction(reg.Func)
 		ll = append(ll, LuaLReg{
 			Name: name,
@@ -321,7 +431,8 @@ func (s *State) SetFuncs(l []*Reg, nup int) {
 		s.Pop(1)
 	}
 	ll = append(ll, LuaLReg{nil, nil}) // Add a sentinel entry with zero values
-	luaLib.ffi.LuaLSetfuncs(s.luaL, unsafe.Pointer(unsafe.SliceData(ll)), nup)
+	pinner.Pin(&ll[0])
+	s.rt.ffi.LuaLSetfuncs(s.luaL, unsafe.Pointer(unsafe.SliceData(ll)), nup)
 }
 
 // NewLibTable creates a new Lua table on the stack and sets it as the current library table.
@@ -1,8 +1,15 @@
 package lua_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"unsafe"
 
 	"github.com/smasher164/mem"
@@ -201,6 +208,140 @@ func (s *Suite) TestLoad(assert *require.Assertions, L *lua.State) {
 	assert.NoError(err)
 }
 
+func (s *Suite) TestLoadReaders(assert *require.Assertions, L *lua.State) {
+	script := "local t = {} for i = 1, 10 do t[i] = i end return #t"
+
+	readers := map[string]io.Reader{
+		"bytes":      bytes.NewReader([]byte(script)),
+		"buffer":     bytes.NewBufferString(script),
+		"one byte":   iotest.OneByteReader(strings.NewReader(script)),
+		"data error": iotest.DataErrReader(strings.NewReader(script)),
+		"half":       iotest.HalfReader(strings.NewReader(script)),
+	}
+	for name, r := range readers {
+		assert.NoError(L.Load(r, name), name)
+		assert.NoError(L.PCall(0, 1, 0), name)
+		assert.EqualValues(10, L.ToInteger(-1), name)
+		L.Pop(1)
+	}
+
+	assert.NoError(L.Load(bytes.NewBuffer(nil), "empty"))
+	L.Pop(1)
+}
+
+func (s *Suite) TestLoadReadError(assert *require.Assertions, L *lua.State) {
+	errBroken := errors.New("broken pipe")
+	r := io.MultiReader(strings.NewReader("return 4"), iotest.ErrReader(errBroken))
+
+	err := L.Load(r, "broken")
+	assert.ErrorIs(err, errBroken)
+	var luaErr *lua.Error
+	assert.True(errors.As(err, &luaErr))
+	assert.Equal(lua.LUA_ERRFILE, luaErr.Status())
+	assert.Equal(0, L.GetTop())
+
+	assert.ErrorIs(L.Load(stuckReader{}, "stuck"), io.ErrNoProgress)
+	assert.Equal(0, L.GetTop())
+}
+
+// countingReader hides the io.WriterTo of the underlying reader and counts the reads.
+type countingReader struct {
+	r     io.Reader
+	reads int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.reads++
+	return c.r.Read(p)
+}
+
+// stuckReader never returns data nor an error.
+type stuckReader struct{}
+
+func (stuckReader) Read(p []byte) (int, error) {
+	return 0, nil
+}
+
+func (s *Suite) TestLoadChunkSize(assert *require.Assertions, t *testing.T) {
+	L := lua.NewState(lua.WithLoadChunkSize(1 << 20))
+	t.Cleanup(L.Close)
+
+	var script strings.Builder
+	script.WriteString("local t = {")
+	for i := range 100000 {
+		fmt.Fprintf(&script, "%d,", i)
+	}
+	script.WriteString("} return #t")
+
+	counting := &countingReader{r: strings.NewReader(script.String())}
+	assert.NoError(L.Load(counting, "large"))
+	assert.NoError(L.PCall(0, 1, 0))
+	assert.EqualValues(100000, L.ToInteger(-1))
+	assert.LessOrEqual(counting.reads, 2)
+}
+
+// gcReader forces a garbage collection before handing out every few bytes of the chunk,
+// so that memory handed to C is collected or moved if it is not kept alive and pinned.
+type gcReader struct {
+	r io.Reader
+}
+
+func (g *gcReader) Read(p []byte) (int, error) {
+	runtime.GC()
+	return g.r.Read(p[:min(len(p), 7)])
+}
+
+func (s *Suite) TestLoadUnderGC(assert *require.Assertions, L *lua.State) {
+	var script strings.Builder
+	for i := range 200 {
+		fmt.Fprintf(&script, "local v%d = %d\n", i, i)
+	}
+	script.WriteString("return 42")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				runtime.GC()
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	answer := lua.NewCallback(func(L *lua.State) int {
+		L.PushInteger(42)
+		return 1
+	})
+
+	for range 20 {
+		assert.NoError(L.Load(&gcReader{strings.NewReader(script.String())}, "gc_chunk"))
+		assert.NoError(L.PCall(0, 1, 0))
+		assert.EqualValues(42, L.ToInteger(-1))
+		L.Pop(1)
+
+		assert.NoError(L.LoadBufferx([]byte(script.String()), "gc_buffer"))
+		assert.NoError(L.PCall(0, 1, 0))
+		assert.EqualValues(42, L.ToInteger(-1))
+		L.Pop(1)
+
+		L.NewTable()
+		L.SetFuncs([]*lua.Reg{{Name: "answer", Func: answer}}, 0)
+		L.GetField(-1, "answer")
+		L.Call(0, 1)
+		assert.EqualValues(42, L.ToInteger(-1))
+		L.Pop(2)
+	}
+}
+
 func (s *Suite) TestLoadBuffer(assert *require.Assertions, L *lua.State) {
 
 	code := []byte("return 'hello from buffer'")
@@ -657,3 +798,51 @@ assert(mylib.add(1, 2) == 3)
 assert(mylib.addwithupvalue(1, 2) == 13)  -- 1 + 2 + 10 (upvalue)
 	`))
 }
+
+func (s *Suite) TestWhereAndGsub(assert *require.Assertions, L *lua.State) {
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		L.Where(1)
+		return 1
+	}))
+	L.SetGlobal("where")
+
+	assert.NoError(L.DoString(`
+		local w = where()
+		here = w
+	`))
+	L.GetGlobal("here")
+	assert.Equal(`[string "..."]:2:`, L.ToString(-1))
+	L.Pop(1)
+
+	assert.Equal("a\x00c-a\x00c", L.Gsub("a\x00b-a\x00b", "b", "c"))
+	assert.Equal("a\x00c-a\x00c", L.ToStringMeta(-1))
+	L.Pop(2)
+	assert.Equal("hello", L.Gsub("hello", "x", "y"))
+	L.Pop(1)
+}
+
+func (s *Suite) TestSetWarnf(assert *require.Assertions, L *lua.State) {
+	if L.Version() < 504 {
+		return
+	}
+
+	var (
+		states   []*lua.State
+		messages []string
+	)
+	// The callback is set from a thread with nil user data, it receives the main thread.
+	T := L.NewThread()
+	T.SetWarnf(func(W *lua.State, msg string, tocont int) {
+		states = append(states, W)
+		messages = append(messages, msg)
+	}, nil)
+	L.Pop(1)
+
+	assert.NoError(L.DoString(`warn("@on") warn("x")`))
+	// Control messages are handled by the warning function, so the callback receives them too.
+	assert.Equal([]string{"@on", "x"}, messages)
+	assert.Len(states, 2)
+	for _, W := range states {
+		assert.Same(L.MainThread(), W)
+	}
+}
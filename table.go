@@ -4,78 +4,119 @@ package lua
 // Narr and nrec are hints for the array and hash part sizes.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_createtable
 func (s *State) CreateTable(narr, nrec int) {
-	luaLib.ffi.LuaCreatetable(s.luaL, narr, nrec)
+	s.rt.ffi.LuaCreatetable(s.luaL, narr, nrec)
 }
 
 // GetTable retrieves a value in table at idx using the key at the top of the stack, and pushes the result.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_gettable
 func (s *State) GetTable(idx int) int {
-	return luaLib.ffi.LuaGettable(s.luaL, idx)
+	typ := s.rt.ffi.LuaGettable(s.luaL, s.index(idx))
+	if s.rt.ffi.version < 503 {
+		// lua_gettable returns nothing in Lua 5.1
+		return s.Type(-1)
+	}
+	return typ
 }
 
 // SetTable sets a value in a table at idx using a key-value pair from the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_settable
 func (s *State) SetTable(idx int) {
-	luaLib.ffi.LuaSettable(s.luaL, idx)
+	s.rt.ffi.LuaSettable(s.luaL, s.index(idx))
 }
 
 // GetField pushes onto the stack the value of the field k from the table at idx.
 // Returns the type of the pushed value.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_getfield
 func (s *State) GetField(idx int, k string) (typ int) {
-	p, _ := bytePtrFromString(k)
-	typ = int(luaLib.ffi.LuaGetfield(s.luaL, idx, p))
+	p := s.rt.cString(k)
+	typ = int(s.rt.ffi.LuaGetfield(s.luaL, s.index(idx), p))
+	if s.rt.ffi.version < 503 {
+		// lua_getfield returns nothing in Lua 5.1
+		typ = s.Type(-1)
+	}
 	return
 }
 
 // SetField sets the field k of the table at idx using a value from the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_setfield
 func (s *State) SetField(idx int, k string) {
-	p, _ := bytePtrFromString(k)
-	luaLib.ffi.LuaSetfield(s.luaL, idx, p)
+	p := s.rt.cString(k)
+	s.rt.ffi.LuaSetfield(s.luaL, s.index(idx), p)
 }
 
 // GetI pushes onto the stack the value n from the table at idx (uses integer key n).
 // Returns the value's type.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_geti
 func (s *State) GetI(idx int, n int64) int {
-	return luaLib.ffi.LuaGeti(s.luaL, idx, n)
+	if s.rt.ffi.version < 503 {
+		idx = s.absIndex51(idx)
+		s.PushInteger(n)
+		return s.GetTable(idx)
+	}
+	return s.rt.ffi.LuaGeti(s.luaL, s.index(idx), n)
 }
 
 // SetI sets a value at index n in the table at idx, using the value on top of the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_seti
 func (s *State) SetI(idx int, n int64) {
-	luaLib.ffi.LuaSeti(s.luaL, idx, n)
+	if s.rt.ffi.version < 503 {
+		idx = s.absIndex51(idx)
+		s.PushInteger(n)
+		s.Insert(-2)
+		s.SetTable(idx)
+		return
+	}
+	s.rt.ffi.LuaSeti(s.luaL, s.index(idx), n)
 }
 
 // NewTable pushes a new empty table onto the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_newtable
 func (s *State) NewTable() {
-	luaLib.ffi.LuaCreatetable(s.luaL, 0, 0)
+	s.rt.ffi.LuaCreatetable(s.luaL, 0, 0)
+}
+
+// PushGlobalTable pushes the global environment onto the stack.
+// See: https://www.lua.org/manual/5.4/manual.html#lua_pushglobaltable
+func (s *State) PushGlobalTable() {
+	if s.rt.ffi.version < 503 {
+		s.PushValue(LUA_GLOBALSINDEX)
+		return
+	}
+	s.RawGetI(LUA_REGISTRYINDEX, LUA_RIDX_GLOBALS)
 }
 
 // RawGet does a raw (no metamethods) lookup in table at idx using key from stack top.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_rawget
 func (s *State) RawGet(idx int) int {
-	return int(luaLib.ffi.LuaRawget(s.luaL, idx))
+	typ := int(s.rt.ffi.LuaRawget(s.luaL, s.index(idx)))
+	if s.rt.ffi.version < 503 {
+		// lua_rawget returns nothing in Lua 5.1
+		return s.Type(-1)
+	}
+	return typ
 }
 
 // RawSet does a raw (no metamethods) table set, using a key/value from the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_rawset
 func (s *State) RawSet(idx int) {
-	luaLib.ffi.LuaRawset(s.luaL, idx)
+	s.rt.ffi.LuaRawset(s.luaL, s.index(idx))
 }
 
 // RawGetI retrieves the entry with key n from the table at idx, ignoring metamethods.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_rawgeti
 func (s *State) RawGetI(idx int, n int64) int {
-	return int(luaLib.ffi.LuaRawgeti(s.luaL, idx, n))
+	typ := int(s.rt.ffi.LuaRawgeti(s.luaL, s.index(idx), n))
+	if s.rt.ffi.version < 503 {
+		// lua_rawgeti returns nothing in Lua 5.1
+		return s.Type(-1)
+	}
+	return typ
 }
 
 // RawSetI sets the value with key n in the table at idx, ignoring metamethods.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_rawseti
 func (s *State) RawSetI(idx int, n int64) {
-	luaLib.ffi.LuaRawseti(s.luaL, idx, n)
+	s.rt.ffi.LuaRawseti(s.luaL, s.index(idx), n)
 }
 
 // RawGetP retrieves a value from a table at idx using a light userdata as the key.
@@ -83,7 +124,12 @@ func (s *State) RawSetI(idx int, n int64) {
 // See: https://www.lua.org/manual/5.4/manual.html#lua_rawgetp
 func (s *State) RawGetP(idx int, ud any) (typ int) {
 	p := toLightUserData(ud)
-	typ = int(luaLib.ffi.LuaRawgetp(s.luaL, idx, p))
+	if s.rt.ffi.version < 503 {
+		idx = s.absIndex51(idx)
+		s.rt.ffi.LuaPushlightuserdata(s.luaL, p)
+		return s.RawGet(idx)
+	}
+	typ = int(s.rt.ffi.LuaRawgetp(s.luaL, s.index(idx), p))
 	return
 }
 
@@ -92,42 +138,54 @@ func (s *State) RawGetP(idx int, ud any) (typ int) {
 // See: https://www.lua.org/manual/5.4/manual.html#lua_rawsetp
 func (s *State) RawSetP(idx int, ud any) {
 	p := toLightUserData(ud)
-	luaLib.ffi.LuaRawsetp(s.luaL, idx, p)
+	if s.rt.ffi.version < 503 {
+		idx = s.absIndex51(idx)
+		s.rt.ffi.LuaPushlightuserdata(s.luaL, p)
+		s.Insert(-2)
+		s.RawSet(idx)
+		return
+	}
+	s.rt.ffi.LuaRawsetp(s.luaL, s.index(idx), p)
 }
 
 // Next pops a key from the stack, and pushes the next key-value pair from table at idx.
 // Returns false if no more elements.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_next
 func (s *State) Next(idx int) bool {
-	return luaLib.ffi.LuaNext(s.luaL, idx) != 0
+	return s.rt.ffi.LuaNext(s.luaL, s.index(idx)) != 0
 }
 
 // GeIMetaTable retrieves the metatable of the value at the given index and pushes it onto the stack.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_getmetatable
 func (s *State) GeIMetaTable(index int) int {
-	return luaLib.ffi.LuaGetmetatable(s.luaL, index)
+	return s.rt.ffi.LuaGetmetatable(s.luaL, s.index(index))
 }
 
 // SetIMetaTable sets the metatable for the value at the given index.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_setmetatable
 func (s *State) SetIMetaTable(index int) int {
-	return luaLib.ffi.LuaSetmetatable(s.luaL, index)
+	return s.rt.ffi.LuaSetmetatable(s.luaL, s.index(index))
 }
 
 // NewMetaTable creates a new metatable with the given name and pushes it onto the stack.
 // Returns true if the metatable already existed.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_newmetatable
 func (s *State) NewMetaTable(tname string) (has bool) {
-	p, _ := bytePtrFromString(tname)
-	has = luaLib.ffi.LuaLNewmetatable(s.luaL, p) == 0
+	p := s.rt.cString(tname)
+	has = s.rt.ffi.LuaLNewmetatable(s.luaL, p) == 0
 	return
 }
 
 // SetMetaTable sets the metatable of the value at the top of the stack to the named metatable.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_setmetatable
 func (s *State) SetMetaTable(tname string) {
-	p, _ := bytePtrFromString(tname)
-	luaLib.ffi.LuaLSetmetatable(s.luaL, p)
+	if s.rt.ffi.version < 503 {
+		s.GetMetaTable(tname)
+		s.SetIMetaTable(-2)
+		return
+	}
+	p := s.rt.cString(tname)
+	s.rt.ffi.LuaLSetmetatable(s.luaL, p)
 }
 
 // GetMetaTable retrieves the metatable associated with the given name from the registry.
@@ -140,16 +198,35 @@ func (s *State) GetMetaTable(tname string) (typ int) {
 // Returns the type of the metafield.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_getmetafield
 func (s *State) GetMetaField(obj int, e string) (typ int) {
-	p, _ := bytePtrFromString(e)
-	typ = int(luaLib.ffi.LuaLGetmetafield(s.luaL, obj, p))
+	p := s.rt.cString(e)
+	typ = int(s.rt.ffi.LuaLGetmetafield(s.luaL, s.index(obj), p))
+	if s.rt.ffi.version < 503 {
+		// luaL_getmetafield returns a boolean in Lua 5.1
+		if typ == 0 {
+			return LUA_TNIL
+		}
+		typ = s.Type(-1)
+	}
 	return
 }
 
+// GetSubTable ensures that the value t[fname], where t is the value at index idx, is a table,
+// and pushes that table onto the stack. Returns true if it finds a previous table there
+// and false if it creates a new table.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_getsubtable
+func (s *State) GetSubTable(idx int, fname string) bool {
+	if s.rt.ffi.version < 503 {
+		return s.getSubTable51(idx, fname)
+	}
+	p := s.rt.cString(fname)
+	return s.rt.ffi.LuaLGetsubtable(s.luaL, s.index(idx), p) != 0
+}
+
 // CallMeta calls the named metamethod on the given object.
 // Returns true if the metamethod exists and was called.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_callmeta
 func (s *State) CallMeta(obj int, e string) (has bool) {
-	p, _ := bytePtrFromString(e)
-	has = luaLib.ffi.LuaLCallmeta(s.luaL, obj, p) == 1
+	p := s.rt.cString(e)
+	has = s.rt.ffi.LuaLCallmeta(s.luaL, s.index(obj), p) == 1
 	return
 }
@@ -503,3 +503,18 @@ func (s *Suite) TestTableMeta(assert *require.Assertions, L *lua.State) {
 	assert.Equal(lua.LUA_TSTRING, L.Type(-1))
 	assert.Equal("mt called", L.ToString(-1))
 }
+
+func (s *Suite) TestGetSubTable(assert *require.Assertions, L *lua.State) {
+	L.NewTable()
+	assert.False(L.GetSubTable(-1, "sub"))
+	assert.Equal(lua.LUA_TTABLE, L.Type(-1))
+	L.PushInteger(1)
+	L.SetField(-2, "x")
+	L.Pop(1)
+
+	assert.True(L.GetSubTable(-1, "sub"))
+	L.GetField(-1, "x")
+	assert.Equal(int64(1), L.ToInteger(-1))
+	L.Pop(3)
+	assert.Equal(0, L.GetTop())
+}
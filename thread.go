@@ -7,21 +7,32 @@ import (
 )
 
 // NewThread creates a new Lua thread (coroutine), pushes it onto the stack, and returns its State.
+// Without options, the returned State is the canonical one also passed to the callbacks running in the thread.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_newthread
 func (s *State) NewThread(o ...stateOptFunc) *State {
-	return BuildState(luaLib.ffi.LuaNewthread(s.luaL), o...)
+	L := s.rt.ffi.LuaNewthread(s.luaL)
+	if len(o) > 0 {
+		return s.rt.BuildState(L, o...)
+	}
+	return s.rt.state(L, s.canonical().group)
 }
 
 // CloseThread closes the specified Lua thread (or the currently running thread if from is nil).
 // Returns an error if closing fails.
-// Available since Lua 5.4.
+// Available since Lua 5.4, lua_resetthread is used before Lua 5.4.6, which ignores from.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_closethread
 func (s *State) CloseThread(from *State) (err error) {
+	if s.rt.ffi.LuaClosethread == nil {
+		if s.rt.ffi.LuaResetthread != nil {
+			return s.CheckError(s.rt.ffi.LuaResetthread(s.luaL))
+		}
+		return s.rt.ffi.unsupported("lua_closethread")
+	}
 	var fromL unsafe.Pointer
 	if from != nil {
 		fromL = from.luaL
 	}
-	err = s.CheckError(luaLib.ffi.LuaClosethread(s.luaL, fromL))
+	err = s.CheckError(s.rt.ffi.LuaClosethread(s.luaL, fromL))
 	return
 }
 
@@ -30,14 +41,21 @@ func (s *State) CloseThread(from *State) (err error) {
 // Deprecated: use CloseThread(nil) instead.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_resetthread
 func (s *State) ResetThread() (err error) {
-	err = s.CheckError(luaLib.ffi.LuaResetthread(s.luaL))
+	if s.rt.ffi.version >= 505 {
+		// lua_resetthread has been removed in favour of lua_closethread since Lua 5.5
+		return s.CloseThread(nil)
+	}
+	if s.rt.ffi.LuaResetthread == nil {
+		return s.rt.ffi.unsupported("lua_resetthread")
+	}
+	err = s.CheckError(s.rt.ffi.LuaResetthread(s.luaL))
 	return
 }
 
 // PushThread pushes the current thread onto the Lua stack. Returns true if it's the main thread.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_pushthread
 func (s *State) PushThread() (isMain bool) {
-	isMain = luaLib.ffi.LuaPushthread(s.luaL) == 1
+	isMain = s.rt.ffi.LuaPushthread(s.luaL) == 1
 	return
 }
 
@@ -57,17 +75,29 @@ func (s *State) YieldK(nresults int, ctx unsafe.Pointer, k KFunc) (err error) {
 		}
 	}()
 
+	if s.rt.ffi.version < 503 {
+		// Lua 5.1 has no continuations
+		if k != nil {
+			return s.rt.ffi.unsupported("lua_yieldk")
+		}
+		s.rt.ffi.LuaYield(s.luaL, nresults)
+		return
+	}
+	if s.rt.ffi.LuaYieldk == nil {
+		return s.rt.ffi.unsupported("lua_yieldk")
+	}
+
 	var kb uintptr
 	if k != nil {
 		kb = purego.NewCallback(func(L unsafe.Pointer, status int, ctx unsafe.Pointer) int {
 			// Use panic instead of setjmp/longjmp to avoid issues with syscall frames
 			defer panic(protectionMsg)
 
-			return k(BuildState(L), status, ctx)
+			return k(s.rt.state(L, nil), status, ctx)
 		})
 	}
 
-	status := luaLib.ffi.LuaYieldk(s.luaL, nresults, ctx, kb)
+	status := s.rt.ffi.LuaYieldk(s.luaL, nresults, ctx, kb)
 	if status != LUA_OK && status != LUA_YIELD {
 		err = s.CheckError(status)
 	}
@@ -75,6 +105,8 @@ func (s *State) YieldK(nresults int, ctx unsafe.Pointer, k KFunc) (err error) {
 }
 
 // Yield yields nresults values from the current coroutine (no continuation function).
+// With Lua 5.1 and LuaJIT lua_yield returns instead of unwinding the stack,
+// so the Go function must return -1 right after calling Yield.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_yield
 func (s *State) Yield(nresults int) (err error) {
 	return s.YieldK(nresults, nil, nil)
@@ -90,10 +122,14 @@ func (s *State) Resume(from *State, narg int) (nres int32, yield bool, err error
 		fromL = from.luaL
 	}
 	var status int
-	if luaLib.ffi.version >= 504 {
-		status = luaLib.ffi.LuaResume(s.luaL, fromL, narg, unsafe.Pointer(&nres))
-	} else {
-		status = luaLib.ffi.LuaResume503(s.luaL, fromL, narg)
+	switch {
+	case s.rt.ffi.version >= 504:
+		status = s.rt.ffi.LuaResume(s.luaL, fromL, narg, unsafe.Pointer(&nres))
+	case s.rt.ffi.version >= 503:
+		status = s.rt.ffi.LuaResume503(s.luaL, fromL, narg)
+	default:
+		// lua_resume of Lua 5.1 has no from parameter
+		status = s.rt.ffi.LuaResume501(s.luaL, narg)
 	}
 	yield = status == LUA_YIELD
 	if status != LUA_OK && status != LUA_YIELD {
@@ -105,22 +141,30 @@ func (s *State) Resume(from *State, narg int) (nres int32, yield bool, err error
 // Status returns the status code of the thread (running, yielded, etc).
 // See: https://www.lua.org/manual/5.4/manual.html#lua_status
 func (s *State) Status() int {
-	return luaLib.ffi.LuaStatus(s.luaL)
+	return s.rt.ffi.LuaStatus(s.luaL)
 }
 
 // IsYieldable reports whether the current Lua thread is yieldable.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_isyieldable
 func (s *State) IsYieldable() bool {
-	return luaLib.ffi.LuaIsyieldable(s.luaL) == 1
+	if s.rt.ffi.LuaIsyieldable == nil {
+		panic(s.rt.ffi.unsupported("lua_isyieldable"))
+	}
+	return s.rt.ffi.LuaIsyieldable(s.luaL) == 1
 }
 
 // ToThread returns the Lua thread at the given stack index as a State.
+// Without options, the returned State is the canonical one also passed to the callbacks running in the thread.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_tothread
 func (s *State) ToThread(idx int, o ...stateOptFunc) *State {
-	return BuildState(luaLib.ffi.LuaTothread(s.luaL, idx), o...)
+	L := s.rt.ffi.LuaTothread(s.luaL, s.index(idx))
+	if len(o) > 0 || L == nil {
+		return s.rt.BuildState(L, o...)
+	}
+	return s.rt.state(L, s.canonical().group)
 }
 
 // ToPointer returns the Lua value at the given stack index as an unsafe.Pointer.
 func (s *State) ToPointer(idx int) unsafe.Pointer {
-	return luaLib.ffi.LuaTopointer(s.luaL, idx)
+	return s.rt.ffi.LuaTopointer(s.luaL, s.index(idx))
 }
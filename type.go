@@ -1,49 +1,53 @@
 package lua
 
 import (
+	"fmt"
 	"unsafe"
 )
 
 // IsNumber returns true if the value at idx is a number or can be converted to a number.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_isnumber
 func (s *State) IsNumber(idx int) bool {
-	return luaLib.ffi.LuaIsnumber(s.luaL, idx) != 0
+	return s.rt.ffi.LuaIsnumber(s.luaL, s.index(idx)) != 0
 }
 
 // IsString returns true if the value at idx is a string or can be converted to a string.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_isstring
 func (s *State) IsString(idx int) bool {
-	return luaLib.ffi.LuaIsstring(s.luaL, idx) != 0
+	return s.rt.ffi.LuaIsstring(s.luaL, s.index(idx)) != 0
 }
 
 // IsGoFunction returns true if the value at idx is a C function.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_iscfunction
 func (s *State) IsGoFunction(idx int) bool {
-	return luaLib.ffi.LuaIscfunction(s.luaL, idx) != 0
+	return s.rt.ffi.LuaIscfunction(s.luaL, s.index(idx)) != 0
 }
 
 // IsInteger returns true if the value at idx is an integer.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_isinteger
 func (s *State) IsInteger(idx int) bool {
-	return luaLib.ffi.LuaIsinteger(s.luaL, idx) != 0
+	if s.rt.ffi.version < 503 {
+		return s.isInteger51(idx)
+	}
+	return s.rt.ffi.LuaIsinteger(s.luaL, s.index(idx)) != 0
 }
 
 // IsUserData returns true if the value at idx is a userdata or full userdata.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_isuserdata
 func (s *State) IsUserData(idx int) bool {
-	return luaLib.ffi.LuaIsuserdata(s.luaL, idx) != 0
+	return s.rt.ffi.LuaIsuserdata(s.luaL, s.index(idx)) != 0
 }
 
 // Type returns the type code of the value at idx.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_type
 func (s *State) Type(idx int) int {
-	return luaLib.ffi.LuaType(s.luaL, idx)
+	return s.rt.ffi.LuaType(s.luaL, s.index(idx))
 }
 
 // TypeName returns the name of the given type code.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_typename
 func (s *State) TypeName(tp int) string {
-	p := luaLib.ffi.LuaTypename(s.luaL, tp)
+	p := s.rt.ffi.LuaTypename(s.luaL, tp)
 	if p == nil {
 		return ""
 	}
@@ -89,28 +93,34 @@ func (s *State) IsLightUserData(idx int) bool {
 // If isnum is true, sets a flag if conversion succeeds.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_tonumberx
 func (s *State) ToNumberx(idx int, isnum bool) float64 {
+	if s.rt.ffi.version < 503 {
+		return s.rt.ffi.LuaTonumber(s.luaL, s.index(idx))
+	}
 	var isNumber int
 	if isnum {
 		isNumber = 1
 	}
-	return luaLib.ffi.LuaTonumberx(s.luaL, idx, unsafe.Pointer(&isNumber))
+	return s.rt.ffi.LuaTonumberx(s.luaL, s.index(idx), unsafe.Pointer(&isNumber))
 }
 
 // ToIntegerx converts the value at idx to an integer (int64).
 // If isnum is true, sets a flag if conversion succeeds.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_tointegerx
 func (s *State) ToIntegerx(idx int, isnum bool) int64 {
+	if s.rt.ffi.version < 503 {
+		return s.rt.ffi.LuaTointeger(s.luaL, s.index(idx))
+	}
 	var isNumber int
 	if isnum {
 		isNumber = 1
 	}
-	return luaLib.ffi.LuaTointegerx(s.luaL, idx, unsafe.Pointer(&isNumber))
+	return s.rt.ffi.LuaTointegerx(s.luaL, s.index(idx), unsafe.Pointer(&isNumber))
 }
 
 // ToLString converts the value at idx to a string and optionally returns its length.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_tolstring
 func (s *State) ToLString(idx int, size *int) string {
-	p := luaLib.ffi.LuaTolstring(s.luaL, idx, unsafe.Pointer(size))
+	p := s.rt.ffi.LuaTolstring(s.luaL, s.index(idx), unsafe.Pointer(size))
 	if p == nil {
 		return ""
 	}
@@ -120,7 +130,7 @@ func (s *State) ToLString(idx int, size *int) string {
 // ToBoolean converts the Lua value at idx to a Go boolean.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_toboolean
 func (s *State) ToBoolean(idx int) bool {
-	return luaLib.ffi.LuaToboolean(s.luaL, idx) != 0
+	return s.rt.ffi.LuaToboolean(s.luaL, s.index(idx)) != 0
 }
 
 // ToNumber converts the value at idx to a Lua number (float64, without extra flag).
@@ -138,37 +148,64 @@ func (s *State) ToString(idx int) string {
 	return s.ToLString(idx, nil)
 }
 
+// ToStringMeta converts the value at idx to a string in a reasonable format, the way print and tostring do,
+// and pushes the resulting string onto the stack.
+// If the value has a metatable with a __tostring field, it is called with the value as argument,
+// and its result, which must be a string, is used.
+// Otherwise the __name field of the metatable, if it is a string, is used as the type of the value.
+// Unlike ToString, the result may contain embedded zeros.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_tolstring
+func (s *State) ToStringMeta(idx int) string {
+	if s.rt.ffi.version < 503 {
+		s.toStringMeta51(idx)
+		return s.toGoString(-1)
+	}
+	var size int
+	p := s.rt.ffi.LuaLTolstring(s.luaL, s.index(idx), unsafe.Pointer(&size))
+	return goStringN(p, size)
+}
+
+// toGoString returns a copy of the string at idx, including its embedded zeros.
+func (s *State) toGoString(idx int) string {
+	var size int
+	p := s.rt.ffi.LuaTolstring(s.luaL, s.index(idx), unsafe.Pointer(&size))
+	return goStringN(p, size)
+}
+
 // ToUserData returns the userdata pointer at idx, or nil if it's not userdata.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_touserdata
 func (s *State) ToUserData(idx int) unsafe.Pointer {
-	return luaLib.ffi.LuaTouserdata(s.luaL, idx)
+	return s.rt.ffi.LuaTouserdata(s.luaL, s.index(idx))
 }
 
 // ToCFunction returns the C function pointer at idx, or nil if not a C function.
 // There is no ToGoFunction because Go functions are not convertible once pushed onto the stack.
 // The returned pointer can be used with PushCFunctionPointer to push it back onto the stack.
 func (s *State) ToCFunction(idx int) unsafe.Pointer {
-	return luaLib.ffi.LuaTocfunction(s.luaL, idx)
+	return s.rt.ffi.LuaTocfunction(s.luaL, s.index(idx))
 }
 
 // RawLen returns the length of value at idx (arrays, strings, tables).
 // See: https://www.lua.org/manual/5.4/manual.html#lua_rawlen
 func (s *State) RawLen(idx int) uint {
-	return luaLib.ffi.LuaRawlen(s.luaL, idx)
+	if s.rt.ffi.version < 503 {
+		return s.rt.ffi.LuaObjlen(s.luaL, s.index(idx))
+	}
+	return s.rt.ffi.LuaRawlen(s.luaL, s.index(idx))
 }
 
 // CheckNumber checks whether the value at idx is a number and returns it.
 // Raises an error if it is not a number.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_checknumber
 func (s *State) CheckNumber(idx int) float64 {
-	return luaLib.ffi.LuaLChecknumber(s.luaL, idx)
+	return s.rt.ffi.LuaLChecknumber(s.luaL, s.index(idx))
 }
 
 // CheckInteger checks whether the value at idx is an integer and returns it.
 // Raises an error if it is not.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_checkinteger
 func (s *State) CheckInteger(idx int) int64 {
-	return luaLib.ffi.LuaLCheckinteger(s.luaL, idx)
+	return s.rt.ffi.LuaLCheckinteger(s.luaL, s.index(idx))
 }
 
 func (s *State) CheckString(idx int) string {
@@ -183,31 +220,116 @@ func (s *State) CheckLString(idx int, size *int) string {
 	if size != nil {
 		sz = unsafe.Pointer(size)
 	}
-	return bytePtrToString(luaLib.ffi.LuaLChecklstring(s.luaL, idx, sz))
+	return bytePtrToString(s.rt.ffi.LuaLChecklstring(s.luaL, s.index(idx), sz))
 }
 
 // CheckType checks whether the value at idx has the given type, raising error if not.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_checktype
 func (s *State) CheckType(idx int, tp int) {
-	luaLib.ffi.LuaLChecktype(s.luaL, idx, tp)
+	s.rt.ffi.LuaLChecktype(s.luaL, s.index(idx), tp)
 }
 
 // CheckAny checks that the value at idx is not none (must exist, any type), raises error if none.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_checkany
 func (s *State) CheckAny(idx int) {
-	luaLib.ffi.LuaLCheckany(s.luaL, idx)
+	s.rt.ffi.LuaLCheckany(s.luaL, s.index(idx))
 }
 
 // OptNumber fetches an optional number arg at idx, or uses def if not present or not number.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_optnumber
 func (s *State) OptNumber(idx int, def float64) float64 {
-	return luaLib.ffi.LuaLOptnumber(s.luaL, idx, def)
+	return s.rt.ffi.LuaLOptnumber(s.luaL, s.index(idx), def)
 }
 
 // OptInteger fetches an optional integer arg at idx, or uses def if not present or not integer.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_optinteger
 func (s *State) OptInteger(idx int, def int64) int64 {
-	return luaLib.ffi.LuaLOptinteger(s.luaL, idx, def)
+	return s.rt.ffi.LuaLOptinteger(s.luaL, s.index(idx), def)
+}
+
+// ArgError raises an error reporting a problem with argument arg of the C function that called it,
+// using a standard message that includes extramsg as a comment:
+// bad argument #arg to 'funcname' (extramsg)
+// This function never returns normally.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_argerror
+func (s *State) ArgError(arg int, extramsg string) int {
+	m, _ := bytePtrFromString(extramsg)
+	return s.rt.ffi.LuaLArgerror(s.luaL, arg, m)
+}
+
+// TypeError raises a type error for the argument arg of the C function that called it,
+// using a standard message: bad argument #arg to 'funcname' (tname expected, got rt),
+// where rt is the __name of the metatable of the argument, or its type name.
+// This function never returns normally.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_typeerror
+func (s *State) TypeError(arg int, tname string) int {
+	t, _ := bytePtrFromString(tname)
+	switch {
+	case s.rt.ffi.LuaLTypeerror != nil:
+		return s.rt.ffi.LuaLTypeerror(s.luaL, arg, t)
+	case s.rt.ffi.LuaLTyperror != nil:
+		// luaL_typerror of Lua 5.1 has the same message, without __name
+		return s.rt.ffi.LuaLTyperror(s.luaL, arg, t)
+	default:
+		// luaL_typeerror is internal to the auxiliary library in Lua 5.3
+		return s.typeError53(arg, tname)
+	}
+}
+
+// ArgCheck checks whether cond is true. If it is not, raises an error with a standard message, see ArgError.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_argcheck
+func (s *State) ArgCheck(cond bool, arg int, extramsg string) {
+	if !cond {
+		s.ArgError(arg, extramsg)
+	}
+}
+
+// ArgExpected checks whether cond is true. If it is not, raises an error about the type of the argument arg
+// with a standard message, see TypeError.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_argexpected
+func (s *State) ArgExpected(cond bool, arg int, tname string) {
+	if !cond {
+		s.TypeError(arg, tname)
+	}
+}
+
+// CheckOption checks whether the argument arg is a string and searches for it in lst.
+// Returns the index in lst where the string was found.
+// Raises an error if the argument is not a string or if the string cannot be found.
+// If def is not empty, it is used as the default value when there is no argument arg or it is nil.
+// The search is done on the Go side, so that the list is not handed over to C.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_checkoption
+func (s *State) CheckOption(arg int, def string, lst []string) int {
+	var name string
+	if def != "" {
+		name = s.OptLString(arg, def, nil)
+	} else {
+		name = s.CheckString(arg)
+	}
+	for i, opt := range lst {
+		if opt == name {
+			return i
+		}
+	}
+	return s.ArgError(arg, fmt.Sprintf("invalid option '%s'", name))
+}
+
+// CheckBoolean checks whether the argument arg is a boolean and returns it.
+// Raises a type error if it is not.
+func (s *State) CheckBoolean(arg int) bool {
+	if s.Type(arg) != LUA_TBOOLEAN {
+		s.TypeError(arg, "boolean")
+	}
+	return s.ToBoolean(arg)
+}
+
+// OptBoolean returns the boolean argument arg, or def if the argument is absent or nil.
+// Raises a type error if it is neither a boolean nor nil.
+func (s *State) OptBoolean(arg int, def bool) bool {
+	if s.IsNoneOrNil(arg) {
+		return def
+	}
+	return s.CheckBoolean(arg)
 }
 
 // OptLString fetches an optional string arg at idx, or uses def if not present or not string.
@@ -215,6 +337,6 @@ func (s *State) OptInteger(idx int, def int64) int64 {
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_optlstring
 func (s *State) OptLString(idx int, def string, size *int) string {
 	d, _ := bytePtrFromString(def)
-	p := luaLib.ffi.LuaLOptlstring(s.luaL, idx, d, unsafe.Pointer(size))
+	p := s.rt.ffi.LuaLOptlstring(s.luaL, s.index(idx), d, unsafe.Pointer(size))
 	return bytePtrToString(p)
 }
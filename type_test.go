@@ -2,6 +2,7 @@ package lua_test
 
 import (
 	"math"
+	"strings"
 	"unsafe"
 
 	"github.com/stretchr/testify/require"
@@ -550,3 +551,166 @@ func (s *Suite) TestOptLString(assert *require.Assertions, L *lua.State) {
 	assert.Equal(len(longStr), size)
 	L.Pop(1)
 }
+
+func (s *Suite) TestArgumentErrors(assert *require.Assertions, L *lua.State) {
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		L.ArgExpected(L.IsNumber(1), 1, "number")
+		L.ArgCheck(L.ToNumber(1) > 0, 1, "positive number expected")
+		L.PushBoolean(L.OptBoolean(2, true))
+		return 1
+	}))
+	L.SetGlobal("checkpositive")
+
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		L.PushInteger(int64(L.CheckOption(1, "", []string{"collect", "count", "step"})))
+		L.PushInteger(int64(L.CheckOption(2, "count", []string{"collect", "count", "step"})))
+		return 2
+	}))
+	L.SetGlobal("checkoption")
+
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		L.TypeError(1, "widget")
+		return 0
+	}))
+	L.SetGlobal("typeerror")
+
+	err := L.DoString(`
+		local function message(f, ...)
+			local ok, e = pcall(f, ...)
+			assert(not ok)
+			return e
+		end
+		r = {}
+		r.noValue = message(function() local v = checkpositive() return v end)
+		r.string = message(function() local v = checkpositive("x") return v end)
+		r.negative = message(function() local v = checkpositive(-1) return v end)
+		r.boolean = message(function() local v = checkpositive(1, 2) return v end)
+		r.option = message(function() local v = checkoption("bogus") return v end)
+		r.missing = message(function() local v = checkoption() return v end)
+		r.typeerror = message(function() local v = typeerror(setmetatable({}, {__name = "gadget"})) return v end)
+		r.default, r.explicit = select(2, checkoption("step")), select(2, checkoption("step", "collect"))
+		r.first = checkoption("collect")
+		r.flag = checkpositive(1)
+		r.flagFalse = checkpositive(1, false)
+	`)
+	assert.NoError(err)
+
+	field := func(name string) string {
+		L.GetGlobal("r")
+		defer L.Pop(1)
+		L.GetField(-1, name)
+		defer L.Pop(1)
+		return L.ToString(-1)
+	}
+	assert.Contains(field("noValue"), "bad argument #1 to")
+	assert.Contains(field("noValue"), "(number expected, got no value)")
+	assert.Contains(field("string"), "(number expected, got string)")
+	assert.Contains(field("negative"), "(positive number expected)")
+	assert.Contains(field("boolean"), "bad argument #2 to")
+	assert.Contains(field("boolean"), "(boolean expected, got number)")
+	assert.Contains(field("option"), "(invalid option 'bogus')")
+	assert.Contains(field("missing"), "(string expected, got no value)")
+	assert.Equal("1", field("default"))
+	assert.Equal("0", field("explicit"))
+	assert.Equal("0", field("first"))
+
+	if L.Version() < 503 {
+		// luaL_typerror does not know about __name
+		assert.Contains(field("typeerror"), "(widget expected, got table)")
+	} else {
+		assert.Contains(field("typeerror"), "(widget expected, got gadget)")
+	}
+
+	L.GetGlobal("r")
+	L.GetField(-1, "flag")
+	assert.True(L.ToBoolean(-1))
+	L.GetField(-2, "flagFalse")
+	assert.False(L.ToBoolean(-1))
+	L.Pop(3)
+}
+
+func (s *Suite) TestArgumentErrorsLikeLua(assert *require.Assertions, L *lua.State) {
+	// rep checks its arguments like string.rep does with luaL_checklstring and luaL_checkinteger.
+	L.PushCFunction(lua.NewCallback(func(L *lua.State) int {
+		L.ArgExpected(L.Type(1) == lua.LUA_TSTRING || L.Type(1) == lua.LUA_TNUMBER, 1, "string")
+		if !L.IsNumber(2) {
+			L.TypeError(2, "number")
+		}
+		if L.Version() >= 503 && !L.IsInteger(2) {
+			L.ArgError(2, "number has no integer representation")
+		}
+		return 0
+	}))
+	L.SetGlobal("rep")
+
+	assert.NoError(L.DoString(`
+		local unpack = table.unpack or unpack
+		-- The messages are compared after the function name, which differs.
+		local function message(f, ...)
+			local ok, e = pcall(f, ...)
+			assert(not ok)
+			return (e:gsub("^bad argument #(%d+) to '[^']*'", "bad argument #%1"))
+		end
+		cases = {
+			{},
+			{ {} },
+			{ setmetatable({}, { __name = "gadget" }) },
+			{ "x" },
+			{ "x", "y" },
+			{ "x", {} },
+		}
+		if math.type then
+			cases[#cases + 1] = { "x", 1.5 }
+		end
+		for i, args in ipairs(cases) do
+			cases[i] = { message(string.rep, unpack(args)), message(rep, unpack(args)) }
+		end
+	`))
+
+	L.GetGlobal("cases")
+	n := int(L.RawLen(-1))
+	assert.GreaterOrEqual(n, 6)
+	for i := 1; i <= n; i++ {
+		L.RawGetI(-1, int64(i))
+		L.RawGetI(-1, 1)
+		L.RawGetI(-2, 2)
+		want, got := L.ToString(-2), L.ToString(-1)
+		assert.True(strings.HasPrefix(want, "bad argument #"), want)
+		assert.Equal(want, got, "case %d", i)
+		L.Pop(3)
+	}
+	L.Pop(1)
+}
+
+func (s *Suite) TestToStringMeta(assert *require.Assertions, L *lua.State) {
+	L.PushInteger(42)
+	assert.Equal("42", L.ToStringMeta(-1))
+	assert.Equal(lua.LUA_TSTRING, L.Type(-1))
+	L.Pop(2)
+
+	L.PushBoolean(false)
+	assert.Equal("false", L.ToStringMeta(-1))
+	L.Pop(2)
+
+	L.PushNil()
+	assert.Equal("nil", L.ToStringMeta(-1))
+	L.Pop(2)
+
+	L.PushString("a\x00b")
+	assert.Equal("a\x00b", L.ToStringMeta(-1))
+	L.Pop(2)
+
+	assert.NoError(L.DoString(`
+		named = setmetatable({}, {__name = "Point"})
+		printable = setmetatable({}, {__tostring = function() return "point\0(1, 2)" end})
+	`))
+
+	L.GetGlobal("named")
+	assert.Regexp(`^Point: 0x[0-9a-f]+$`, L.ToStringMeta(-1))
+	L.Pop(2)
+
+	L.GetGlobal("printable")
+	assert.Equal("point\x00(1, 2)", L.ToStringMeta(-1))
+	assert.Equal(2, L.GetTop())
+	L.Pop(2)
+}
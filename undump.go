@@ -0,0 +1,254 @@
+package lua
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// errChunkFormat is returned when a binary chunk cannot be decoded.
+var errChunkFormat = errors.New("lua: unsupported binary chunk")
+
+// chunkFunction is a function prototype decoded from a binary chunk, with the lines of its instructions.
+type chunkFunction struct {
+	lineDefined, lastLineDefined int
+	lines                        []int
+}
+
+// chunkReader decodes the binary chunks dumped by Lua 5.1, 5.3 and 5.4, see ldump.c.
+type chunkReader struct {
+	data    []byte
+	version byte
+	order   binary.ByteOrder
+	// The sizes of the C types declared by the header.
+	intSize, sizeTSize, instructionSize, integerSize, numberSize int
+}
+
+// chunkFunctions returns the prototypes of the binary chunk, the main function first,
+// followed by its nested functions in the order of the chunk.
+func chunkFunctions(chunk []byte) (functions []chunkFunction, err error) {
+	defer func() {
+		// The reads panic past the end of a truncated chunk.
+		if r := recover(); r != nil {
+			functions, err = nil, fmt.Errorf("%w: %v", errChunkFormat, r)
+		}
+	}()
+
+	r := &chunkReader{data: chunk}
+	if err = r.header(); err != nil {
+		return
+	}
+	r.function(&functions)
+	return
+}
+
+func (r *chunkReader) bytes(n int) []byte {
+	if n < 0 || n > len(r.data) {
+		panic("truncated chunk")
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *chunkReader) byte() int {
+	return int(r.bytes(1)[0])
+}
+
+// uint reads an unsigned integer of n bytes in the byte order of the chunk.
+func (r *chunkReader) uint(n int) (x uint64) {
+	b := r.bytes(n)
+	for i := range b {
+		if r.order == binary.BigEndian {
+			x = x<<8 | uint64(b[i])
+		} else {
+			x = x<<8 | uint64(b[len(b)-1-i])
+		}
+	}
+	return
+}
+
+// int reads a C int, which Lua 5.4 dumps as a variable length unsigned integer.
+func (r *chunkReader) int() int {
+	if r.version >= 0x54 {
+		var x int
+		for {
+			b := r.byte()
+			x = x<<7 | b&0x7f
+			if b&0x80 != 0 {
+				return x
+			}
+		}
+	}
+	return int(int32(r.uint(r.intSize)))
+}
+
+// size reads the size of a string or of an array.
+func (r *chunkReader) size() int {
+	switch r.version {
+	case 0x51:
+		return int(r.uint(r.sizeTSize))
+	case 0x53:
+		n := r.byte()
+		if n == 0xff {
+			return int(r.uint(r.sizeTSize))
+		}
+		return n
+	}
+	return r.int()
+}
+
+func (r *chunkReader) string() {
+	n := r.size()
+	if r.version >= 0x53 && n > 0 {
+		// The size includes the trailing zero, which is not dumped.
+		n--
+	}
+	r.bytes(n)
+}
+
+// header checks the header of the chunk and reads the sizes of its types.
+func (r *chunkReader) header() error {
+	if len(r.data) < 6 || string(r.bytes(4)) != luaSignature {
+		return errChunkFormat
+	}
+	r.version = byte(r.byte())
+	if format := r.byte(); format != 0 {
+		return errChunkFormat
+	}
+
+	switch r.version {
+	case 0x51:
+		if len(r.data) < 6 {
+			return errChunkFormat
+		}
+		r.order = binary.BigEndian
+		if r.byte() == 1 {
+			r.order = binary.LittleEndian
+		}
+		r.intSize, r.sizeTSize, r.instructionSize, r.numberSize = r.byte(), r.byte(), r.byte(), r.byte()
+		r.byte() // whether lua_Number is integral
+		return nil
+	case 0x53, 0x54:
+	default:
+		return errChunkFormat
+	}
+
+	if string(r.bytes(6)) != "\x19\x93\r\n\x1a\n" {
+		return errChunkFormat
+	}
+	if r.version == 0x53 {
+		r.intSize, r.sizeTSize = r.byte(), r.byte()
+	}
+	r.instructionSize, r.integerSize, r.numberSize = r.byte(), r.byte(), r.byte()
+
+	// The integer 0x5678 tells the byte order, the number 370.5 its format.
+	r.order = binary.LittleEndian
+	if r.data[0] == 0 {
+		r.order = binary.BigEndian
+	}
+	if r.uint(r.integerSize) != 0x5678 || r.numberSize != 8 || math.Float64frombits(r.uint(8)) != 370.5 {
+		return errChunkFormat
+	}
+	r.byte() // the number of upvalues of the main function
+	return nil
+}
+
+// function reads a function prototype and its nested ones.
+func (r *chunkReader) function(functions *[]chunkFunction) {
+	r.string() // source
+	fn := chunkFunction{lineDefined: r.int(), lastLineDefined: r.int()}
+	if r.version == 0x51 {
+		r.byte() // number of upvalues
+	}
+	r.byte() // number of parameters
+	vararg := r.byte() != 0
+	r.byte() // maximum stack size
+	r.bytes(r.int() * r.instructionSize)
+
+	i := len(*functions)
+	*functions = append(*functions, fn)
+
+	for range r.int() {
+		r.constant()
+	}
+	if r.version >= 0x53 {
+		upvalueSize := 2
+		if r.version >= 0x54 {
+			upvalueSize = 3
+		}
+		r.bytes(r.int() * upvalueSize)
+	}
+	for range r.int() {
+		r.function(functions)
+	}
+
+	if r.version >= 0x54 {
+		(*functions)[i].lines = r.relativeLines(fn.lineDefined, vararg)
+	} else {
+		lines := make([]int, r.int())
+		for j := range lines {
+			lines[j] = r.int()
+		}
+		(*functions)[i].lines = lines
+	}
+
+	for range r.int() {
+		r.string() // local name
+		r.int()
+		r.int()
+	}
+	for range r.int() {
+		r.string() // upvalue name
+	}
+}
+
+// relativeLines reads the line information of Lua 5.4, where each instruction stores the line difference
+// from the previous one, or is marked as having its absolute line stored in a separate list.
+// Like lua_getinfo, the line of the instruction preparing the arguments of a vararg function is left out.
+func (r *chunkReader) relativeLines(lineDefined int, vararg bool) []int {
+	const absLineInfo = -0x80
+
+	deltas := r.bytes(r.int())
+	absolute := make([]int, r.int())
+	for j := range absolute {
+		r.int() // pc
+		absolute[j] = r.int()
+	}
+
+	lines := make([]int, 0, len(deltas))
+	line := lineDefined
+	for pc, b := range deltas {
+		if delta := int(int8(b)); delta == absLineInfo {
+			line, absolute = absolute[0], absolute[1:]
+		} else {
+			line += delta
+		}
+		if pc > 0 || !vararg {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// constant reads a constant of a function.
+func (r *chunkReader) constant() {
+	typ := r.byte()
+	switch {
+	case typ == LUA_TNIL:
+	case typ == LUA_TBOOLEAN && r.version < 0x54:
+		r.byte()
+	case typ&0x0f == LUA_TBOOLEAN:
+		// Lua 5.4 stores the value in the type.
+	case typ == LUA_TNUMBER && r.version < 0x54,
+		typ == LUA_TNUMBER|1<<4 && r.version >= 0x54:
+		r.bytes(r.numberSize)
+	case typ == LUA_TNUMBER|1<<4, typ == LUA_TNUMBER:
+		r.bytes(r.integerSize)
+	case typ&0x0f == LUA_TSTRING:
+		r.string()
+	default:
+		panic(fmt.Sprintf("unknown constant type %d", typ))
+	}
+}
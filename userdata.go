@@ -9,7 +9,7 @@ import (
 // See: https://www.lua.org/manual/5.4/manual.html#lua_newuserdatauv
 func (s *State) NewUserData(size int) unsafe.Pointer {
 	if s.Version() < 504 {
-		return luaLib.ffi.LuaNewuserdata(s.luaL, size)
+		return s.rt.ffi.LuaNewuserdata(s.luaL, size)
 	}
 	return s.NewUserDataUv(size, 1)
 }
@@ -18,7 +18,10 @@ func (s *State) NewUserData(size int) unsafe.Pointer {
 // Available since Lua 5.4.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_newuserdatauv
 func (s *State) NewUserDataUv(size, nuv int) unsafe.Pointer {
-	return luaLib.ffi.LuaNewuserdatauv(s.luaL, size, nuv)
+	if s.rt.ffi.LuaNewuserdatauv == nil {
+		panic(s.rt.ffi.unsupported("lua_newuserdatauv"))
+	}
+	return s.rt.ffi.LuaNewuserdatauv(s.luaL, size, nuv)
 }
 
 // GetIUserValue gets the nth user value associated with the userdata at idx (1-based).
@@ -26,47 +29,92 @@ func (s *State) NewUserDataUv(size, nuv int) unsafe.Pointer {
 // Available since Lua 5.4.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_getiuservalue
 func (s *State) GetIUserValue(idx, n int) int {
-	return int(luaLib.ffi.LuaGetiuservalue(s.luaL, idx, n))
+	if s.rt.ffi.LuaGetiuservalue == nil {
+		panic(s.rt.ffi.unsupported("lua_getiuservalue"))
+	}
+	return int(s.rt.ffi.LuaGetiuservalue(s.luaL, s.index(idx), n))
 }
 
-// SetIUserValue sets the nth user value of the userdata at idx with the value at the top of the stack.
+// SetIUserValue sets the nth user value of the userdata at idx with the value at the top of the stack,
+// and pops the value. Returns false if the userdata does not have that value.
 // Available since Lua 5.4.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_setiuservalue
-func (s *State) SetIUserValue(idx, n int) {
-	luaLib.ffi.LuaSetiuservalue(s.luaL, idx, n)
+func (s *State) SetIUserValue(idx, n int) bool {
+	if s.rt.ffi.LuaSetiuservalue == nil {
+		panic(s.rt.ffi.unsupported("lua_setiuservalue"))
+	}
+	return s.rt.ffi.LuaSetiuservalue(s.luaL, s.index(idx), n) != 0
 }
 
 // GetUserValue gets the first user value associated with the userdata at idx.
 // For most userdata, only one user value is used.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_getiuservalue
 func (s *State) GetUserValue(idx int) int {
+	if s.Version() < 503 {
+		// Lua 5.1 keeps the user value in the environment table of the userdata
+		s.rt.ffi.LuaGetfenv(s.luaL, s.index(idx))
+		return s.Type(-1)
+	}
 	if s.Version() < 504 {
-		return int(luaLib.ffi.LuaGetuservalue(s.luaL, idx))
+		if s.rt.ffi.LuaGetuservalue == nil {
+			panic(s.rt.ffi.unsupported("lua_getuservalue"))
+		}
+		return int(s.rt.ffi.LuaGetuservalue(s.luaL, s.index(idx)))
 	}
 	return s.GetIUserValue(idx, 1)
 }
 
-// SetUserValue sets the first user value of the userdata at idx.
+// SetUserValue sets the first user value of the userdata at idx with the value at the top of the stack,
+// and pops the value. Returns false if the value cannot be set.
+// With Lua 5.1 and LuaJIT the user value is the environment of the userdata, which must be a table,
+// and false is also returned when the value is not a table or idx is not a userdata.
 // See: https://www.lua.org/manual/5.4/manual.html#lua_setiuservalue
-func (s *State) SetUserValue(idx int) {
+func (s *State) SetUserValue(idx int) bool {
+	if s.Version() < 503 {
+		// Lua 5.1 keeps the user value in the environment table of the userdata
+		if s.Type(-1) != LUA_TTABLE {
+			s.Pop(1)
+			return false
+		}
+		return s.rt.ffi.LuaSetfenv(s.luaL, s.index(idx)) != 0
+	}
 	if s.Version() < 504 {
-		luaLib.ffi.LuaSetuservalue(s.luaL, idx)
-		return
+		if s.rt.ffi.LuaSetuservalue == nil {
+			panic(s.rt.ffi.unsupported("lua_setuservalue"))
+		}
+		s.rt.ffi.LuaSetuservalue(s.luaL, s.index(idx))
+		return true
 	}
-	luaLib.ffi.LuaSetiuservalue(s.luaL, idx, 1)
+	return s.SetIUserValue(idx, 1)
 }
 
 // CheckUserData checks that the value at ud is a userdata of the type given by tname and returns its pointer.
 // Raises an error if the type does not match.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_checkudata
 func (s *State) CheckUserData(ud int, tname string) (ptr unsafe.Pointer) {
-	tptr, _ := bytePtrFromString(tname)
-	return luaLib.ffi.LuaLCheckudata(s.luaL, ud, tptr)
+	tptr := s.rt.cString(tname)
+	return s.rt.ffi.LuaLCheckudata(s.luaL, s.index(ud), tptr)
+}
+
+// CheckUserDataT checks that the value at ud is a userdata of the type given by tname,
+// and returns it as a pointer to T. Raises an error if the type does not match.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_checkudata
+func CheckUserDataT[T any](L *State, ud int, tname string) *T {
+	return (*T)(L.CheckUserData(ud, tname))
+}
+
+// TestUserDataT is like TestUserData, returning the userdata as a pointer to T, or nil.
+// See: https://www.lua.org/manual/5.4/manual.html#luaL_testudata
+func TestUserDataT[T any](L *State, ud int, tname string) *T {
+	return (*T)(L.TestUserData(ud, tname))
 }
 
 // TestUserData tests whether the value at ud is a userdata of the type given by tname, returning its pointer or nil.
 // See: https://www.lua.org/manual/5.4/manual.html#luaL_testudata
 func (s *State) TestUserData(ud int, tname string) (ptr unsafe.Pointer) {
-	tptr, _ := bytePtrFromString(tname)
-	return luaLib.ffi.LuaLTestudata(s.luaL, ud, tptr)
+	if s.rt.ffi.version < 503 {
+		return s.testUserData51(ud, tname)
+	}
+	tptr := s.rt.cString(tname)
+	return s.rt.ffi.LuaLTestudata(s.luaL, s.index(ud), tptr)
 }